@@ -15,17 +15,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"text/tabwriter"
 	"time"
 
+	"github.com/containerd/containerd/platforms"
+	"github.com/dustin/go-humanize"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
+	"github.com/goharbor/acceleration-service/pkg/cache"
 	"github.com/goharbor/acceleration-service/pkg/client"
 	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/content"
 	"github.com/goharbor/acceleration-service/pkg/handler"
+	"github.com/goharbor/acceleration-service/pkg/model"
 )
 
 var versionTag string
@@ -41,6 +48,91 @@ func ellipsis(txt string, max int) string {
 	return txt
 }
 
+// withRemoteCache opens the remote cache of reference in command arguments, the
+// cache version and source registry auth are read from config. A temporary work
+// directory is used, so that it doesn't conflict with the running acceld.
+func withRemoteCache(c *cli.Context, fn func(ctx context.Context, rc *cache.RemoteCache) error) error {
+	ref := c.Args().First()
+	if ref == "" {
+		return fmt.Errorf("cache reference is required")
+	}
+
+	cfg, err := config.Parse(c.String("config"))
+	if err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp("", "accelctl-cache-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	cfg.Provider.WorkDir = workDir
+
+	provider, _, err := content.NewLocalProvider(cfg, platforms.All)
+	if err != nil {
+		return err
+	}
+
+	return content.WithRemoteCache(c.Context, provider, ref, fn)
+}
+
+// createTasks creates the asynchronous conversion tasks of images in file,
+// logs each task once it's created (or finished if wait), and prints a
+// summary table of tasks at the end.
+func createTasks(file string, opts client.BatchOptions) error {
+	wait := opts.Wait
+	entries, err := client.ParseBatchFile(file)
+	if err != nil {
+		return err
+	}
+
+	status := func(result client.BatchResult) string {
+		switch {
+		case result.Err != nil:
+			return "FAILED"
+		case wait:
+			return "COMPLETED"
+		default:
+			return "SUBMITTED"
+		}
+	}
+	var finished, failed int
+	results := ctl.CreateTasks(entries, opts, func(result client.BatchResult) {
+		finished++
+		if result.Err != nil {
+			failed++
+		}
+		logrus.Infof("[%d/%d] %s %s, %d succeeded, %d failed, %d pending",
+			finished, len(entries), status(result), result.Entry.Source, finished-failed, failed, len(entries)-finished)
+	})
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "SOURCE\tTARGET\tSTATUS\tELAPSED\tREASON")
+	for _, result := range results {
+		target := result.Entry.Target
+		if target == "" {
+			target = "-"
+		}
+		reason := ""
+		if result.Err != nil {
+			reason = ellipsis(result.Err.Error(), 80)
+		}
+		elapsed := result.Elapsed.Round(time.Millisecond)
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", ellipsis(result.Entry.Source, 80), ellipsis(target, 80), status(result), elapsed, reason)
+	}
+	writer.Flush()
+
+	fmt.Printf("Total %d, succeeded %d, failed %d\n", len(results), len(results)-failed, failed)
+	if !wait && failed < len(results) {
+		fmt.Println("Submitted asynchronous tasks, check status by `task list`.")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tasks failed", failed, len(results))
+	}
+
+	return nil
+}
+
 func main() {
 	logrus.SetFormatter(&logrus.TextFormatter{
 		FullTimestamp:   true,
@@ -71,9 +163,25 @@ func main() {
 						Usage: "Convert a source to an acceleration image",
 						Flags: []cli.Flag{
 							&cli.BoolFlag{Name: "sync", Value: false},
+							&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Convert the images listed in a text file (a source and optional target per line) or a yaml file"},
+							&cli.IntFlag{Name: "concurrency", Value: 4, Usage: "The max number of requests sent at the same time for the images in file"},
+							&cli.BoolFlag{Name: "wait", Usage: "Block until all the images in file are converted by polling the task list"},
+							&cli.DurationFlag{Name: "timeout", Usage: "The max duration of waiting for the images in file to be converted, no limit if zero"},
 						},
 						ArgsUsage: "[SOURCE]",
 						Action: func(c *cli.Context) error {
+							if file := c.String("file"); file != "" {
+								// The images in file are always converted asynchronously.
+								if c.Bool("sync") {
+									return fmt.Errorf("--sync can't be used with --file, use --wait instead")
+								}
+								return createTasks(file, client.BatchOptions{
+									Concurrency: c.Int("concurrency"),
+									Wait:        c.Bool("wait"),
+									Timeout:     c.Duration("timeout"),
+								})
+							}
+
 							source := c.Args().First()
 							if source == "" {
 								return fmt.Errorf("source is required")
@@ -84,7 +192,7 @@ func main() {
 								logrus.Info("Waiting task to be completed...")
 							}
 
-							if err := ctl.CreateTask(source, sync); err != nil {
+							if _, err := ctl.CreateTask(source, sync, nil); err != nil {
 								return err
 							}
 
@@ -122,11 +230,197 @@ func main() {
 					},
 				},
 			},
+			{
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "addr", Value: "localhost:2077", Usage: "Service address in format <host:port>."},
+				},
+				Before: func(c *cli.Context) error {
+					ctl = client.NewClient(c.String("addr"))
+					return nil
+				},
+				Name:  "backfill",
+				Usage: "Convert the existing images of registry in remote acceld",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "Enqueue the conversions of the images in registry matched by the conversion rules",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "project", Usage: "Only convert the repositories under the project (namespace)"},
+							&cli.StringFlag{Name: "repository", Usage: "Only convert the repository like library/nginx"},
+							&cli.StringFlag{Name: "tag-pattern", Usage: "Only convert the tags fully matched by the regular expression"},
+							&cli.StringFlag{Name: "max-age", Usage: "Skip the images pushed (or created) earlier than the duration like 720h"},
+						},
+						ArgsUsage: "[REGISTRY]",
+						Action: func(c *cli.Context) error {
+							registry := c.Args().First()
+							if registry == "" {
+								return fmt.Errorf("registry is required")
+							}
+
+							job, err := ctl.CreateBackfill(model.BackfillRequest{
+								Registry:   registry,
+								Project:    c.String("project"),
+								Repository: c.String("repository"),
+								TagPattern: c.String("tag-pattern"),
+								MaxAge:     c.String("max-age"),
+							})
+							if err != nil {
+								return err
+							}
+							logrus.Infof("Created backfill job %s, check progress by `backfill list`.", job.ID)
+
+							return nil
+						},
+					},
+					{
+						Name:    "list",
+						Aliases: []string{"ls"},
+						Usage:   "List backfill jobs with progress",
+						Action: func(c *cli.Context) error {
+							jobs, err := ctl.ListBackfills()
+							if err != nil {
+								return err
+							}
+
+							writer := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', tabwriter.AlignRight)
+							fmt.Fprintln(writer, "ID\tCREATED\tSTATUS\tREGISTRY\tREPOSITORIES\tENQUEUED\tSKIPPED\tFAILED\tREASON")
+							for _, job := range jobs {
+								created := job.Created.Format("2006-01-02 15:04:05")
+								registry := job.Request.Registry
+								if job.Request.Repository != "" {
+									registry += "/" + job.Request.Repository
+								} else if job.Request.Project != "" {
+									registry += "/" + job.Request.Project
+								}
+								repositories := fmt.Sprintf("%d/%d", job.Processed, job.Repositories)
+								reason := ellipsis(job.Reason, 80)
+								fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n", job.ID, created, job.Status, ellipsis(registry, 80), repositories, job.Enqueued, job.Skipped, job.Failed, reason)
+							}
+							writer.Flush()
+
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "addr", Value: "localhost:2077", Usage: "Service address in format <host:port>."},
+				},
+				Before: func(c *cli.Context) error {
+					ctl = client.NewClient(c.String("addr"))
+					return nil
+				},
+				Name:  "chunkdict",
+				Usage: "Manage nydus chunk dict image in remote acceld",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "build",
+						Usage:     "Build chunk dict image from the most frequently converted images",
+						ArgsUsage: "[REPOSITORY]",
+						Action: func(c *cli.Context) error {
+							repository := c.Args().First()
+
+							logrus.Info("Waiting chunk dict to be built...")
+							if err := ctl.BuildChunkDict(repository); err != nil {
+								return err
+							}
+							logrus.Info("Chunk dict has been built.")
+
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "config", Required: true, Usage: "Specify the path of config in yaml format"},
+				},
+				Name:  "cache",
+				Usage: "Inspect and maintain remote cache image",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "inspect",
+						Usage:     "List the records of remote cache",
+						ArgsUsage: "[REF]",
+						Action: func(c *cli.Context) error {
+							return withRemoteCache(c, func(ctx context.Context, rc *cache.RemoteCache) error {
+								records, err := rc.Records(ctx)
+								if err != nil {
+									return err
+								}
+
+								writer := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', tabwriter.AlignRight)
+								fmt.Fprintln(writer, "PLATFORM\tVERSION\tDRIVER\tSOURCE\tSOURCE SIZE\tTARGET\tTARGET SIZE\tHITS\tLAST HIT")
+								for _, record := range records {
+									sourceSize := "-"
+									if record.SourceSize > 0 {
+										sourceSize = humanize.Bytes(uint64(record.SourceSize))
+									}
+									lastHit := "-"
+									if !record.LastHit.IsZero() {
+										lastHit = record.LastHit.Local().Format("2006-01-02 15:04:05")
+									}
+									fmt.Fprintf(
+										writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+										record.Platform, record.Version, record.Driver,
+										record.Source, sourceSize,
+										record.Target.Digest, humanize.Bytes(uint64(record.Target.Size)),
+										record.HitCount, lastHit,
+									)
+								}
+								writer.Flush()
+
+								return nil
+							})
+						},
+					},
+					{
+						Name:      "prune",
+						Usage:     "Remove the records of which the blob doesn't exist or cache version is unmatched",
+						ArgsUsage: "[REF]",
+						Action: func(c *cli.Context) error {
+							return withRemoteCache(c, func(ctx context.Context, rc *cache.RemoteCache) error {
+								pruned, err := rc.Prune(ctx)
+								if err != nil {
+									return err
+								}
+								logrus.Infof("Pruned %d cache records.", pruned)
+								return nil
+							})
+						},
+					},
+					{
+						Name:  "migrate",
+						Usage: "Rewrite the remote cache of configured cache version to a new version",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "to-version", Required: true, Usage: "The new cache version"},
+						},
+						ArgsUsage: "[REF]",
+						Action: func(c *cli.Context) error {
+							return withRemoteCache(c, func(ctx context.Context, rc *cache.RemoteCache) error {
+								migrated, err := rc.Migrate(ctx, c.String("to-version"))
+								if err != nil {
+									return err
+								}
+								logrus.Infof("Migrated %d cache manifests to version %s.", migrated, c.String("to-version"))
+								return nil
+							})
+						},
+					},
+				},
+			},
 			{
 				Name:  "convert",
 				Usage: "Convert an image locally (one-time mode)",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "config", Required: true, Usage: "Specify the path of config in yaml format"},
+					&cli.StringFlag{Name: "archive-source", Usage: "Convert the image from OCI layout directory or tar archive instead of registry, the SOURCE is the image name in archive"},
+					&cli.StringFlag{Name: "archive-target", Usage: "Write the converted image to tar archive if ends with .tar, otherwise OCI layout directory"},
+					&cli.StringFlag{Name: "containerd-address", Usage: "Convert the image in containerd instead of registry, and import the converted image back"},
+					&cli.StringFlag{Name: "namespace", Usage: "Specify the containerd namespace of images"},
+					&cli.BoolFlag{Name: "push", Usage: "Push the converted image to registry after importing to containerd"},
+					&cli.BoolFlag{Name: "dry-run", Usage: "Print what the conversion would do in JSON, without converting or pushing anything"},
 				},
 				ArgsUsage: "[SOURCE]",
 				Action: func(c *cli.Context) error {
@@ -139,13 +433,39 @@ func main() {
 					if err != nil {
 						return err
 					}
+					if c.IsSet("archive-source") {
+						cfg.Provider.Archive.Source = c.String("archive-source")
+					}
+					if c.IsSet("archive-target") {
+						cfg.Provider.Archive.Target = c.String("archive-target")
+					}
+					if c.IsSet("containerd-address") {
+						cfg.Provider.Containerd.Address = c.String("containerd-address")
+					}
+					if c.IsSet("namespace") {
+						cfg.Provider.Containerd.Namespace = c.String("namespace")
+					}
+					if c.IsSet("push") {
+						cfg.Provider.Containerd.Push = c.Bool("push")
+					}
 
 					handler, err := handler.NewLocalHandler(cfg)
 					if err != nil {
 						return err
 					}
 
-					return handler.Convert(c.Context, source, true)
+					if c.Bool("dry-run") {
+						plan, err := handler.Plan(c.Context, source, nil)
+						if err != nil {
+							return err
+						}
+						encoder := json.NewEncoder(os.Stdout)
+						encoder.SetIndent("", "  ")
+						return encoder.Encode(plan)
+					}
+
+					_, err = handler.Convert(c.Context, source, true, nil)
+					return err
 				},
 			},
 		},
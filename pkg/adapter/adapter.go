@@ -17,19 +17,26 @@ package adapter
 import (
 	"context"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/singleflight"
 
+	"github.com/goharbor/acceleration-service/pkg/backfill"
 	"github.com/goharbor/acceleration-service/pkg/config"
 	"github.com/goharbor/acceleration-service/pkg/content"
 	"github.com/goharbor/acceleration-service/pkg/converter"
 	"github.com/goharbor/acceleration-service/pkg/errdefs"
 	"github.com/goharbor/acceleration-service/pkg/metrics"
+	"github.com/goharbor/acceleration-service/pkg/model"
 	"github.com/goharbor/acceleration-service/pkg/platformutil"
+	"github.com/goharbor/acceleration-service/pkg/remote"
 	"github.com/goharbor/acceleration-service/pkg/task"
 )
 
@@ -40,19 +47,63 @@ type Adapter interface {
 	// by specifying source image reference, the conversion is
 	// asynchronous, and if the sync option is specified,
 	// Dispatch will be blocked until the conversion is complete.
-	Dispatch(ctx context.Context, ref string, sync bool) error
+	// The overrides of request replace the configured driver config,
+	// platforms and target reference if not nil. The ID of created
+	// task is returned.
+	Dispatch(ctx context.Context, ref string, sync bool, overrides *model.Overrides) (string, error)
+	// Plan reports what the conversion of source image reference
+	// would do, without converting or pushing anything.
+	Plan(ctx context.Context, ref string, overrides *model.Overrides) (*converter.Plan, error)
 	// CheckHealth checks the containerd client can successfully
 	// connect to the containerd daemon and the healthcheck service
 	// returns the SERVING response.
 	CheckHealth(ctx context.Context) error
+	// BuildChunkDict builds a chunk dict image from the most frequently
+	// converted images of the repository (or project), and updates the
+	// chunk dict used by subsequent conversions.
+	BuildChunkDict(ctx context.Context, repository string) error
+	// Backfill creates a job enqueuing the conversions of the existing
+	// images in registry, which match the conversion rules and filters.
+	Backfill(ctx context.Context, req model.BackfillRequest) (*backfill.Job, error)
+	// ListBackfills lists the backfill jobs.
+	ListBackfills() []*backfill.Job
+	// ResumeBackfills resumes the backfill jobs interrupted by the
+	// last exit, it's only called by the long-running daemon.
+	ResumeBackfills()
 }
 
 type LocalAdapter struct {
-	cfg     *config.Config
-	rule    *Rule
-	worker  *Worker
-	cvt     *converter.Converter
+	cfg      *config.Config
+	rule     *Rule
+	worker   *Worker
+	cvt      *converter.Converter
+	provider content.Provider
+	// content is the local content store, nil if the content is managed
+	// externally like containerd.
 	content *content.Content
+	// pushRegistry is true if the target image is pushed to registry.
+	pushRegistry bool
+	platformMC   platforms.MatchComparer
+
+	convertersMutex sync.Mutex
+	// converters caches the converters of request overrides by config hash.
+	converters map[digest.Digest]*converter.Converter
+	// chunkDictRef is the chunk dict image built at runtime, it's applied
+	// to the converters of request overrides as well.
+	chunkDictRef string
+	backfill     *backfill.Manager
+}
+
+func newConverter(cfg *config.Config, provider content.Provider, driverConfig map[string]string, platformMC platforms.MatchComparer) (*converter.Converter, error) {
+	opts := []converter.ConvertOpt{
+		converter.WithProvider(provider),
+		converter.WithDriver(cfg.Converter.Driver.Type, driverConfig),
+		converter.WithPlatform(platformMC),
+	}
+	if cfg.Converter.Verify.Enabled {
+		opts = append(opts, converter.WithVerify(cfg.Converter.Verify.CompareFS))
+	}
+	return converter.New(opts...)
 }
 
 func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
@@ -62,20 +113,33 @@ func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
 		return nil, errors.Wrap(err, "invalid platform configuration")
 	}
 
-	provider, content, err := content.NewLocalProvider(cfg, platformMC)
+	newProvider := content.NewLocalProvider
+	pushRegistry := true
+	if cfg.Provider.Containerd.Address != "" {
+		newProvider = content.NewContainerdProvider
+		pushRegistry = cfg.Provider.Containerd.Push
+	} else if cfg.Provider.Archive.Source != "" {
+		newProvider = content.NewArchiveProvider
+		pushRegistry = false
+	}
+	provider, content, err := newProvider(cfg, platformMC)
 	if err != nil {
 		return nil, errors.Wrap(err, "create content provider")
 	}
 	// start scheduled gc task every hour
-	go startScheduledGC(content)
-	cvt, err := converter.New(
-		converter.WithProvider(provider),
-		converter.WithDriver(cfg.Converter.Driver.Type, cfg.Converter.Driver.Config),
-		converter.WithPlatform(platformMC),
-	)
+	if content != nil {
+		go startScheduledGC(content)
+	}
+	cvt, err := newConverter(cfg, provider, cfg.Converter.Driver.Config, platformMC)
 	if err != nil {
 		return nil, err
 	}
+	// The chunk dict image built before restart is used at once, instead
+	// of waiting for the next build.
+	chunkDictRef := cfg.Converter.ChunkDict.Ref
+	if chunkDictRef != "" {
+		cvt.SetChunkDictRef(chunkDictRef)
+	}
 
 	if err := task.Manager.Init(cfg.Provider.WorkDir); err != nil {
 		return nil, errors.Wrap(err, "task manager init")
@@ -91,11 +155,29 @@ func NewLocalAdapter(cfg *config.Config) (*LocalAdapter, error) {
 	}
 
 	handler := &LocalAdapter{
-		cfg:     cfg,
-		rule:    rule,
-		worker:  worker,
-		cvt:     cvt,
-		content: content,
+		cfg:          cfg,
+		rule:         rule,
+		worker:       worker,
+		cvt:          cvt,
+		provider:     provider,
+		content:      content,
+		pushRegistry: pushRegistry,
+		platformMC:   platformMC,
+		converters:   make(map[digest.Digest]*converter.Converter),
+		chunkDictRef: chunkDictRef,
+	}
+
+	if handler.backfill, err = newBackfillManager(handler); err != nil {
+		return nil, errors.Wrap(err, "backfill manager init")
+	}
+
+	if interval := cfg.Converter.ChunkDict.Interval; interval != "" && cfg.Converter.ChunkDict.Ref != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid chunk dict interval")
+		}
+		// start scheduled chunk dict build task
+		go startScheduledChunkDict(handler, duration)
 	}
 
 	return handler, nil
@@ -108,72 +190,191 @@ func startScheduledGC(content *content.Content) {
 	}
 }
 
-func (adp *LocalAdapter) Convert(ctx context.Context, source string) (*converter.Metric, error) {
-	target, err := adp.rule.Map(source, TagSuffix)
+func (adp *LocalAdapter) Convert(ctx context.Context, source string, overrides *model.Overrides) (*converter.Metric, error) {
+	cvt, err := adp.converter(overrides)
+	if err != nil {
+		return nil, err
+	}
+	target, cacheRef, mountRef, err := adp.refs(source, overrides)
 	if err != nil {
 		if errors.Is(err, errdefs.ErrAlreadyConverted) {
 			logrus.Infof("image has been converted: %s", source)
 			return nil, nil
 		}
-		return nil, errors.Wrap(err, "create target reference by rule")
-	}
-	cacheRef, err := adp.rule.Map(source, CacheTag)
-	if err != nil {
 		if errors.Is(err, errdefs.ErrSameTag) {
 			logrus.Infof("image was remote cache: %s", source)
 			return nil, nil
 		}
-		return nil, errors.Wrap(err, "create cache reference by rule")
+		return nil, err
+	}
+	ctx = converter.WithMountFrom(ctx, mountRef)
+	if adp.content != nil {
+		adp.content.GcMutex.RLock()
+		defer adp.content.GcMutex.RUnlock()
 	}
-	adp.content.GcMutex.RLock()
-	defer adp.content.GcMutex.RUnlock()
-	metric, err := adp.cvt.Convert(ctx, source, target, cacheRef)
+	metric, err := cvt.Convert(ctx, source, target, cacheRef)
 	if err != nil {
 		if errdefs.NeedsRetryWithoutCache(err) && cacheRef != "" {
 			logrus.Infof("inconsistent layer format with the cache, retry conversion without cache: %s", cacheRef)
-			if _, err := adp.cvt.Convert(ctx, source, target, ""); err != nil {
+			if _, err := cvt.Convert(ctx, source, target, ""); err != nil {
 				return nil, err
 			}
 		}
 		return nil, err
 	}
-	go adp.content.GC(ctx, adp.content.Threshold)
+	if adp.content != nil {
+		go adp.content.GC(ctx, adp.content.Threshold)
+	}
 	return metric, nil
 }
 
-func (adp *LocalAdapter) Dispatch(ctx context.Context, ref string, sync bool) error {
+func (adp *LocalAdapter) Plan(ctx context.Context, source string, overrides *model.Overrides) (*converter.Plan, error) {
+	cvt, err := adp.converter(overrides)
+	if err != nil {
+		return nil, err
+	}
+	target, cacheRef, mountRef, err := adp.refs(source, overrides)
+	if err != nil {
+		if errors.Is(err, errdefs.ErrAlreadyConverted) {
+			return &converter.Plan{Source: source, Skipped: "image has been converted"}, nil
+		}
+		if errors.Is(err, errdefs.ErrSameTag) {
+			return &converter.Plan{Source: source, Skipped: "image was remote cache"}, nil
+		}
+		return nil, err
+	}
+	if adp.content != nil {
+		adp.content.GcMutex.RLock()
+		defer adp.content.GcMutex.RUnlock()
+	}
+	plan, err := cvt.Plan(namespaces.WithNamespace(ctx, "acceleration-service"), source, target, cacheRef)
+	if err != nil {
+		return nil, err
+	}
+	plan.MountFrom = mountRef
+	if adp.pushRegistry {
+		if err := remote.CheckPush(ctx, plan.Target, adp.cfg.Host); err != nil {
+			plan.PushError = err.Error()
+		}
+	}
+	return plan, nil
+}
+
+// target returns the target reference overridden by request, or
+// created by rules. The overridden target must be under a host of
+// `provider.source` and differ from the source, and the converted
+// image is skipped as without override.
+func (adp *LocalAdapter) target(source string, overrides *model.Overrides) (string, error) {
+	target, err := adp.rule.Map(source, TagSuffix)
+	if overrides == nil || overrides.Target == "" || errors.Is(err, errdefs.ErrAlreadyConverted) {
+		return target, err
+	}
+
+	sourceNamed, err := docker.ParseDockerRef(source)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source image reference")
+	}
+	targetNamed, err := docker.ParseDockerRef(overrides.Target)
+	if err != nil {
+		return "", errors.Wrapf(errdefs.ErrIllegalParameter, "invalid target reference %s: %s", overrides.Target, err)
+	}
+	if targetNamed.String() == sourceNamed.String() {
+		return "", errors.Wrapf(errdefs.ErrIllegalParameter, "target reference %s is the same as source", overrides.Target)
+	}
+	if _, ok := adp.cfg.Provider.Source[docker.Domain(targetNamed)]; !ok {
+		return "", errors.Wrapf(errdefs.ErrIllegalParameter, "target host %s isn't configured in provider.source", docker.Domain(targetNamed))
+	}
+
+	return overrides.Target, nil
+}
+
+// refs returns the target, remote cache and mount references of source image,
+// the cache and mount references are derived from the target overridden by
+// request if any. ErrAlreadyConverted is returned for the converted image, and
+// ErrSameTag for the remote cache image.
+func (adp *LocalAdapter) refs(source string, overrides *model.Overrides) (string, string, string, error) {
+	target, err := adp.target(source, overrides)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "create target reference by rule")
+	}
+	cacheRef, err := adp.rule.Map(source, CacheTag)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "create cache reference by rule")
+	}
+	mountRef, err := adp.rule.Map(source, MountFrom)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "create mount reference by rule")
+	}
+	if overrides == nil || overrides.Target == "" {
+		return target, cacheRef, mountRef, nil
+	}
+
+	if cacheRef, err = adp.rule.MapOverride(source, target, CacheTag); err != nil {
+		if errors.Is(err, errdefs.ErrSameTag) {
+			return "", "", "", errors.Wrapf(errdefs.ErrIllegalParameter, "target reference %s is the remote cache", target)
+		}
+		return "", "", "", errors.Wrap(err, "create cache reference by rule")
+	}
+	if mountRef, err = adp.rule.MapOverride(source, target, MountFrom); err != nil {
+		return "", "", "", errors.Wrap(err, "create mount reference by rule")
+	}
+
+	return target, cacheRef, mountRef, nil
+}
+
+func (adp *LocalAdapter) Dispatch(ctx context.Context, ref string, sync bool, overrides *model.Overrides) (string, error) {
+	return adp.dispatch(ctx, ref, sync, overrides, nil)
+}
+
+// dispatch creates the conversion task, done is called once the task
+// finishes in worker if it's not nil and the task is dispatched.
+func (adp *LocalAdapter) dispatch(ctx context.Context, ref string, sync bool, overrides *model.Overrides, done func()) (string, error) {
+	// Check the overrides before the task is created.
+	if _, err := adp.converter(overrides); err != nil {
+		return "", err
+	}
+	if _, _, _, err := adp.refs(ref, overrides); errors.Is(err, errdefs.ErrIllegalParameter) {
+		return "", err
+	}
 	taskID, err := task.Manager.Create(ref)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if sync {
 		// FIXME: The synchronous conversion task should also be
 		// executed in a limited worker queue.
-		_, err := metrics.Conversion.OpWrap(func() (*converter.Metric, error) {
-			metric, err := adp.Convert(namespaces.WithNamespace(ctx, "acceleration-service"), ref)
+		return taskID, metrics.Conversion.OpWrap(func() error {
+			metric, err := adp.Convert(namespaces.WithNamespace(ctx, "acceleration-service"), ref, overrides)
 			task.Manager.Finish(taskID, metric, err)
-			return nil, err
+			return err
 		}, "convert")
-		return err
 	}
 
 	adp.worker.Dispatch(func() error {
-		// If the ref is same, we only convert once in the same time.
-		metric, err, _ := dispatchSingleflight.Do(ref, func() (interface{}, error) {
-			metric, err := metrics.Conversion.OpWrap(func() (*converter.Metric, error) {
-				metric, err := adp.Convert(namespaces.WithNamespace(context.Background(), "acceleration-service"), ref)
-				return metric, err
+		// If the ref and overrides are same, we only convert once in the same time.
+		metric, err, _ := dispatchSingleflight.Do(ref+overrideKey(overrides), func() (interface{}, error) {
+			var metric *converter.Metric
+			err := metrics.Conversion.OpWrap(func() error {
+				var err error
+				metric, err = adp.Convert(namespaces.WithNamespace(context.Background(), "acceleration-service"), ref, overrides)
+				return err
 			}, "convert")
 			return metric, err
 		})
 		task.Manager.Finish(taskID, metric.(*converter.Metric), err)
+		if done != nil {
+			done()
+		}
 		return err
 	})
 
-	return nil
+	return taskID, nil
 }
 
-func (adp *LocalAdapter) CheckHealth(_ context.Context) error {
+func (adp *LocalAdapter) CheckHealth(ctx context.Context) error {
+	if checker, ok := adp.provider.(content.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
 	_, err := adp.content.Size()
 	return err
 }
@@ -0,0 +1,113 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/backfill"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+)
+
+func newBackfillManager(adp *LocalAdapter) (*backfill.Manager, error) {
+	return backfill.NewManager(backfill.Options{
+		WorkDir:     adp.cfg.Provider.WorkDir,
+		Rate:        adp.cfg.Converter.Backfill.Rate,
+		Concurrency: adp.cfg.Converter.Worker,
+		Host:        adp.cfg.Host,
+		Harbor: func(host string) bool {
+			return adp.cfg.Provider.Source[host].Harbor
+		},
+		Target:  adp.backfillTarget,
+		Created: adp.imageCreated,
+		Dispatch: func(ctx context.Context, ref string, done func()) error {
+			_, err := adp.dispatch(ctx, ref, false, nil, done)
+			return err
+		},
+	})
+}
+
+// backfillTarget returns the target reference of source image by rules,
+// an error is returned for the converted and cache images.
+func (adp *LocalAdapter) backfillTarget(source string) (string, error) {
+	target, err := adp.rule.Map(source, TagSuffix)
+	if err != nil {
+		return "", err
+	}
+	if _, err := adp.rule.Map(source, CacheTag); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// imageCreated returns the creation time in the image config of the
+// default platform, only the manifests and configs are fetched.
+func (adp *LocalAdapter) imageCreated(ctx context.Context, ref string) (time.Time, error) {
+	ctx = namespaces.WithNamespace(ctx, "acceleration-service")
+	if adp.content != nil {
+		adp.content.GcMutex.RLock()
+		defer adp.content.GcMutex.RUnlock()
+	}
+
+	desc, err := adp.provider.Resolve(ctx, ref)
+	if errdefs.NeedsRetryWithHTTP(err) {
+		if err = adp.provider.UsePlainHTTP(ref); err == nil {
+			desc, err = adp.provider.Resolve(ctx, ref)
+		}
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "resolve image")
+	}
+	manifest, err := images.Manifest(ctx, adp.provider.ContentStore(), *desc, adp.platformMC)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "get image manifest")
+	}
+	var config ocispec.Image
+	if _, err := utils.ReadJSON(ctx, adp.provider.ContentStore(), &config, manifest.Config); err != nil {
+		return time.Time{}, errors.Wrap(err, "read image config")
+	}
+	if config.Created == nil {
+		return time.Time{}, errors.New("creation time isn't found in image config")
+	}
+	return *config.Created, nil
+}
+
+// Backfill creates a backfill job enqueuing the conversions of the
+// existing images in registry.
+func (adp *LocalAdapter) Backfill(_ context.Context, req model.BackfillRequest) (*backfill.Job, error) {
+	if adp.cfg.Provider.Archive.Source != "" {
+		return nil, errors.Wrap(errdefs.ErrIllegalParameter, "backfill isn't supported by archive provider")
+	}
+	if adp.cfg.Provider.Containerd.Address != "" {
+		return nil, errors.Wrap(errdefs.ErrIllegalParameter, "backfill isn't supported by containerd provider")
+	}
+	return adp.backfill.Create(req)
+}
+
+func (adp *LocalAdapter) ListBackfills() []*backfill.Job {
+	return adp.backfill.List()
+}
+
+func (adp *LocalAdapter) ResumeBackfills() {
+	adp.backfill.Resume()
+}
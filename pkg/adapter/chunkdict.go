@@ -0,0 +1,151 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/metrics"
+	"github.com/goharbor/acceleration-service/pkg/task"
+)
+
+const defaultChunkDictLimit = 10
+
+var chunkDictSingleflight = &singleflight.Group{}
+
+func startScheduledChunkDict(adp *LocalAdapter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		if err := adp.BuildChunkDict(context.Background(), ""); err != nil {
+			logrus.WithError(err).Error("scheduled chunk dict build")
+		}
+	}
+}
+
+// selectChunkDictImages picks the most frequently converted images under
+// the repository (or project) from the task history, and returns the
+// references of the converted images.
+func selectChunkDictImages(tasks []*task.Task, repository string, limit int, rule *Rule) ([]string, error) {
+	repository = strings.TrimSuffix(repository, "/")
+
+	type candidate struct {
+		source   string
+		count    int
+		finished time.Time
+	}
+	candidates := map[string]*candidate{}
+	for _, t := range tasks {
+		if t.Status != task.StatusCompleted {
+			continue
+		}
+		named, err := docker.ParseDockerRef(t.Source)
+		if err != nil {
+			continue
+		}
+		if repository != "" && named.Name() != repository && !strings.HasPrefix(named.Name(), repository+"/") {
+			continue
+		}
+		source := named.String()
+		if c, ok := candidates[source]; ok {
+			c.count++
+			if t.Finished.After(c.finished) {
+				c.finished = t.Finished
+			}
+		} else {
+			candidates[source] = &candidate{source: source, count: 1, finished: t.Finished}
+		}
+	}
+
+	sorted := make([]*candidate, 0, len(candidates))
+	for _, c := range candidates {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].finished.After(sorted[j].finished)
+	})
+
+	images := []string{}
+	for _, c := range sorted {
+		if len(images) >= limit {
+			break
+		}
+		target, err := rule.Map(c.source, TagSuffix)
+		if err != nil {
+			if errors.Is(err, errdefs.ErrAlreadyConverted) {
+				images = append(images, c.source)
+				continue
+			}
+			return nil, errors.Wrapf(err, "create target reference for %s", c.source)
+		}
+		images = append(images, target)
+	}
+
+	return images, nil
+}
+
+// BuildChunkDict builds a chunk dict image from the most frequently converted
+// images of the repository, the configured repository is used if empty.
+func (adp *LocalAdapter) BuildChunkDict(ctx context.Context, repository string) error {
+	cfg := adp.cfg.Converter.ChunkDict
+	if cfg.Ref == "" {
+		return errors.Wrap(errdefs.ErrIllegalParameter, "chunk dict reference isn't configured")
+	}
+	if repository == "" {
+		repository = cfg.Repository
+	}
+	limit := cfg.Limit
+	if limit <= 0 {
+		limit = defaultChunkDictLimit
+	}
+
+	images, err := selectChunkDictImages(task.Manager.List(), repository, limit, adp.rule)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		logrus.Infof("no converted image found in %q for chunk dict", repository)
+		return nil
+	}
+
+	// Only one chunk dict build at the same time.
+	_, err, _ = chunkDictSingleflight.Do(cfg.Ref, func() (interface{}, error) {
+		return nil, metrics.ChunkDict.OpWrap(func() error {
+			if adp.content != nil {
+				adp.content.GcMutex.RLock()
+				defer adp.content.GcMutex.RUnlock()
+			}
+			return adp.cvt.BuildChunkDict(namespaces.WithNamespace(ctx, "acceleration-service"), images, cfg.Ref)
+		}, "build")
+	})
+	if err != nil {
+		return err
+	}
+	adp.setChunkDictRef(cfg.Ref)
+
+	return nil
+}
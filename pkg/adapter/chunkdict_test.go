@@ -0,0 +1,54 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/task"
+)
+
+func TestSelectChunkDictImages(t *testing.T) {
+	now := time.Now()
+	completed := func(source string, finished time.Time) *task.Task {
+		return &task.Task{Source: source, Status: task.StatusCompleted, Finished: finished}
+	}
+	tasks := []*task.Task{
+		completed("192.168.1.1/library/nginx:latest", now),
+		completed("192.168.1.1/library/nginx:latest", now),
+		completed("192.168.1.1/library/redis:7", now.Add(-time.Hour)),
+		completed("192.168.1.1/library/busybox:latest", now),
+		completed("192.168.1.1/other/nginx:latest", now),
+		completed("192.168.1.1/library/alpine:latest-nydus", now.Add(-2*time.Hour)),
+		{Source: "192.168.1.1/library/ubuntu:latest", Status: task.StatusFailed, Finished: now},
+	}
+	rule := &Rule{items: []config.ConversionRule{{TagSuffix: "-nydus"}}}
+
+	images, err := selectChunkDictImages(tasks, "192.168.1.1/library/", 3, rule)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"192.168.1.1/library/nginx:latest-nydus",
+		"192.168.1.1/library/busybox:latest-nydus",
+		"192.168.1.1/library/redis:7-nydus",
+	}, images)
+
+	images, err = selectChunkDictImages(tasks, "192.168.1.1/library/alpine", 3, rule)
+	require.NoError(t, err)
+	require.Equal(t, []string{"192.168.1.1/library/alpine:latest-nydus"}, images)
+}
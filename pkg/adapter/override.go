@@ -0,0 +1,134 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/platformutil"
+)
+
+// overridableDriverConfig is the allowlist of driver config keys which can
+// be overridden by conversion request, the others like `work_dir`, `builder`
+// and `backend_config` are only configured by the operator.
+var overridableDriverConfig = map[string]bool{
+	"fs_version":        true,
+	"compressor":        true,
+	"fs_chunk_size":     true,
+	"prefetch_patterns": true,
+	"docker2oci":        true,
+}
+
+// maxOverrideConverters is the max number of cached converters for the
+// overrides, an arbitrary one is dropped once exceeded.
+const maxOverrideConverters = 16
+
+// overrideKey returns the key of overrides to deduplicate the conversions of
+// the same source, empty for no overrides.
+func overrideKey(overrides *model.Overrides) string {
+	if overrides == nil {
+		return ""
+	}
+	// The keys of map are sorted in JSON.
+	data, _ := json.Marshal(overrides)
+	return digest.FromBytes(data).String()
+}
+
+// converter returns the converter of the driver config and platforms overridden
+// by the request, the converters are cached by the hash of config, so that the
+// driver isn't recreated for each request.
+func (adp *LocalAdapter) converter(overrides *model.Overrides) (*converter.Converter, error) {
+	if overrides == nil || (len(overrides.DriverConfig) == 0 && overrides.Platforms == "") {
+		return adp.cvt, nil
+	}
+
+	driverConfig := make(map[string]string)
+	for key, value := range adp.cfg.Converter.Driver.Config {
+		driverConfig[key] = value
+	}
+	for key, value := range overrides.DriverConfig {
+		if !overridableDriverConfig[key] {
+			return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "driver config %s isn't allowed to override", key)
+		}
+		driverConfig[key] = value
+	}
+
+	platformMC := adp.platformMC
+	if overrides.Platforms != "" {
+		specs, err := platformutil.NewOCISpecPlatformSlice(false, strings.Split(overrides.Platforms, ","))
+		if err != nil {
+			return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "invalid platforms %s: %s", overrides.Platforms, err)
+		}
+		// The provider only pulls the platforms of `converter.platforms`.
+		for _, spec := range specs {
+			if !adp.platformMC.Match(spec) {
+				return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "platform %s isn't in converter.platforms", platforms.Format(spec))
+			}
+		}
+		platformMC = platforms.Ordered(specs...)
+	}
+
+	data, err := json.Marshal(struct {
+		DriverConfig map[string]string
+		Platforms    string
+	}{driverConfig, overrides.Platforms})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal overrides")
+	}
+	hash := digest.FromBytes(data)
+
+	adp.convertersMutex.Lock()
+	defer adp.convertersMutex.Unlock()
+
+	if cvt, ok := adp.converters[hash]; ok {
+		return cvt, nil
+	}
+	cvt, err := newConverter(adp.cfg, adp.provider, driverConfig, platformMC)
+	if err != nil {
+		return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "create converter for overrides: %s", err)
+	}
+	if adp.chunkDictRef != "" {
+		cvt.SetChunkDictRef(adp.chunkDictRef)
+	}
+	if len(adp.converters) >= maxOverrideConverters {
+		for key := range adp.converters {
+			delete(adp.converters, key)
+			break
+		}
+	}
+	adp.converters[hash] = cvt
+
+	return cvt, nil
+}
+
+// setChunkDictRef updates the chunk dict image of the converters of request
+// overrides, the default converter is updated by the chunk dict build.
+func (adp *LocalAdapter) setChunkDictRef(ref string) {
+	adp.convertersMutex.Lock()
+	defer adp.convertersMutex.Unlock()
+
+	adp.chunkDictRef = ref
+	for _, cvt := range adp.converters {
+		cvt.SetChunkDictRef(ref)
+	}
+}
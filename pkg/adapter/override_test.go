@@ -0,0 +1,112 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package adapter
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+)
+
+func TestConverterOverrides(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Converter.Driver.Type = "nydus"
+	cfg.Converter.Driver.Config = map[string]string{"work_dir": t.TempDir()}
+	platformMC := platforms.Ordered(platforms.MustParse("linux/amd64"), platforms.MustParse("linux/arm64"))
+	adp := &LocalAdapter{
+		cfg:        cfg,
+		cvt:        &converter.Converter{},
+		platformMC: platformMC,
+		converters: make(map[digest.Digest]*converter.Converter),
+	}
+
+	cvt, err := adp.converter(nil)
+	require.NoError(t, err)
+	require.Same(t, adp.cvt, cvt)
+	cvt, err = adp.converter(&model.Overrides{Target: "192.168.1.1/nginx:custom"})
+	require.NoError(t, err)
+	require.Same(t, adp.cvt, cvt)
+
+	// The converters are cached by the overridden config.
+	cvt, err = adp.converter(&model.Overrides{DriverConfig: map[string]string{"fs_version": "5"}})
+	require.NoError(t, err)
+	require.NotSame(t, adp.cvt, cvt)
+	cached, err := adp.converter(&model.Overrides{DriverConfig: map[string]string{"fs_version": "5"}})
+	require.NoError(t, err)
+	require.Same(t, cvt, cached)
+	other, err := adp.converter(&model.Overrides{DriverConfig: map[string]string{"fs_version": "5"}, Platforms: "linux/arm64"})
+	require.NoError(t, err)
+	require.NotSame(t, cvt, other)
+
+	_, err = adp.converter(&model.Overrides{DriverConfig: map[string]string{"builder": "/tmp/nydus-image"}})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+	_, err = adp.converter(&model.Overrides{Platforms: "linux/s390x"})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+}
+
+func TestOverrideTarget(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Provider.Source = map[string]config.SourceConfig{
+		"192.168.1.1": {},
+		"192.168.1.2": {},
+	}
+	adp := &LocalAdapter{
+		cfg: cfg,
+		rule: &Rule{items: []config.ConversionRule{{
+			Source:    "192.168.1.1",
+			TagSuffix: "-nydus",
+			CacheTag:  "nydus-cache",
+			BlobCopy:  config.BlobCopyMount,
+			MountFrom: "192.168.1.1/proxy",
+		}}},
+	}
+
+	target, err := adp.target("192.168.1.1/nginx:latest", nil)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/nginx:latest-nydus", target)
+
+	target, err = adp.target("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.1/nginx:custom"})
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/nginx:custom", target)
+
+	// The target can't overwrite the source or be pushed to an unknown host,
+	// and the converted image is skipped.
+	_, err = adp.target("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.1/nginx"})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+	_, err = adp.target("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.3/nginx:custom"})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+	_, err = adp.target("192.168.1.1/nginx:latest-nydus", &model.Overrides{Target: "192.168.1.1/nginx:custom"})
+	require.ErrorIs(t, err, errdefs.ErrAlreadyConverted)
+
+	// The cache and mount references are derived from the overridden target.
+	target, cacheRef, mountRef, err := adp.refs("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.1/app:custom"})
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/app:custom", target)
+	require.Equal(t, "192.168.1.1/app:nydus-cache", cacheRef)
+	require.Equal(t, "192.168.1.1/proxy/nginx:latest", mountRef)
+	_, cacheRef, mountRef, err = adp.refs("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.2/nginx:custom"})
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.2/nginx:nydus-cache", cacheRef)
+	require.Empty(t, mountRef)
+	_, _, _, err = adp.refs("192.168.1.1/nginx:latest", &model.Overrides{Target: "192.168.1.1/nginx:nydus-cache"})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+}
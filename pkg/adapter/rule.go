@@ -16,6 +16,7 @@ package adapter
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/containerd/containerd/reference/docker"
@@ -27,6 +28,7 @@ import (
 const (
 	TagSuffix = "tag_suffix"
 	CacheTag  = "cache_tag"
+	MountFrom = "mount_from"
 )
 
 // Add suffix to source image reference as the target
@@ -78,35 +80,297 @@ func setReferenceTag(ref, tag string) (string, error) {
 	return target, nil
 }
 
+// renderCacheRef renders the cache reference template by source image reference,
+// so that the remote cache can be shared by the repositories in the same project
+// or registry, for example:
+// Source: 192.168.1.1/library/nginx:latest
+// Template: {registry}/{project}/nydus-cache:latest
+// Target: 192.168.1.1/library/nydus-cache:latest
+func renderCacheRef(ref, template string) (string, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source image reference")
+	}
+	path := docker.Path(named)
+	project := path
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		project = path[:idx]
+	}
+	cacheRef := strings.NewReplacer(
+		"{registry}", docker.Domain(named),
+		"{project}", project,
+		"{repository}", path,
+	).Replace(template)
+
+	cacheNamed, err := docker.ParseNormalizedNamed(cacheRef)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid cache reference %s rendered from %s", cacheRef, template)
+	}
+	cacheNamed = docker.TagNameOnly(cacheNamed)
+	if tagged, ok := named.(docker.NamedTagged); ok && tagged.String() == cacheNamed.String() {
+		return "", errdefs.ErrSameTag
+	}
+	return cacheNamed.String(), nil
+}
+
+// cacheRefPattern returns the regular expression matching the references
+// rendered from the cache reference template, the tag defaults to latest.
+func cacheRefPattern(template string) *regexp.Regexp {
+	if !strings.Contains(template[strings.LastIndex(template, "/")+1:], ":") {
+		template += ":latest"
+	}
+	pattern := strings.NewReplacer(
+		regexp.QuoteMeta("{registry}"), `[^/]+`,
+		regexp.QuoteMeta("{project}"), `[^/:]+`,
+		regexp.QuoteMeta("{repository}"), `[^:]+`,
+	).Replace(regexp.QuoteMeta(template))
+	// The pattern is always valid since the template is quoted.
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// matchPrefix returns true if the repository name is under the
+// registry/namespace prefix by path components, empty prefix
+// matches all.
+func matchPrefix(name, prefix string) bool {
+	prefix = strings.Trim(prefix, "/")
+	return prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// replacePrefix replaces the registry/namespace prefix of image reference
+// with a new one, the tag and digest are kept, for example:
+// Source: docker.io/library/nginx:latest
+// Prefix: docker.io -> 192.168.1.1/dockerhub
+// Target: 192.168.1.1/dockerhub/library/nginx:latest
+func replacePrefix(ref, prefix, newPrefix string) (string, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid source image reference")
+	}
+	if !matchPrefix(named.Name(), prefix) {
+		return "", fmt.Errorf("image %s isn't under %s", named.Name(), prefix)
+	}
+	name := strings.TrimSuffix(newPrefix, "/") + "/" + strings.TrimPrefix(strings.TrimPrefix(named.Name(), strings.Trim(prefix, "/")), "/")
+	name = strings.TrimSuffix(name, "/")
+	newNamed, err := docker.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image reference %s mapped to %s", name, newPrefix)
+	}
+	if tagged, ok := named.(docker.NamedTagged); ok {
+		if newNamed, err = docker.WithTag(newNamed, tagged.Tag()); err != nil {
+			return "", errors.Wrap(err, "invalid source image reference")
+		}
+	}
+	if digested, ok := named.(docker.Digested); ok {
+		if newNamed, err = docker.WithDigest(newNamed, digested.Digest()); err != nil {
+			return "", errors.Wrap(err, "invalid source image reference")
+		}
+	}
+	return newNamed.String(), nil
+}
+
 type Rule struct {
 	items []config.ConversionRule
 }
 
+// matchedItems returns the rule items applied to the image reference.
+func (rule *Rule) matchedItems(ref string) ([]config.ConversionRule, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid source image reference")
+	}
+	items := []config.ConversionRule{}
+	for _, item := range rule.items {
+		if matchPrefix(named.Name(), item.Source) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// targetItem returns the rule item deciding the target image reference,
+// nil if not found.
+func (rule *Rule) targetItem(ref string) (*config.ConversionRule, error) {
+	items, err := rule.matchedItems(ref)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.TagSuffix != "" || item.Target != "" {
+			return &item, nil
+		}
+	}
+	return nil, nil
+}
+
+// isCache returns true if the image reference may be rendered from the cache
+// reference template of a rule item, so that the remote cache pushed to the
+// repository matched by rules isn't converted.
+func (rule *Rule) isCache(ref string) (bool, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid source image reference")
+	}
+	tagged, ok := named.(docker.NamedTagged)
+	if !ok {
+		if _, ok := named.(docker.Digested); ok {
+			// The remote cache is always pushed by tag.
+			return false, nil
+		}
+		tagged = docker.TagNameOnly(named).(docker.NamedTagged)
+	}
+	name := tagged.Name() + ":" + tagged.Tag()
+	for _, item := range rule.items {
+		if item.CacheRef != "" && cacheRefPattern(item.CacheRef).MatchString(name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// converted returns true if the image reference is under the target
+// registry/namespace of a rule item with its tag suffix, so that the pushed
+// target isn't converted again if the target is matched by rules as well.
+func (rule *Rule) converted(ref string) (bool, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid source image reference")
+	}
+	for _, item := range rule.items {
+		if item.Target != "" && matchPrefix(named.Name(), item.Target) && strings.HasSuffix(ref, item.TagSuffix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mapTarget maps the image reference to the target registry/namespace
+// by the target rule item, it's unchanged if not configured.
+func (rule *Rule) mapTarget(ref string) (string, error) {
+	item, err := rule.targetItem(ref)
+	if err != nil {
+		return "", err
+	}
+	if item == nil || item.Target == "" {
+		return ref, nil
+	}
+	return replacePrefix(ref, item.Source, item.Target)
+}
+
 // Map maps the source image reference to a new one according to
 // a rule, the new one will be used as the reference of target image.
 func (rule *Rule) Map(ref, opt string) (string, error) {
 	switch opt {
 	case TagSuffix:
-		for _, item := range rule.items {
-			if item.TagSuffix != "" {
-				if strings.HasSuffix(ref, item.TagSuffix) {
-					// FIXME: To check if an image has been converted, a better solution
-					// is to use the annotation on image manifest.
-					return "", errdefs.ErrAlreadyConverted
-				}
-				return addSuffix(ref, item.TagSuffix)
-			}
+		converted, err := rule.converted(ref)
+		if err != nil {
+			return "", err
+		}
+		if converted {
+			return "", errdefs.ErrAlreadyConverted
+		}
+		item, err := rule.targetItem(ref)
+		if err != nil {
+			return "", err
+		}
+		if item == nil {
+			break
+		}
+		if item.TagSuffix != "" && strings.HasSuffix(ref, item.TagSuffix) {
+			// FIXME: To check if an image has been converted, a better solution
+			// is to use the annotation on image manifest.
+			return "", errdefs.ErrAlreadyConverted
+		}
+		target, err := addSuffix(ref, item.TagSuffix)
+		if err != nil || item.Target == "" {
+			return target, err
 		}
+		return replacePrefix(target, item.Source, item.Target)
 	case CacheTag:
-		for _, item := range rule.items {
+		isCache, err := rule.isCache(ref)
+		if err != nil {
+			return "", err
+		}
+		if isCache {
+			return "", errdefs.ErrSameTag
+		}
+		items, err := rule.matchedItems(ref)
+		if err != nil {
+			return "", err
+		}
+		// The cache is stored in the target registry.
+		if ref, err = rule.mapTarget(ref); err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			if item.CacheRef != "" {
+				return renderCacheRef(ref, item.CacheRef)
+			}
 			if item.CacheTag != "" {
 				return setReferenceTag(ref, item.CacheTag)
 			}
 		}
-		// CacheTag empty means do not provide remote cache, just return empty string.
+		// CacheRef and CacheTag empty means do not provide remote cache, just return empty string.
 		return "", nil
+	case MountFrom:
+		item, err := rule.targetItem(ref)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case item == nil || item.BlobCopy == "" || item.BlobCopy == config.BlobCopyPush:
+			// Empty means the untouched blobs are pushed as usual.
+			return "", nil
+		case item.BlobCopy != config.BlobCopyMount:
+			return "", fmt.Errorf("unsupported blob copy strategy: %s", item.BlobCopy)
+		case item.MountFrom == "":
+			return "", errors.New("mount_from is required by mount strategy")
+		}
+		return replacePrefix(ref, item.Source, item.MountFrom)
 	default:
 		return "", fmt.Errorf("unsupported map option: %s", opt)
 	}
 	return "", errors.New("not found matched conversion rule")
 }
+
+// MapOverride maps the source image reference as Map does, except that the
+// remote cache and mount references are derived from the target reference
+// overridden by request, instead of the one created by rules.
+func (rule *Rule) MapOverride(ref, target, opt string) (string, error) {
+	switch opt {
+	case CacheTag:
+		items, err := rule.matchedItems(ref)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			if item.CacheRef != "" {
+				return renderCacheRef(target, item.CacheRef)
+			}
+			if item.CacheTag != "" {
+				return setReferenceTag(target, item.CacheTag)
+			}
+		}
+		return "", nil
+	case MountFrom:
+		mountRef, err := rule.Map(ref, MountFrom)
+		if err != nil || mountRef == "" {
+			return mountRef, err
+		}
+		mountNamed, err := docker.ParseNormalizedNamed(mountRef)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid mount reference")
+		}
+		targetNamed, err := docker.ParseNormalizedNamed(target)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid target reference")
+		}
+		// The blobs can only be mounted in the same registry, they're
+		// pushed as usual otherwise.
+		if docker.Domain(mountNamed) != docker.Domain(targetNamed) {
+			return "", nil
+		}
+		return mountRef, nil
+	default:
+		return rule.Map(ref, opt)
+	}
+}
@@ -15,9 +15,13 @@
 package adapter
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
 )
 
 func TestAddSuffix(t *testing.T) {
@@ -55,3 +59,134 @@ func TestSetReferenceTag(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "192.168.1.1/nginx:nydus-cache", cacheRef)
 }
+
+func TestRenderCacheRef(t *testing.T) {
+	cacheRef, err := renderCacheRef("192.168.1.1/library/nginx:test", "{registry}/{project}/nydus-cache:latest")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/library/nydus-cache:latest", cacheRef)
+
+	cacheRef, err = renderCacheRef("192.168.1.1/library/app/nginx@sha256:f8c20f8bbcb684055b4fea470fdd169c86e87786940b3262335b12ec3adef418", "{registry}/cache/nydus:{project}")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/cache/nydus:library", cacheRef)
+
+	cacheRef, err = renderCacheRef("192.168.1.1/library/nginx", "{registry}/{repository}:nydus-cache")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/library/nginx:nydus-cache", cacheRef)
+
+	cacheRef, err = renderCacheRef("192.168.1.1/library/nydus-cache", "{registry}/{project}/nydus-cache")
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/library/nydus-cache:latest", cacheRef)
+
+	_, err = renderCacheRef("192.168.1.1/library/nydus-cache:latest", "{registry}/{project}/nydus-cache:latest")
+	require.ErrorIs(t, err, errdefs.ErrSameTag)
+
+	_, err = renderCacheRef("192.168.1.1/library/nginx:test", "{registry}/{project}/Invalid")
+	require.Error(t, err)
+}
+
+func TestMapTarget(t *testing.T) {
+	rule := &Rule{items: []config.ConversionRule{
+		{Source: "docker.io/library", Target: "192.168.1.1/dockerhub", TagSuffix: "-nydus", BlobCopy: config.BlobCopyMount, MountFrom: "192.168.1.1/proxy"},
+		{Source: "ghcr.io", Target: "192.168.1.1/ghcr"},
+		{TagSuffix: "-nydus"},
+		{CacheRef: "{registry}/{project}/nydus-cache:latest"},
+	}}
+
+	requireMap := func(ref, opt, expected string) {
+		mapped, err := rule.Map(ref, opt)
+		require.NoError(t, err)
+		require.Equal(t, expected, mapped)
+	}
+
+	requireMap("nginx:latest", TagSuffix, "192.168.1.1/dockerhub/nginx:latest-nydus")
+	requireMap("nginx:latest", CacheTag, "192.168.1.1/dockerhub/nydus-cache:latest")
+	requireMap("nginx:latest", MountFrom, "192.168.1.1/proxy/nginx:latest")
+
+	// The target registry keeps the namespace under the source prefix.
+	requireMap("ghcr.io/goharbor/harbor-core:v2.8.0", TagSuffix, "192.168.1.1/ghcr/goharbor/harbor-core:v2.8.0")
+	requireMap("ghcr.io/goharbor/harbor-core:v2.8.0", CacheTag, "192.168.1.1/ghcr/nydus-cache:latest")
+	requireMap("ghcr.io/goharbor/harbor-core:v2.8.0", MountFrom, "")
+
+	// The prefix matches by path components.
+	requireMap("docker.io/library-x/nginx:latest", TagSuffix, "docker.io/library-x/nginx:latest-nydus")
+	requireMap("192.168.1.1/library/nginx:latest", TagSuffix, "192.168.1.1/library/nginx:latest-nydus")
+	requireMap("192.168.1.1/library/nginx:latest", CacheTag, "192.168.1.1/library/nydus-cache:latest")
+}
+
+func TestMapConverted(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		items []config.ConversionRule
+		ref   string
+	}{
+		{
+			name:  "tag suffix",
+			items: []config.ConversionRule{{TagSuffix: "-nydus"}},
+			ref:   "192.168.1.1/library/nginx:latest-nydus",
+		},
+		{
+			name: "target without tag suffix",
+			items: []config.ConversionRule{
+				{Source: "ghcr.io", Target: "192.168.1.1/ghcr"},
+				{TagSuffix: "-nydus"},
+			},
+			ref: "192.168.1.1/ghcr/goharbor/harbor-core:v2.8.0",
+		},
+		{
+			name:  "target under empty source",
+			items: []config.ConversionRule{{Target: "192.168.1.1/nydus"}},
+			ref:   "192.168.1.1/nydus/docker.io/library/nginx:latest",
+		},
+		{
+			name:  "target with tag suffix",
+			items: []config.ConversionRule{{Source: "192.168.1.1/library", Target: "192.168.1.1/nydus", TagSuffix: "-nydus"}, {Source: "192.168.1.1", TagSuffix: "-esgz"}},
+			ref:   "192.168.1.1/nydus/nginx:latest-nydus",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := &Rule{items: tc.items}
+			_, err := rule.Map(tc.ref, TagSuffix)
+			require.ErrorIs(t, err, errdefs.ErrAlreadyConverted)
+		})
+	}
+
+	// The image under the target namespace without the tag suffix isn't
+	// converted by the rule item.
+	rule := &Rule{items: []config.ConversionRule{
+		{Source: "192.168.1.1/library", Target: "192.168.1.1/nydus", TagSuffix: "-nydus"},
+		{Source: "192.168.1.1", TagSuffix: "-esgz"},
+	}}
+	target, err := rule.Map("192.168.1.1/nydus/nginx:latest", TagSuffix)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/nydus/nginx:latest-esgz", target)
+}
+
+func TestMapCache(t *testing.T) {
+	rule := &Rule{items: []config.ConversionRule{
+		{Source: "docker.io", Target: "192.168.1.1/dockerhub", CacheRef: "{registry}/{repository}-cache"},
+		{TagSuffix: "-nydus"},
+		{CacheRef: "{registry}/cache/nydus:{project}"},
+	}}
+
+	for ref, cacheRef := range map[string]string{
+		"192.168.1.1/library/nginx:latest":                            "192.168.1.1/cache/nydus:library",
+		"docker.io/library/nginx:latest":                              "192.168.1.1/dockerhub/library/nginx-cache:latest",
+		"192.168.1.1/library/nginx-cache:v1":                          "192.168.1.1/cache/nydus:library",
+		"192.168.1.1/library/nginx@sha256:" + strings.Repeat("a", 64): "192.168.1.1/cache/nydus:library",
+	} {
+		mapped, err := rule.Map(ref, CacheTag)
+		require.NoError(t, err)
+		require.Equal(t, cacheRef, mapped)
+	}
+
+	// The remote caches pushed to the repositories matched by rules are
+	// skipped, though they're converted to different cache references.
+	for _, ref := range []string{
+		"192.168.1.1/cache/nydus:library",
+		"192.168.1.1/dockerhub/library/nginx-cache:latest",
+		"192.168.1.1/dockerhub/library/nginx-cache",
+	} {
+		_, err := rule.Map(ref, CacheTag)
+		require.ErrorIs(t, err, errdefs.ErrSameTag, ref)
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backfill converts the images pushed to registry before acceld
+// was deployed, which are never notified by webhook. A backfill job lists
+// the repositories and tags of registry, and enqueues the conversions at
+// a limited rate, the progress is stored to resume after restart.
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/time/rate"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+)
+
+var bucketObjectJobs = []byte("jobs")
+
+// defaultRate is the default max number of conversions enqueued per second.
+const defaultRate = 1
+
+// defaultConcurrency is the default max number of conversions in flight.
+const defaultConcurrency = 1
+
+const StatusRunning = "RUNNING"
+const StatusCompleted = "COMPLETED"
+const StatusFailed = "FAILED"
+
+// Job is a backfill job, the progress is saved once an image is enqueued
+// or a repository is processed.
+type Job struct {
+	ID       string                `json:"id"`
+	Created  time.Time             `json:"created"`
+	Finished time.Time             `json:"finished"`
+	Request  model.BackfillRequest `json:"request"`
+	Status   string                `json:"status"`
+	Reason   string                `json:"reason"`
+	// Repositories is the number of repositories to process.
+	Repositories int `json:"repositories"`
+	// Processed is the number of processed repositories, and LastRepository
+	// is the last one, the job is resumed after it.
+	Processed      int    `json:"processed"`
+	LastRepository string `json:"last_repository"`
+	// Repository is the repository in processing, and LastTag is the last
+	// processed tag of it, the repository is resumed after the tag.
+	Repository string `json:"repository"`
+	LastTag    string `json:"last_tag"`
+	// Enqueued is the number of images enqueued for conversion, Skipped is
+	// the number of tags filtered out, and Failed is the number of images
+	// failed to check or enqueue.
+	Enqueued int `json:"enqueued"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// Options configures the backfill manager.
+type Options struct {
+	// WorkDir stores the database of backfill jobs.
+	WorkDir string
+	// Rate is the max number of conversions enqueued per second,
+	// defaults to 1.
+	Rate float64
+	// Concurrency is the max number of conversions enqueued by backfill
+	// jobs and not finished yet, defaults to 1.
+	Concurrency int
+	// Host returns the host configuration of image reference.
+	Host remote.HostFunc
+	// Harbor returns true if the registry host is listed by Harbor API.
+	Harbor func(host string) bool
+	// Target returns the target reference of source image by the conversion
+	// rules, the image is skipped if an error is returned.
+	Target func(source string) (string, error)
+	// Created returns the creation time of image, it's called to filter
+	// the image by age if the push time is unknown.
+	Created func(ctx context.Context, ref string) (time.Time, error)
+	// Dispatch enqueues the conversion of image, done must be called once
+	// the conversion finishes if no error is returned.
+	Dispatch func(ctx context.Context, ref string, done func()) error
+}
+
+// Manager manages the backfill jobs.
+type Manager struct {
+	opts    Options
+	db      *bolt.DB
+	limiter *rate.Limiter
+	// inflight holds a slot for each conversion in flight.
+	inflight chan struct{}
+
+	mutex sync.Mutex
+	jobs  map[string]*Job
+}
+
+// filter is the parsed tag and age filter of request.
+type filter struct {
+	tagPattern *regexp.Regexp
+	maxAge     time.Duration
+}
+
+// NewManager opens the backfill database in work directory, the running
+// jobs are loaded but not resumed until Resume is called.
+func NewManager(opts Options) (*Manager, error) {
+	bdb, err := bolt.Open(filepath.Join(opts.WorkDir, "backfill.db"), 0655, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create backfill database")
+	}
+	limit := opts.Rate
+	if limit <= 0 {
+		limit = defaultRate
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	m := &Manager{
+		opts:     opts,
+		db:       bdb,
+		limiter:  rate.NewLimiter(rate.Limit(limit), 1),
+		inflight: make(chan struct{}, concurrency),
+		jobs:     make(map[string]*Job),
+	}
+	if err := m.load(); err != nil {
+		bdb.Close()
+		return nil, errors.Wrap(err, "load backfill jobs")
+	}
+	return m, nil
+}
+
+// load loads the jobs from the database into memory.
+func (m *Manager) load() error {
+	return m.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketObjectJobs)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			m.jobs[job.ID] = &job
+			return nil
+		})
+	})
+}
+
+// save updates the job in database, it's called with mutex held.
+func (m *Manager) save(job *Job) error {
+	return m.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketObjectJobs)
+		if err != nil {
+			return err
+		}
+		jobJSON, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(job.ID), jobJSON)
+	})
+}
+
+func parseFilter(req model.BackfillRequest) (*filter, error) {
+	f := filter{}
+	if req.TagPattern != "" {
+		pattern, err := regexp.Compile("^(?:" + req.TagPattern + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "invalid tag pattern %s: %s", req.TagPattern, err)
+		}
+		f.tagPattern = pattern
+	}
+	if req.MaxAge != "" {
+		maxAge, err := time.ParseDuration(req.MaxAge)
+		if err != nil || maxAge <= 0 {
+			return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "invalid max age %s", req.MaxAge)
+		}
+		f.maxAge = maxAge
+	}
+	return &f, nil
+}
+
+func validate(req model.BackfillRequest) error {
+	if req.Registry == "" || strings.ContainsAny(req.Registry, "/ ") {
+		return errors.Wrapf(errdefs.ErrIllegalParameter, "invalid registry host %q", req.Registry)
+	}
+	if req.Repository != "" {
+		if _, err := docker.ParseNormalizedNamed(req.Registry + "/" + req.Repository); err != nil {
+			return errors.Wrapf(errdefs.ErrIllegalParameter, "invalid repository %s", req.Repository)
+		}
+		if req.Project != "" && !strings.HasPrefix(req.Repository, req.Project+"/") {
+			return errors.Wrapf(errdefs.ErrIllegalParameter, "repository %s isn't under project %s", req.Repository, req.Project)
+		}
+	}
+	_, err := parseFilter(req)
+	return err
+}
+
+// Create creates a backfill job and runs it in background.
+func (m *Manager) Create(req model.BackfillRequest) (*Job, error) {
+	req.Project = strings.Trim(req.Project, "/")
+	req.Repository = strings.Trim(req.Repository, "/")
+	if err := validate(req); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job := &Job{
+		ID:      uuid.NewString(),
+		Created: time.Now(),
+		Request: req,
+		Status:  StatusRunning,
+	}
+	if err := m.save(job); err != nil {
+		return nil, errors.Wrap(err, "save backfill job")
+	}
+	m.jobs[job.ID] = job
+
+	jobCopy := *job
+	go m.run(context.Background(), job.ID)
+
+	return &jobCopy, nil
+}
+
+// Resume resumes the running jobs interrupted by the last exit.
+func (m *Manager) Resume() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, job := range m.jobs {
+		if job.Status == StatusRunning {
+			logrus.Infof("resume backfill job %s after repository %q, tag %q of %q", id, job.LastRepository, job.LastTag, job.Repository)
+			go m.run(context.Background(), id)
+		}
+	}
+}
+
+// List returns the copies of jobs sorted by creation time.
+func (m *Manager) List() []*Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobCopy := *job
+		jobs = append(jobs, &jobCopy)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// update applies fn to the job and saves it.
+func (m *Manager) update(id string, fn func(job *Job)) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job := m.jobs[id]
+	fn(job)
+	return *job, m.save(job)
+}
+
+// record applies fn to the job in memory, it's saved by the next update.
+func (m *Manager) record(id string, fn func(job *Job)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fn(m.jobs[id])
+}
+
+func (m *Manager) catalog(host string) Catalog {
+	if m.opts.Harbor != nil && m.opts.Harbor(host) {
+		return &harborCatalog{host: host, hostFunc: m.opts.Host}
+	}
+	return &registryCatalog{host: host, hostFunc: m.opts.Host}
+}
+
+func (m *Manager) run(ctx context.Context, id string) {
+	err := m.process(ctx, id)
+	job, saveErr := m.update(id, func(job *Job) {
+		job.Finished = time.Now()
+		if err != nil {
+			job.Status = StatusFailed
+			job.Reason = err.Error()
+		} else {
+			job.Status = StatusCompleted
+		}
+	})
+	if saveErr != nil {
+		logrus.WithError(saveErr).Errorf("save backfill job %s", id)
+	}
+	if err != nil {
+		logrus.WithError(err).Errorf("backfill job %s failed", id)
+		return
+	}
+	logrus.Infof("backfill job %s completed: enqueued %d, skipped %d, failed %d", id, job.Enqueued, job.Skipped, job.Failed)
+}
+
+// process processes the repositories after the last processed one in order,
+// the repository in processing is resumed after its last processed tag.
+func (m *Manager) process(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	job := *m.jobs[id]
+	m.mutex.Unlock()
+
+	req := job.Request
+	f, err := parseFilter(req)
+	if err != nil {
+		return err
+	}
+	catalog := m.catalog(req.Registry)
+
+	repositories := []string{req.Repository}
+	if req.Repository == "" {
+		if repositories, err = catalog.Repositories(ctx, req.Project); err != nil {
+			return err
+		}
+	}
+	sort.Strings(repositories)
+	if _, err := m.update(id, func(job *Job) {
+		job.Repositories = len(repositories)
+	}); err != nil {
+		return errors.Wrap(err, "save backfill job")
+	}
+
+	for _, repository := range repositories {
+		if job.LastRepository != "" && repository <= job.LastRepository {
+			continue
+		}
+		lastTag := ""
+		if repository == job.Repository {
+			lastTag = job.LastTag
+		}
+		if err := m.processRepository(ctx, id, catalog, req.Registry, repository, lastTag, f); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			logrus.WithError(err).Warnf("backfill repository %s", repository)
+			m.record(id, func(job *Job) {
+				job.Failed++
+			})
+		}
+		if _, err := m.update(id, func(job *Job) {
+			job.Processed++
+			job.LastRepository = repository
+			job.Repository = ""
+			job.LastTag = ""
+		}); err != nil {
+			return errors.Wrap(err, "save backfill job")
+		}
+	}
+
+	return nil
+}
+
+// targetTags returns the tags of target repository keyed by reference, the
+// target repository is listed on the first use.
+func (m *Manager) targetTags(ctx context.Context, cache map[string]map[string]bool, named docker.Named) map[string]bool {
+	repository := named.Name()
+	if tags, ok := cache[repository]; ok {
+		return tags
+	}
+	tags := make(map[string]bool)
+	listed, err := m.catalog(docker.Domain(named)).Tags(ctx, docker.Path(named))
+	if err != nil {
+		// The target repository may not be created yet.
+		logrus.WithError(err).Debugf("list tags of target repository %s", repository)
+	}
+	for _, tag := range listed {
+		tags[repository+":"+tag.Name] = true
+	}
+	cache[repository] = tags
+	return tags
+}
+
+// tagResult is the result of processing a tag.
+type tagResult int
+
+const (
+	tagEnqueued tagResult = iota
+	tagSkipped
+	tagFailed
+)
+
+// processRepository enqueues the conversions of the tags in repository
+// after lastTag which pass the filter and haven't been converted, the
+// progress is saved once a conversion is enqueued.
+func (m *Manager) processRepository(ctx context.Context, id string, catalog Catalog, registry, repository, lastTag string, f *filter) error {
+	tags, err := catalog.Tags(ctx, repository)
+	if err != nil {
+		return err
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	named, err := docker.ParseDockerRef(registry + "/" + repository)
+	if err != nil {
+		return errors.Wrap(err, "parse repository")
+	}
+	// The tags of target repositories, the target may be mapped to
+	// another repository or registry by rules.
+	existing := map[string]map[string]bool{named.Name(): {}}
+	for _, tag := range tags {
+		existing[named.Name()][named.Name()+":"+tag.Name] = true
+	}
+
+	for _, tag := range tags {
+		if lastTag != "" && tag.Name <= lastTag {
+			continue
+		}
+		result, err := m.processTag(ctx, existing, registry+"/"+repository, tag, f)
+		if err != nil {
+			return err
+		}
+		progress := func(job *Job) {
+			job.Repository = repository
+			job.LastTag = tag.Name
+			switch result {
+			case tagEnqueued:
+				job.Enqueued++
+			case tagSkipped:
+				job.Skipped++
+			case tagFailed:
+				job.Failed++
+			}
+		}
+		if result != tagEnqueued {
+			m.record(id, progress)
+			continue
+		}
+		if _, err := m.update(id, progress); err != nil {
+			return errors.Wrap(err, "save backfill job")
+		}
+	}
+
+	return nil
+}
+
+// processTag enqueues the conversion of tag if it passes the filter and
+// hasn't been converted, an error is returned only if ctx is done.
+func (m *Manager) processTag(ctx context.Context, existing map[string]map[string]bool, repository string, tag Tag, f *filter) (tagResult, error) {
+	source := repository + ":" + tag.Name
+	if f.tagPattern != nil && !f.tagPattern.MatchString(tag.Name) {
+		return tagSkipped, nil
+	}
+	// The converted and cache images are skipped by rules, and the image
+	// is skipped if the target has been pushed.
+	target, err := m.opts.Target(source)
+	if err != nil {
+		return tagSkipped, nil
+	}
+	if named, err := docker.ParseDockerRef(target); err == nil && m.targetTags(ctx, existing, named)[named.String()] {
+		return tagSkipped, nil
+	}
+	if f.maxAge > 0 {
+		pushed := tag.Pushed
+		if pushed.IsZero() {
+			if pushed, err = m.opts.Created(ctx, source); err != nil {
+				logrus.WithError(err).Warnf("get creation time of %s", source)
+				return tagFailed, nil
+			}
+		}
+		if time.Since(pushed) > f.maxAge {
+			return tagSkipped, nil
+		}
+	}
+
+	// Wait for a slot of conversion in flight, so that the worker queue
+	// isn't flooded by a large registry.
+	select {
+	case m.inflight <- struct{}{}:
+	case <-ctx.Done():
+		return tagFailed, ctx.Err()
+	}
+	if err := m.limiter.Wait(ctx); err != nil {
+		m.release()
+		return tagFailed, err
+	}
+	if err := m.opts.Dispatch(ctx, source, m.release); err != nil {
+		m.release()
+		logrus.WithError(err).Warnf("enqueue conversion of %s", source)
+		return tagFailed, nil
+	}
+	return tagEnqueued, nil
+}
+
+// release releases the slot of a conversion in flight.
+func (m *Manager) release() {
+	<-m.inflight
+}
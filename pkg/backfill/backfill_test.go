@@ -0,0 +1,208 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+func putManifest(t *testing.T, registry *registrytest.Registry, repository, tag string) {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v2/%s/manifests/%s", registry.URL, repository, tag), strings.NewReader(`{"schemaVersion":2}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", ocispec.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestBackfill(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	putManifest(t, registry, "library/busybox", "latest")
+	putManifest(t, registry, "library/nginx", "latest")
+	putManifest(t, registry, "library/nginx", "latest-nydus")
+	putManifest(t, registry, "library/nginx", "v1")
+	putManifest(t, registry, "library/nginx", "old")
+	putManifest(t, registry, "library/redis", "v1")
+	putManifest(t, registry, "library/redis", "v2")
+	putManifest(t, registry, "other/nginx", "v1")
+	// The redis images are converted to another registry.
+	targetRegistry := registrytest.New()
+	defer targetRegistry.Close()
+	putManifest(t, targetRegistry, "library/redis", "v1")
+
+	var (
+		mutex      sync.Mutex
+		dispatched []string
+		running    int
+		finished   sync.WaitGroup
+	)
+	m, err := NewManager(Options{
+		WorkDir:     t.TempDir(),
+		Rate:        1000,
+		Concurrency: 1,
+		Host: func(string) (*remote.HostConfig, error) {
+			return &remote.HostConfig{PlainHTTP: true}, nil
+		},
+		Target: func(source string) (string, error) {
+			if strings.HasSuffix(source, "-nydus") {
+				return "", errdefs.ErrAlreadyConverted
+			}
+			if strings.Contains(source, "/library/redis:") {
+				return targetRegistry.Host() + "/library/redis:" + strings.SplitN(source, ":", 3)[2], nil
+			}
+			return source + "-nydus", nil
+		},
+		Created: func(_ context.Context, ref string) (time.Time, error) {
+			if strings.HasSuffix(ref, ":old") {
+				return time.Now().Add(-48 * time.Hour), nil
+			}
+			return time.Now(), nil
+		},
+		Dispatch: func(_ context.Context, ref string, done func()) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			dispatched = append(dispatched, ref)
+			// The next conversion isn't enqueued until this one is done.
+			running++
+			require.Equal(t, 1, running)
+			finished.Add(1)
+			go func() {
+				defer finished.Done()
+				mutex.Lock()
+				running--
+				mutex.Unlock()
+				done()
+			}()
+			return nil
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = m.Create(model.BackfillRequest{Registry: registry.Host(), TagPattern: "("})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+	_, err = m.Create(model.BackfillRequest{Registry: registry.Host(), MaxAge: "1month"})
+	require.ErrorIs(t, err, errdefs.ErrIllegalParameter)
+
+	// Resumed after library/nginx:latest, the converted image, the old
+	// image and the image converted to another registry are skipped.
+	job := &Job{
+		ID:             "resumed",
+		Created:        time.Now(),
+		Request:        model.BackfillRequest{Registry: registry.Host(), Project: "library", MaxAge: "24h"},
+		Status:         StatusRunning,
+		LastRepository: "library/busybox",
+		Repository:     "library/nginx",
+		LastTag:        "latest",
+	}
+	m.jobs[job.ID] = job
+	m.run(context.Background(), job.ID)
+	finished.Wait()
+
+	host := registry.Host()
+	require.Equal(t, []string{host + "/library/nginx:v1", host + "/library/redis:v2"}, dispatched)
+	jobs := m.List()
+	require.Len(t, jobs, 1)
+	require.Equal(t, StatusCompleted, jobs[0].Status)
+	require.Equal(t, 3, jobs[0].Repositories)
+	require.Equal(t, 2, jobs[0].Processed)
+	require.Equal(t, "library/redis", jobs[0].LastRepository)
+	require.Empty(t, jobs[0].LastTag)
+	require.Equal(t, 2, jobs[0].Enqueued)
+	require.Equal(t, 3, jobs[0].Skipped)
+
+	// Single repository filtered by tag pattern, the image converted
+	// to the same repository is skipped.
+	dispatched = nil
+	job = &Job{
+		ID:      "repository",
+		Created: time.Now(),
+		Request: model.BackfillRequest{Registry: registry.Host(), Repository: "library/nginx", TagPattern: "latest|v.*"},
+		Status:  StatusRunning,
+	}
+	m.jobs[job.ID] = job
+	m.run(context.Background(), job.ID)
+	finished.Wait()
+	require.Equal(t, []string{host + "/library/nginx:v1"}, dispatched)
+	require.Equal(t, 3, m.jobs[job.ID].Skipped)
+}
+
+func TestHarborCatalog(t *testing.T) {
+	pushed := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, _ := req.BasicAuth(); user != "admin" || password != "Harbor12345" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body interface{}
+		switch req.URL.EscapedPath() {
+		case "/api/v2.0/projects":
+			body = []harborProject{{Name: "library"}}
+		case "/api/v2.0/projects/library/repositories":
+			body = []harborRepository{{Name: "library/nginx"}, {Name: "library/app/web"}}
+		case "/api/v2.0/projects/library/repositories/app%252Fweb/artifacts":
+			require.Equal(t, "true", req.URL.Query().Get("with_tag"))
+			body = []map[string]interface{}{
+				{"tags": []map[string]interface{}{{"name": "v1", "push_time": pushed}, {"name": "v2", "push_time": pushed}}},
+				{"tags": nil},
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	defer server.Close()
+
+	catalog := &harborCatalog{
+		host: strings.TrimPrefix(server.URL, "http://"),
+		hostFunc: func(string) (*remote.HostConfig, error) {
+			return &remote.HostConfig{
+				PlainHTTP: true,
+				Credential: remote.CredentialProviderFunc(func(string) (*remote.Credential, error) {
+					return &remote.Credential{Username: "admin", Password: "Harbor12345"}, nil
+				}),
+			}, nil
+		},
+	}
+
+	repositories, err := catalog.Repositories(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"library/nginx", "library/app/web"}, repositories)
+
+	tags, err := catalog.Tags(context.Background(), "library/app/web")
+	require.NoError(t, err)
+	require.Equal(t, []Tag{{Name: "v1", Pushed: pushed}, {Name: "v2", Pushed: pushed}}, tags)
+
+	_, err = catalog.Tags(context.Background(), "library/redis")
+	require.Error(t, err)
+}
@@ -0,0 +1,241 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+)
+
+// harborPageSize is the number of entries requested per page of Harbor API.
+const harborPageSize = 100
+
+// Tag is a tag of repository.
+type Tag struct {
+	Name string
+	// Pushed is the push time of tag, zero if unknown.
+	Pushed time.Time
+}
+
+// Catalog lists the repositories and tags of a registry.
+type Catalog interface {
+	// Repositories lists the repositories under the project, or all
+	// repositories if project is empty, the names are without host.
+	Repositories(ctx context.Context, project string) ([]string, error)
+	// Tags lists the tags of repository.
+	Tags(ctx context.Context, repository string) ([]Tag, error)
+}
+
+// registryCatalog lists by the catalog and tags list API of distribution.
+type registryCatalog struct {
+	host     string
+	hostFunc remote.HostFunc
+}
+
+func (catalog *registryCatalog) Repositories(ctx context.Context, project string) ([]string, error) {
+	repositories, err := remote.ListRepositories(ctx, catalog.host, catalog.hostFunc)
+	if err != nil {
+		return nil, err
+	}
+	if project == "" {
+		return repositories, nil
+	}
+	matched := []string{}
+	for _, repository := range repositories {
+		if strings.HasPrefix(repository, project+"/") {
+			matched = append(matched, repository)
+		}
+	}
+	return matched, nil
+}
+
+func (catalog *registryCatalog) Tags(ctx context.Context, repository string) ([]Tag, error) {
+	names, err := remote.ListTags(ctx, catalog.host+"/"+repository, catalog.hostFunc)
+	if err != nil {
+		return nil, err
+	}
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, Tag{Name: name})
+	}
+	return tags, nil
+}
+
+// harborCatalog lists by the Harbor v2 API, it reports the push time of tags.
+type harborCatalog struct {
+	host     string
+	hostFunc remote.HostFunc
+}
+
+type harborProject struct {
+	Name string `json:"name"`
+}
+
+type harborRepository struct {
+	Name string `json:"name"`
+}
+
+type harborArtifact struct {
+	Tags []struct {
+		Name     string    `json:"name"`
+		PushTime time.Time `json:"push_time"`
+	} `json:"tags"`
+}
+
+func (catalog *harborCatalog) Repositories(ctx context.Context, project string) ([]string, error) {
+	projects := []string{project}
+	if project == "" {
+		projects = nil
+		if err := catalog.list(ctx, "/api/v2.0/projects", func(data []byte) (int, error) {
+			var page []harborProject
+			if err := json.Unmarshal(data, &page); err != nil {
+				return 0, err
+			}
+			for _, project := range page {
+				projects = append(projects, project.Name)
+			}
+			return len(page), nil
+		}); err != nil {
+			return nil, errors.Wrap(err, "list harbor projects")
+		}
+	}
+
+	repositories := []string{}
+	for _, project := range projects {
+		if err := catalog.list(ctx, fmt.Sprintf("/api/v2.0/projects/%s/repositories", url.PathEscape(project)), func(data []byte) (int, error) {
+			var page []harborRepository
+			if err := json.Unmarshal(data, &page); err != nil {
+				return 0, err
+			}
+			for _, repository := range page {
+				repositories = append(repositories, repository.Name)
+			}
+			return len(page), nil
+		}); err != nil {
+			return nil, errors.Wrapf(err, "list repositories of harbor project %s", project)
+		}
+	}
+
+	return repositories, nil
+}
+
+func (catalog *harborCatalog) Tags(ctx context.Context, repository string) ([]Tag, error) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) < 2 {
+		return nil, errors.Wrapf(errdefs.ErrIllegalParameter, "invalid harbor repository %s", repository)
+	}
+	// The slashes in repository name are double escaped by Harbor API.
+	api := fmt.Sprintf("/api/v2.0/projects/%s/repositories/%s/artifacts", url.PathEscape(parts[0]), url.PathEscape(url.PathEscape(parts[1])))
+
+	tags := []Tag{}
+	if err := catalog.list(ctx, api, func(data []byte) (int, error) {
+		var page []harborArtifact
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, err
+		}
+		for _, artifact := range page {
+			for _, tag := range artifact.Tags {
+				tags = append(tags, Tag{Name: tag.Name, Pushed: tag.PushTime})
+			}
+		}
+		return len(page), nil
+	}, "with_tag=true"); err != nil {
+		return nil, errors.Wrapf(err, "list artifacts of harbor repository %s", repository)
+	}
+
+	return tags, nil
+}
+
+// list requests the paginated Harbor API, and calls fn with the body of each
+// page until fn reports a page isn't full.
+func (catalog *harborCatalog) list(ctx context.Context, api string, fn func(data []byte) (int, error), query ...string) error {
+	hostConfig, err := catalog.hostFunc(catalog.host)
+	if err != nil {
+		return err
+	}
+	client := remote.NewHostClient(hostConfig)
+	scheme := "https"
+	if hostConfig.PlainHTTP {
+		scheme = "http"
+	}
+	var cred *remote.Credential
+	if hostConfig.Credential != nil {
+		if cred, err = hostConfig.Credential.Credential(catalog.host); err != nil {
+			return errors.Wrap(err, "get credential")
+		}
+	}
+
+	for page := 1; ; page++ {
+		rawQuery := strings.Join(append([]string{fmt.Sprintf("page=%d&page_size=%d", page, harborPageSize)}, query...), "&")
+		data, err := catalog.get(ctx, client, scheme, cred, api, rawQuery)
+		if errdefs.NeedsRetryWithHTTP(err) && scheme == "https" {
+			scheme = "http"
+			data, err = catalog.get(ctx, client, scheme, cred, api, rawQuery)
+		}
+		if err != nil {
+			return err
+		}
+		count, err := fn(data)
+		if err != nil {
+			return errors.Wrap(err, "unmarshal response")
+		}
+		if count < harborPageSize {
+			return nil
+		}
+	}
+}
+
+func (catalog *harborCatalog) get(ctx context.Context, client *http.Client, scheme string, cred *remote.Credential, api, rawQuery string) ([]byte, error) {
+	reqURL := url.URL{
+		Scheme: scheme,
+		Host:   catalog.host,
+		// The escaped path is kept as is.
+		Opaque:   "//" + catalog.host + api,
+		RawQuery: rawQuery,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if cred != nil && cred.Username != "" {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from GET %s: %s", api, resp.Status)
+	}
+
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+	return data, nil
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"sync"
+	"time"
 
 	"bytes"
 	"encoding/json"
@@ -30,10 +32,12 @@ import (
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
 	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
 	"github.com/containerd/containerd/remotes"
-	nydusify "github.com/containerd/nydus-snapshotter/pkg/converter"
 	nydusutils "github.com/goharbor/acceleration-service/pkg/driver/nydus/utils"
+	"github.com/goharbor/acceleration-service/pkg/remote"
 	"github.com/goharbor/acceleration-service/pkg/utils"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
@@ -42,6 +46,37 @@ import (
 
 const LayerAnnotationCacheVersion = "containerd.io/snapshot/nydus-cache-version"
 
+const (
+	// maxPushAttempts is the max attempts to merge and push the remote cache
+	// when the remote cache is updated by other converters concurrently.
+	maxPushAttempts   = 5
+	pushRetryInterval = 200 * time.Millisecond
+)
+
+// ErrConflict is returned if the remote cache is updated concurrently.
+var ErrConflict = errors.New("remote cache is updated concurrently")
+
+// SourceDigestAnnotation returns the annotation key recording the source
+// layer digest on the cached target layer, it's namespaced by driver and
+// driver version, so that the cache records of different drivers can't be
+// mixed up. The key without driver version is compatible with nydusify.
+func SourceDigestAnnotation(driver, version string) string {
+	return annotationKey(driver, version, "source-digest")
+}
+
+// TargetDigestAnnotation returns the annotation key recording the target
+// layer digest on the source layer, it's namespaced like SourceDigestAnnotation.
+func TargetDigestAnnotation(driver, version string) string {
+	return annotationKey(driver, version, "target-digest")
+}
+
+func annotationKey(driver, version, suffix string) string {
+	if version == "" {
+		return fmt.Sprintf("containerd.io/snapshot/%s-%s", driver, suffix)
+	}
+	return fmt.Sprintf("containerd.io/snapshot/%s-%s-%s", driver, version, suffix)
+}
+
 type cacheKey struct{}
 
 type Item struct {
@@ -50,7 +85,7 @@ type Item struct {
 }
 
 type Provider interface {
-	Resolver(ref string) (remotes.Resolver, error)
+	Resolver(ref string, op remote.Operation) (remotes.Resolver, error)
 	Pull(ctx context.Context, ref string) error
 	Push(ctx context.Context, desc ocispec.Descriptor, ref string) error
 	ContentStore() content.Store
@@ -69,24 +104,50 @@ type RemoteCache struct {
 	records map[digest.Digest]*Item
 	// size is the cache record capacity of target layers.
 	size int
-	// newAdded records the number of new caches added in one conversion.
-	newAdded uint
+	// added records the source layers of new caches added in one conversion.
+	added map[digest.Digest]bool
+	// policy decides which records are evicted if the capacity is exceeded.
+	policy EvictionPolicy
 
 	version string
+
+	// driver and driverVersion namespace the cache records.
+	driver        string
+	driverVersion string
+
+	// sourceRef is the reference of source image in conversion.
+	sourceRef string
 }
 
-func New(ctx context.Context, ref, version string, size int, pvd Provider) (context.Context, *RemoteCache) {
+func New(ctx context.Context, ref, version string, size int, policy EvictionPolicy, pvd Provider) (context.Context, *RemoteCache) {
 	cache := &RemoteCache{
 		Ref:      ref,
 		provider: pvd,
 		records:  make(map[digest.Digest]*Item),
+		added:    make(map[digest.Digest]bool),
 		size:     size,
+		policy:   policy,
 		version:  version,
+		// The remote cache was only supported by nydus driver before.
+		driver: "nydus",
 	}
 	cxt := context.WithValue(ctx, cacheKey{}, cache)
 	return cxt, cache
 }
 
+// SetDriver sets the driver which the cache records belong to,
+// it must be called before fetching the cache.
+func (rc *RemoteCache) SetDriver(name, version string) {
+	rc.driver = name
+	rc.driverVersion = version
+}
+
+// SetSource sets the reference of source image in conversion, the source layers
+// of new cache records are mounted from it if the cache is in another repository.
+func (rc *RemoteCache) SetSource(ref string) {
+	rc.sourceRef = ref
+}
+
 func (rc *RemoteCache) getByTarget(target digest.Digest) *Item {
 	rc.mutex.Lock()
 	defer rc.mutex.Unlock()
@@ -98,6 +159,14 @@ func (rc *RemoteCache) getByTarget(target digest.Digest) *Item {
 	return nil
 }
 
+// isHit reports whether the cache record of source layer is hit,
+// the record added in current conversion isn't a hit.
+func (rc *RemoteCache) isHit(source digest.Digest) bool {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.records[source] != nil && !rc.added[source]
+}
+
 func (rc *RemoteCache) getBySource(source digest.Digest) *Item {
 	rc.mutex.Lock()
 	defer rc.mutex.Unlock()
@@ -117,11 +186,13 @@ func (rc *RemoteCache) get(digest digest.Digest) *ocispec.Descriptor {
 func (rc *RemoteCache) set(source, target ocispec.Descriptor) {
 	rc.mutex.Lock()
 	defer rc.mutex.Unlock()
-	if source.Annotations == nil {
-		source.Annotations = map[string]string{}
-	}
-	source.Annotations[nydusify.LayerAnnotationNydusTargetDigest] = string(target.Digest)
-	target.Annotations[nydusify.LayerAnnotationNydusSourceDigest] = string(source.Digest)
+	// Copy the annotations to avoid modifying the descriptors of caller.
+	source.Annotations = mergeMap(mergeMap(nil, source.Annotations), map[string]string{
+		TargetDigestAnnotation(rc.driver, rc.driverVersion): string(target.Digest),
+	})
+	target.Annotations = mergeMap(mergeMap(nil, target.Annotations), map[string]string{
+		SourceDigestAnnotation(rc.driver, rc.driverVersion): string(source.Digest),
+	})
 	rc.records[source.Digest] = &Item{
 		Source: source,
 		Target: target,
@@ -161,11 +232,26 @@ func Get(ctx context.Context, dgst digest.Digest) (*RemoteCache, *ocispec.Descri
 	return nil, nil
 }
 
+// GetTarget gets the cached target layer of the source layer digest.
+func GetTarget(ctx context.Context, source digest.Digest) *ocispec.Descriptor {
+	rc, ok := ctx.Value(cacheKey{}).(*RemoteCache)
+	if ok {
+		if item := rc.getBySource(source); item != nil {
+			target := item.Target
+			target.Annotations = mergeMap(nil, item.Target.Annotations)
+			return &target
+		}
+	}
+	return nil
+}
+
 func Set(ctx context.Context, source, target ocispec.Descriptor) {
 	rc, ok := ctx.Value(cacheKey{}).(*RemoteCache)
 	if ok {
 		if rc.get(source.Digest) == nil {
-			rc.newAdded++
+			rc.mutex.Lock()
+			rc.added[source.Digest] = true
+			rc.mutex.Unlock()
 		}
 		rc.set(source, target)
 	}
@@ -181,9 +267,16 @@ func Update(ctx context.Context, dgst digest.Digest, labels map[string]string) (
 
 // Fetch fetchs cache manifest from remote registry.
 func (rc *RemoteCache) Fetch(ctx context.Context, platformMC platforms.MatchComparer) (*ocispec.Descriptor, error) {
-	resolver, err := rc.provider.Resolver(rc.Ref)
+	desc, _, err := rc.fetch(ctx, platformMC)
+	return desc, err
+}
+
+// fetch fetchs cache manifest from remote registry, and returns the digest
+// of manifest index in remote registry as well.
+func (rc *RemoteCache) fetch(ctx context.Context, platformMC platforms.MatchComparer) (*ocispec.Descriptor, digest.Digest, error) {
+	resolver, err := rc.provider.Resolver(rc.Ref, remote.OperationPush)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	remoteContext := &containerd.RemoteContext{
 		Resolver:        resolver,
@@ -191,34 +284,34 @@ func (rc *RemoteCache) Fetch(ctx context.Context, platformMC platforms.MatchComp
 	}
 	name, desc, err := remoteContext.Resolver.Resolve(ctx, rc.Ref)
 	if err != nil {
-		return nil, errors.Wrap(err, "resolve remote cache")
+		return nil, "", errors.Wrap(err, "resolve remote cache")
 	}
 	fetcher, err := remoteContext.Resolver.Fetcher(ctx, name)
 	if err != nil {
-		return nil, errors.Wrap(err, "get fetcher for remote cache")
+		return nil, "", errors.Wrap(err, "get fetcher for remote cache")
 	}
 	ir, err := fetcher.Fetch(ctx, desc)
 	if err != nil {
-		return nil, errors.Wrap(err, "fetch remote cache manifest")
+		return nil, "", errors.Wrap(err, "fetch remote cache manifest")
 	}
 	defer ir.Close()
 	mBytes, err := io.ReadAll(ir)
 	if err != nil {
-		return nil, errors.Wrap(err, "read remote cache bytes to manifest index")
+		return nil, "", errors.Wrap(err, "read remote cache bytes to manifest index")
 	}
 
 	switch desc.MediaType {
 	case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
 		manifestIndex := ocispec.Index{}
 		if err = json.Unmarshal(mBytes, &manifestIndex); err != nil {
-			return nil, errors.Wrap(err, "unmarshal remote cache manifest index")
+			return nil, "", errors.Wrap(err, "unmarshal remote cache manifest index")
 		}
 		manifestIndexDesc, _, err := nydusutils.MarshalToDesc(manifestIndex, ocispec.MediaTypeImageIndex)
 		if err != nil {
-			return nil, errors.Wrap(err, "marshal remote cache manifest index")
+			return nil, "", errors.Wrap(err, "marshal remote cache manifest index")
 		}
 		if err = content.WriteBlob(ctx, rc.provider.ContentStore(), rc.Ref, bytes.NewReader(mBytes), *manifestIndexDesc); err != nil {
-			return nil, errors.Wrap(err, "write remote cache manifest index")
+			return nil, "", errors.Wrap(err, "write remote cache manifest index")
 		}
 		for _, manifest := range manifestIndex.Manifests {
 			mDesc := ocispec.Descriptor{
@@ -228,28 +321,28 @@ func (rc *RemoteCache) Fetch(ctx context.Context, platformMC platforms.MatchComp
 			}
 			mir, err := fetcher.Fetch(ctx, mDesc)
 			if err != nil {
-				return nil, errors.Wrap(err, "fetch remote cache manifest")
+				return nil, "", errors.Wrap(err, "fetch remote cache manifest")
 			}
 			manifestBytes, err := io.ReadAll(mir)
 			if err != nil {
-				return nil, errors.Wrap(err, "read remote cache manifest")
+				return nil, "", errors.Wrap(err, "read remote cache manifest")
 			}
 			if err = content.WriteBlob(ctx, rc.provider.ContentStore(), rc.Ref, bytes.NewReader(manifestBytes), mDesc); err != nil {
-				return nil, errors.Wrap(err, "write remote cache manifest")
+				return nil, "", errors.Wrap(err, "write remote cache manifest")
 			}
 		}
 
 		// Get manifests which matches specified platforms and put them into cache records
 		matchDescs, err := utils.GetManifests(ctx, rc.provider.ContentStore(), *manifestIndexDesc, platformMC)
 		if err != nil {
-			return nil, errors.Wrap(err, "get remote cache manifest list")
+			return nil, "", errors.Wrap(err, "get remote cache manifest list")
 		}
 		var targetManifests []ocispec.Manifest
 		for _, desc := range matchDescs {
 			targetManifest := ocispec.Manifest{}
 			_, err = utils.ReadJSON(ctx, rc.provider.ContentStore(), &targetManifest, desc)
 			if err != nil {
-				return nil, errors.Wrap(err, "read remote cache manifest")
+				return nil, "", errors.Wrap(err, "read remote cache manifest")
 			}
 			if targetManifest.Annotations[LayerAnnotationCacheVersion] != rc.version {
 				logrus.WithError(err).Warnf("ignore cache %s, unmatched version: %s, expected: %s", rc.Ref,
@@ -258,44 +351,139 @@ func (rc *RemoteCache) Fetch(ctx context.Context, platformMC platforms.MatchComp
 			}
 			targetManifests = append(targetManifests, targetManifest)
 		}
+		sourceAnnotation := SourceDigestAnnotation(rc.driver, rc.driverVersion)
 		for _, manifest := range targetManifests {
 			for _, targetDesc := range manifest.Layers {
-				sourceDigest := digest.Digest(targetDesc.Annotations[nydusify.LayerAnnotationNydusSourceDigest])
+				sourceDigestStr, ok := targetDesc.Annotations[sourceAnnotation]
+				if !ok {
+					// The layer is cached by other driver or driver version.
+					continue
+				}
+				sourceDigest := digest.Digest(sourceDigestStr)
 				if err := sourceDigest.Validate(); err != nil {
 					logrus.WithError(err).Warnf("invalid cache layer digest record: %s", sourceDigest)
 					continue
 				}
 				reader, sourceDesc, err := fetcher.(remotes.FetcherByDigest).FetchByDigest(ctx, sourceDigest)
 				if err != nil {
-					return nil, errors.Wrap(err, "read remote cache manifest")
+					if errors.Is(err, ctrErrdefs.ErrNotFound) {
+						// The record can be removed by `accelctl cache prune`.
+						logrus.Warnf("ignore cache layer %s, source layer %s not found", targetDesc.Digest, sourceDigest)
+						continue
+					}
+					return nil, "", errors.Wrap(err, "read remote cache manifest")
 				}
 				reader.Close()
 				if targetDesc.Annotations == nil {
 					targetDesc.Annotations = map[string]string{}
 				}
-				targetDesc.Annotations[nydusify.LayerAnnotationUncompressed] = string(targetDesc.Digest)
+				if _, ok := targetDesc.Annotations[labels.LabelUncompressed]; !ok && rc.driver == "nydus" {
+					// The nydus blob is uncompressed.
+					targetDesc.Annotations[labels.LabelUncompressed] = string(targetDesc.Digest)
+				}
+				// The hit statistics are only meaningful in cache manifest.
+				delete(targetDesc.Annotations, LayerAnnotationCacheHitCount)
+				delete(targetDesc.Annotations, LayerAnnotationCacheLastHit)
 				rc.set(sourceDesc, targetDesc)
 			}
 		}
-		return manifestIndexDesc, nil
+		return manifestIndexDesc, desc.Digest, nil
 	default:
-		return nil, fmt.Errorf("unsupported cache image mediatype %s", desc.MediaType)
+		return nil, "", fmt.Errorf("unsupported cache image mediatype %s", desc.MediaType)
 	}
 }
 
 // Push merges local and remote cache records, then push cache manifest to remote registry.
+// The remote cache may be updated by other converters concurrently, the merge is retried
+// if the cache manifest index in remote registry is changed during the push.
 func (rc *RemoteCache) Push(ctx context.Context, orgDesc, newDesc *ocispec.Descriptor, platformMC platforms.MatchComparer) error {
-	// Fetch the remote cache before pushing the new one to avoid conflict .
-	cacheDesc, err := rc.Fetch(ctx, platformMC)
-	if err != nil && !errors.Is(err, ctrErrdefs.ErrNotFound) {
+	if err := rc.mountSources(ctx); err != nil {
 		return err
 	}
-	cacheIndex, err := rc.update(ctx, orgDesc, newDesc, cacheDesc, platformMC)
+	return rc.retryOnConflict(ctx, func() error {
+		// Fetch the remote cache before pushing the new one to avoid conflict.
+		cacheDesc, fetched, err := rc.fetch(ctx, platformMC)
+		if err != nil && !errors.Is(err, ctrErrdefs.ErrNotFound) {
+			return err
+		}
+		cacheIndex, err := rc.update(ctx, orgDesc, newDesc, cacheDesc, platformMC)
+		if err != nil {
+			return err
+		}
+		return rc.pushIndex(ctx, cacheIndex, fetched)
+	})
+}
+
+// mountSources makes the source layers of new cache records available in the
+// repository of remote cache, they're fetched along with the cache records.
+func (rc *RemoteCache) mountSources(ctx context.Context) error {
+	if rc.sourceRef == "" {
+		return nil
+	}
+	refspec, err := reference.Parse(rc.Ref)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "parse remote cache reference")
+	}
+	sourceSpec, err := reference.Parse(rc.sourceRef)
+	if err != nil {
+		return errors.Wrap(err, "parse source reference")
+	}
+	if refspec.Locator == sourceSpec.Locator {
+		return nil
+	}
+
+	sources := []ocispec.Descriptor{}
+	rc.mutex.Lock()
+	for source := range rc.added {
+		if item := rc.records[source]; item != nil {
+			sources = append(sources, item.Source)
+		}
+	}
+	rc.mutex.Unlock()
+
+	for _, source := range sources {
+		source.Annotations, err = remote.AppendDistributionSource(source.Annotations, rc.sourceRef)
+		if err != nil {
+			return err
+		}
+		// The source layer is uploaded if it can't be mounted, for example,
+		// the cache is in another registry.
+		if err := rc.provider.Push(ctx, source, fmt.Sprintf("%s@%s", refspec.Locator, source.Digest)); err != nil {
+			return errors.Wrapf(err, "mount source layer %s", source.Digest)
+		}
+	}
+	return nil
+}
+
+// retryOnConflict calls fn until it isn't failed by ErrConflict, or the max attempts is reached.
+func (rc *RemoteCache) retryOnConflict(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+		if attempt >= maxPushAttempts {
+			return errors.Wrapf(err, "push remote cache after %d attempts", attempt)
+		}
+		logrus.WithError(err).Warnf("retry to push remote cache %s", rc.Ref)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt)*pushRetryInterval + time.Duration(rand.Int63n(int64(pushRetryInterval)))):
+		}
+	}
+}
+
+// pushIndex pushes the cache manifest index and its manifests, ErrConflict is returned
+// if the manifest index in remote registry isn't the fetched one any more.
+func (rc *RemoteCache) pushIndex(ctx context.Context, cacheIndex *ocispec.Index, fetched digest.Digest) error {
+	refspec, err := reference.Parse(rc.Ref)
+	if err != nil {
+		return errors.Wrap(err, "parse remote cache reference")
 	}
 	for _, manifest := range cacheIndex.Manifests {
-		if err := rc.provider.Push(ctx, manifest, rc.Ref); err != nil {
+		// Push the manifest by digest, only the manifest index is tagged.
+		if err := rc.provider.Push(ctx, manifest, fmt.Sprintf("%s@%s", refspec.Locator, manifest.Digest)); err != nil {
 			return err
 		}
 	}
@@ -306,10 +494,48 @@ func (rc *RemoteCache) Push(ctx context.Context, orgDesc, newDesc *ocispec.Descr
 	if err = content.WriteBlob(ctx, rc.provider.ContentStore(), rc.Ref, bytes.NewReader(manifestIndexBytes), *manifestIndexDesc); err != nil {
 		return errors.Wrap(err, "write remote cache manifest index")
 	}
-	return rc.provider.Push(ctx, *manifestIndexDesc, rc.Ref)
+
+	// Re-resolve the remote cache to find the concurrent update during the merge,
+	// the registry supporting If-Match header also rejects the concurrent update
+	// between the resolve and push.
+	current, err := rc.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if current != fetched {
+		return errors.Wrapf(ErrConflict, "manifest index changed from %q to %q", fetched, current)
+	}
+	if fetched != "" {
+		ctx = remote.WithIfMatch(ctx, fetched)
+	}
+	if err := rc.provider.Push(ctx, *manifestIndexDesc, rc.Ref); err != nil {
+		if remote.IsPreconditionFailed(err) {
+			return errors.Wrapf(ErrConflict, "manifest index changed from %q", fetched)
+		}
+		return err
+	}
+	return nil
 }
 
-// update updates cache manifests, it preferentially keep the lower layers if the cache capacity is full.
+// resolve returns the digest of cache manifest index in remote registry,
+// an empty digest is returned if the remote cache doesn't exist.
+func (rc *RemoteCache) resolve(ctx context.Context) (digest.Digest, error) {
+	resolver, err := rc.provider.Resolver(rc.Ref, remote.OperationPush)
+	if err != nil {
+		return "", err
+	}
+	_, desc, err := resolver.Resolve(ctx, rc.Ref)
+	if err != nil {
+		if errors.Is(err, ctrErrdefs.ErrNotFound) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "resolve remote cache")
+	}
+	return desc.Digest, nil
+}
+
+// update updates cache manifests and the hit statistics of cache layers, the least
+// valuable layers are evicted by eviction policy if the cache capacity is full.
 func (rc *RemoteCache) update(ctx context.Context, orgDesc, newDesc, cacheDesc *ocispec.Descriptor,
 	platformMC platforms.MatchComparer) (*ocispec.Index, error) {
 	targetLayersByPlatform := map[*platforms.Platform][]ocispec.Descriptor{}
@@ -362,6 +588,17 @@ func (rc *RemoteCache) update(ctx context.Context, orgDesc, newDesc, cacheDesc *
 		}
 	}
 
+	sourceAnnotation := SourceDigestAnnotation(rc.driver, rc.driverVersion)
+	hits := map[digest.Digest]bool{}
+	for _, layers := range targetLayersByPlatform {
+		for _, layer := range layers {
+			if rc.isHit(digest.Digest(layer.Annotations[sourceAnnotation])) {
+				hits[layer.Digest] = true
+			}
+		}
+	}
+	now := time.Now()
+
 	imageConfig := ocispec.ImageConfig{}
 	imageConfigDesc, imageConfigBytes, err := nydusutils.MarshalToDesc(imageConfig, ocispec.MediaTypeImageConfig)
 	if err != nil {
@@ -394,7 +631,7 @@ func (rc *RemoteCache) update(ctx context.Context, orgDesc, newDesc, cacheDesc *
 					if err != nil {
 						return nil, errors.Wrap(err, "read cache manifest")
 					}
-					manifest.Layers = appendLayers(manifest.Layers, layers, rc.size)
+					manifest.Layers = mergeLayers(manifest.Layers, layers, hits, rc.size, rc.policy, now)
 					// append LayerAnnotationCacheVersion to manifest annotations
 					if manifest.Annotations == nil {
 						manifest.Annotations = map[string]string{}
@@ -419,7 +656,7 @@ func (rc *RemoteCache) update(ctx context.Context, orgDesc, newDesc, cacheDesc *
 			},
 			MediaType: ocispec.MediaTypeImageManifest,
 			Config:    *imageConfigDesc,
-			Layers:    layers,
+			Layers:    mergeLayers(nil, layers, hits, rc.size, rc.policy, now),
 			Annotations: map[string]string{
 				LayerAnnotationCacheVersion: rc.version,
 			},
@@ -457,28 +694,6 @@ func (rc *RemoteCache) getTargetLayers(ctx context.Context, cs content.Store, so
 	return targetLayers, nil
 }
 
-// appendLayers appends new cache layers to cache manifest layers, if the layers
-// already exists, moving them to front.
-func appendLayers(orgDescs, newDescs []ocispec.Descriptor, size int) []ocispec.Descriptor {
-	moveFront := map[digest.Digest]bool{}
-	for _, desc := range orgDescs {
-		moveFront[desc.Digest] = true
-	}
-	mergedLayers := orgDescs
-	for _, desc := range newDescs {
-		if !moveFront[desc.Digest] {
-			mergedLayers = append(mergedLayers, desc)
-			if len(mergedLayers) >= size {
-				break
-			}
-		}
-	}
-	if len(mergedLayers) > size {
-		mergedLayers = mergedLayers[:size]
-	}
-	return mergedLayers
-}
-
 // HitCount returns the hitted and total count of cache layers in a conversion.
 func (rc *RemoteCache) HitCount(ctx context.Context, desc ocispec.Descriptor, platform platforms.MatchComparer) (uint, uint, error) {
 	maniDescs := []ocispec.Descriptor{}
@@ -501,11 +716,10 @@ func (rc *RemoteCache) HitCount(ctx context.Context, desc ocispec.Descriptor, pl
 		}
 		total += uint(len(manifest.Layers))
 		for _, sourceLayer := range manifest.Layers {
-			if item := rc.get(sourceLayer.Digest); item != nil {
+			if rc.isHit(sourceLayer.Digest) {
 				cached++
 			}
 		}
 	}
-	cached -= rc.newAdded
 	return cached, total, nil
 }
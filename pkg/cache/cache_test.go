@@ -0,0 +1,59 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	nydusify "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestAnnotation(t *testing.T) {
+	// Compatible with the cache records created by nydusify.
+	require.Equal(t, nydusify.LayerAnnotationNydusSourceDigest, SourceDigestAnnotation("nydus", ""))
+	require.Equal(t, nydusify.LayerAnnotationNydusTargetDigest, TargetDigestAnnotation("nydus", ""))
+
+	require.Equal(t, "containerd.io/snapshot/estargz-v1-source-digest", SourceDigestAnnotation("estargz", "v1"))
+	require.Equal(t, "containerd.io/snapshot/estargz-v1-target-digest", TargetDigestAnnotation("estargz", "v1"))
+}
+
+func TestSetAndGetTarget(t *testing.T) {
+	ctx, rc := New(context.Background(), "localhost/library/nginx:esgz-cache", "v1", 200, EvictionLRU, nil)
+	rc.SetDriver("estargz", "")
+
+	source := ocispec.Descriptor{Digest: digest.FromString("source")}
+	target := ocispec.Descriptor{
+		Digest:      digest.FromString("target"),
+		Annotations: map[string]string{"containerd.io/uncompressed": digest.FromString("uncompressed").String()},
+	}
+	require.Nil(t, GetTarget(ctx, source.Digest))
+
+	Set(ctx, source, target)
+	// The descriptors of caller aren't modified.
+	require.Nil(t, source.Annotations)
+	require.Len(t, target.Annotations, 1)
+
+	cached := GetTarget(ctx, source.Digest)
+	require.NotNil(t, cached)
+	require.Equal(t, target.Digest, cached.Digest)
+	require.Equal(t, source.Digest.String(), cached.Annotations[SourceDigestAnnotation("estargz", "")])
+
+	_, cachedSource := Get(ctx, source.Digest)
+	require.Equal(t, target.Digest.String(), cachedSource.Annotations[TargetDigestAnnotation("estargz", "")])
+}
@@ -0,0 +1,142 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// LayerAnnotationCacheHitCount records how many conversions hit the cache layer.
+	LayerAnnotationCacheHitCount = "containerd.io/snapshot/cache-hit-count"
+	// LayerAnnotationCacheLastHit records the last time (RFC3339) the cache layer
+	// is hit, or the time it's added if never hit.
+	LayerAnnotationCacheLastHit = "containerd.io/snapshot/cache-last-hit"
+)
+
+// EvictionPolicy decides which cache records are evicted
+// when the cache record capacity is exceeded.
+type EvictionPolicy string
+
+const (
+	// EvictionLRU evicts the least recently hit records.
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionLFU evicts the least frequently hit records,
+	// the least recently hit one is evicted on a tie.
+	EvictionLFU EvictionPolicy = "lfu"
+)
+
+func ParseEvictionPolicy(policy string) (EvictionPolicy, error) {
+	switch EvictionPolicy(policy) {
+	case "":
+		return EvictionLRU, nil
+	case EvictionLRU, EvictionLFU:
+		return EvictionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unsupported cache eviction policy %s", policy)
+	}
+}
+
+type layerStat struct {
+	hitCount int
+	lastHit  time.Time
+}
+
+func getLayerStat(desc ocispec.Descriptor) layerStat {
+	var stat layerStat
+	stat.hitCount, _ = strconv.Atoi(desc.Annotations[LayerAnnotationCacheHitCount])
+	stat.lastHit, _ = time.Parse(time.RFC3339, desc.Annotations[LayerAnnotationCacheLastHit])
+	return stat
+}
+
+func setLayerStat(desc *ocispec.Descriptor, stat layerStat) {
+	desc.Annotations = mergeMap(mergeMap(nil, desc.Annotations), map[string]string{
+		LayerAnnotationCacheHitCount: strconv.Itoa(stat.hitCount),
+		LayerAnnotationCacheLastHit:  stat.lastHit.UTC().Format(time.RFC3339),
+	})
+}
+
+// less reports whether the record a is less valuable than b.
+func (policy EvictionPolicy) less(a, b layerStat) bool {
+	if policy == EvictionLFU && a.hitCount != b.hitCount {
+		return a.hitCount < b.hitCount
+	}
+	return a.lastHit.Before(b.lastHit)
+}
+
+// mergeLayers appends new cache layers to cache manifest layers and updates the
+// hit statistics, the least valuable layers are evicted by policy if the cache
+// capacity is exceeded. The layers used by current conversion are evicted last.
+func mergeLayers(orgDescs, newDescs []ocispec.Descriptor, hits map[digest.Digest]bool, size int, policy EvictionPolicy, now time.Time) []ocispec.Descriptor {
+	merged := []ocispec.Descriptor{}
+	exists := map[digest.Digest]bool{}
+	for _, desc := range orgDescs {
+		exists[desc.Digest] = true
+		merged = append(merged, desc)
+	}
+	used := map[digest.Digest]bool{}
+	for _, desc := range newDescs {
+		used[desc.Digest] = true
+		if !exists[desc.Digest] {
+			exists[desc.Digest] = true
+			setLayerStat(&desc, layerStat{lastHit: now})
+			merged = append(merged, desc)
+		}
+	}
+
+	for idx := range merged {
+		desc := &merged[idx]
+		stat := getLayerStat(*desc)
+		if hits[desc.Digest] {
+			stat.hitCount++
+			stat.lastHit = now
+		}
+		setLayerStat(desc, stat)
+	}
+
+	if len(merged) <= size {
+		return merged
+	}
+
+	order := make([]int, len(merged))
+	for idx := range order {
+		order[idx] = idx
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := merged[order[i]], merged[order[j]]
+		if used[a.Digest] != used[b.Digest] {
+			return !used[a.Digest]
+		}
+		return policy.less(getLayerStat(a), getLayerStat(b))
+	})
+	evicted := map[int]bool{}
+	for _, idx := range order[:len(merged)-size] {
+		evicted[idx] = true
+	}
+
+	kept := []ocispec.Descriptor{}
+	for idx, desc := range merged {
+		if !evicted[idx] {
+			kept = append(kept, desc)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,79 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func testLayer(name string, hitCount int, lastHit time.Time) ocispec.Descriptor {
+	desc := ocispec.Descriptor{Digest: digest.FromString(name)}
+	setLayerStat(&desc, layerStat{hitCount: hitCount, lastHit: lastHit})
+	return desc
+}
+
+func layerDigests(descs []ocispec.Descriptor) []digest.Digest {
+	digests := []digest.Digest{}
+	for _, desc := range descs {
+		digests = append(digests, desc.Digest)
+	}
+	return digests
+}
+
+func TestParseEvictionPolicy(t *testing.T) {
+	policy, err := ParseEvictionPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, EvictionLRU, policy)
+
+	policy, err = ParseEvictionPolicy("lfu")
+	require.NoError(t, err)
+	require.Equal(t, EvictionLFU, policy)
+
+	_, err = ParseEvictionPolicy("fifo")
+	require.Error(t, err)
+}
+
+func TestMergeLayers(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	// frequent is hit many times long ago, recent is hit once recently.
+	frequent := testLayer("frequent", 10, now.Add(-2*time.Hour))
+	recent := testLayer("recent", 1, now.Add(-time.Hour))
+	hit := testLayer("hit", 0, now.Add(-3*time.Hour))
+	added := ocispec.Descriptor{Digest: digest.FromString("added")}
+
+	orgDescs := []ocispec.Descriptor{frequent, recent, hit}
+	newDescs := []ocispec.Descriptor{hit, added}
+	hits := map[digest.Digest]bool{hit.Digest: true}
+
+	merged := mergeLayers(orgDescs, newDescs, hits, 10, EvictionLRU, now)
+	require.Equal(t, []digest.Digest{frequent.Digest, recent.Digest, hit.Digest, added.Digest}, layerDigests(merged))
+	require.Equal(t, layerStat{hitCount: 1, lastHit: now}, getLayerStat(merged[2]))
+	require.Equal(t, layerStat{hitCount: 0, lastHit: now}, getLayerStat(merged[3]))
+	// The descriptors of caller aren't modified.
+	require.Equal(t, layerStat{hitCount: 0, lastHit: now.Add(-3 * time.Hour)}, getLayerStat(hit))
+	require.Nil(t, added.Annotations)
+
+	// The layers used by current conversion are kept even if they're less valuable.
+	merged = mergeLayers(orgDescs, newDescs, hits, 3, EvictionLRU, now)
+	require.Equal(t, []digest.Digest{recent.Digest, hit.Digest, added.Digest}, layerDigests(merged))
+
+	merged = mergeLayers(orgDescs, newDescs, hits, 3, EvictionLFU, now)
+	require.Equal(t, []digest.Digest{frequent.Digest, hit.Digest, added.Digest}, layerDigests(merged))
+}
@@ -0,0 +1,57 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type localCacheKey struct{}
+
+// LayerKey identifies a layer conversion, the converted layer
+// can be reused only if all the fields are matched.
+type LayerKey struct {
+	Source         digest.Digest
+	Driver         string
+	ConfigHash     string
+	BuilderVersion string
+}
+
+func (key LayerKey) String() string {
+	return fmt.Sprintf("%s/%s/%s/%s", key.Driver, key.ConfigHash, key.BuilderVersion, key.Source)
+}
+
+// LocalCache persists the map of source and converted layers on local host,
+// so that the converted layers can be reused without remote cache.
+type LocalCache interface {
+	// Get gets the converted layer by key, a nil descriptor is returned if
+	// the layer isn't cached or the converted blob has been garbage collected.
+	Get(ctx context.Context, key LayerKey) (*ocispec.Descriptor, error)
+	// Set records the converted layer by key.
+	Set(ctx context.Context, key LayerKey, target ocispec.Descriptor) error
+}
+
+func WithLocalCache(ctx context.Context, lc LocalCache) context.Context {
+	return context.WithValue(ctx, localCacheKey{}, lc)
+}
+
+func GetLocalCache(ctx context.Context) LocalCache {
+	lc, _ := ctx.Value(localCacheKey{}).(LocalCache)
+	return lc
+}
@@ -0,0 +1,277 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	ctrErrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+)
+
+// Record is a cache record in the remote cache manifest.
+type Record struct {
+	Platform string
+	Version  string
+	// Driver is the driver and driver version namespacing the record.
+	Driver     string
+	Source     digest.Digest
+	SourceSize int64
+	Target     ocispec.Descriptor
+	HitCount   int
+	LastHit    time.Time
+}
+
+// parseSource gets the namespace and source layer digest recorded on the target layer.
+func parseSource(desc ocispec.Descriptor) (string, digest.Digest, bool) {
+	prefix, suffix := "containerd.io/snapshot/", "-source-digest"
+	for key, value := range desc.Annotations {
+		if strings.HasPrefix(key, prefix) && strings.HasSuffix(key, suffix) && len(key) > len(prefix)+len(suffix) {
+			source, err := digest.Parse(value)
+			if err != nil {
+				continue
+			}
+			return key[len(prefix) : len(key)-len(suffix)], source, true
+		}
+	}
+	return "", "", false
+}
+
+// blobResolver resolves the blobs in the repository of remote cache.
+type blobResolver struct {
+	resolver remotes.Resolver
+	locator  string
+	resolved map[digest.Digest]*ocispec.Descriptor
+}
+
+func (rc *RemoteCache) newBlobResolver() (*blobResolver, error) {
+	refspec, err := reference.Parse(rc.Ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse remote cache reference")
+	}
+	resolver, err := rc.provider.Resolver(rc.Ref, remote.OperationPush)
+	if err != nil {
+		return nil, err
+	}
+	return &blobResolver{
+		resolver: resolver,
+		locator:  refspec.Locator,
+		resolved: make(map[digest.Digest]*ocispec.Descriptor),
+	}, nil
+}
+
+// resolve returns nil if the blob doesn't exist.
+func (br *blobResolver) resolve(ctx context.Context, dgst digest.Digest) (*ocispec.Descriptor, error) {
+	if desc, ok := br.resolved[dgst]; ok {
+		return desc, nil
+	}
+	_, desc, err := br.resolver.Resolve(ctx, fmt.Sprintf("%s@%s", br.locator, dgst))
+	if err != nil {
+		if !errors.Is(err, ctrErrdefs.ErrNotFound) {
+			return nil, errors.Wrapf(err, "resolve blob %s", dgst)
+		}
+		br.resolved[dgst] = nil
+		return nil, nil
+	}
+	br.resolved[dgst] = &desc
+	return &desc, nil
+}
+
+// fetchIndex fetches the remote cache of all platforms, and returns the manifest
+// index and its digest in remote registry.
+func (rc *RemoteCache) fetchIndex(ctx context.Context) (*ocispec.Index, digest.Digest, error) {
+	cacheDesc, fetched, err := rc.fetch(ctx, platforms.All)
+	if err != nil {
+		return nil, "", err
+	}
+	var index ocispec.Index
+	if _, err := utils.ReadJSON(ctx, rc.provider.ContentStore(), &index, *cacheDesc); err != nil {
+		return nil, "", errors.Wrap(err, "read cache manifest index")
+	}
+	return &index, fetched, nil
+}
+
+// Records lists the cache records of all platforms in remote cache, including
+// the records of other drivers and cache versions.
+func (rc *RemoteCache) Records(ctx context.Context) ([]Record, error) {
+	index, _, err := rc.fetchIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := rc.newBlobResolver()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []Record{}
+	for _, maniDesc := range index.Manifests {
+		var manifest ocispec.Manifest
+		if _, err := utils.ReadJSON(ctx, rc.provider.ContentStore(), &manifest, maniDesc); err != nil {
+			return nil, errors.Wrap(err, "read cache manifest")
+		}
+		platform := ""
+		if maniDesc.Platform != nil {
+			platform = platforms.Format(*maniDesc.Platform)
+		}
+		for _, layer := range manifest.Layers {
+			driver, source, ok := parseSource(layer)
+			if !ok {
+				continue
+			}
+			stat := getLayerStat(layer)
+			record := Record{
+				Platform: platform,
+				Version:  manifest.Annotations[LayerAnnotationCacheVersion],
+				Driver:   driver,
+				Source:   source,
+				Target:   layer,
+				HitCount: stat.hitCount,
+				LastHit:  stat.lastHit,
+			}
+			sourceDesc, err := blobs.resolve(ctx, source)
+			if err != nil {
+				return nil, err
+			}
+			if sourceDesc != nil {
+				record.SourceSize = sourceDesc.Size
+			}
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// Prune removes the cache manifests of which the cache version isn't matched,
+// and the cache records of which the source or target blob doesn't exist in
+// remote registry. It returns the number of removed records.
+func (rc *RemoteCache) Prune(ctx context.Context) (int, error) {
+	var pruned int
+	err := rc.retryOnConflict(ctx, func() error {
+		pruned = 0
+		index, fetched, err := rc.fetchIndex(ctx)
+		if err != nil {
+			return err
+		}
+		blobs, err := rc.newBlobResolver()
+		if err != nil {
+			return err
+		}
+
+		manifests := []ocispec.Descriptor{}
+		for _, maniDesc := range index.Manifests {
+			var manifest ocispec.Manifest
+			if _, err := utils.ReadJSON(ctx, rc.provider.ContentStore(), &manifest, maniDesc); err != nil {
+				return errors.Wrap(err, "read cache manifest")
+			}
+			if manifest.Annotations[LayerAnnotationCacheVersion] != rc.version {
+				pruned += len(manifest.Layers)
+				continue
+			}
+
+			layers := []ocispec.Descriptor{}
+			for _, layer := range manifest.Layers {
+				_, source, ok := parseSource(layer)
+				if !ok {
+					pruned++
+					continue
+				}
+				sourceDesc, err := blobs.resolve(ctx, source)
+				if err != nil {
+					return err
+				}
+				targetDesc, err := blobs.resolve(ctx, layer.Digest)
+				if err != nil {
+					return err
+				}
+				if sourceDesc == nil || targetDesc == nil {
+					pruned++
+					continue
+				}
+				layers = append(layers, layer)
+			}
+			if len(layers) == 0 {
+				continue
+			}
+			if len(layers) != len(manifest.Layers) {
+				manifest.Layers = layers
+				newManiDesc, err := utils.WriteJSON(ctx, rc.provider.ContentStore(), manifest, maniDesc, "", nil)
+				if err != nil {
+					return errors.Wrap(err, "write cache manifest")
+				}
+				maniDesc = *newManiDesc
+			}
+			manifests = append(manifests, maniDesc)
+		}
+
+		if pruned == 0 {
+			return nil
+		}
+		index.Manifests = manifests
+		return rc.pushIndex(ctx, index, fetched)
+	})
+	return pruned, err
+}
+
+// Migrate rewrites the cache manifests of current cache version to the new version,
+// so that the cache records can be reused after changing the cache version. It
+// returns the number of migrated cache manifests.
+func (rc *RemoteCache) Migrate(ctx context.Context, version string) (int, error) {
+	var migrated int
+	err := rc.retryOnConflict(ctx, func() error {
+		migrated = 0
+		index, fetched, err := rc.fetchIndex(ctx)
+		if err != nil {
+			return err
+		}
+
+		for idx, maniDesc := range index.Manifests {
+			var manifest ocispec.Manifest
+			if _, err := utils.ReadJSON(ctx, rc.provider.ContentStore(), &manifest, maniDesc); err != nil {
+				return errors.Wrap(err, "read cache manifest")
+			}
+			if manifest.Annotations[LayerAnnotationCacheVersion] != rc.version {
+				continue
+			}
+			if manifest.Annotations == nil {
+				manifest.Annotations = map[string]string{}
+			}
+			manifest.Annotations[LayerAnnotationCacheVersion] = version
+			newManiDesc, err := utils.WriteJSON(ctx, rc.provider.ContentStore(), manifest, maniDesc, "", nil)
+			if err != nil {
+				return errors.Wrap(err, "write cache manifest")
+			}
+			index.Manifests[idx] = *newManiDesc
+			migrated++
+		}
+
+		if migrated == 0 {
+			return nil
+		}
+		return rc.pushIndex(ctx, index, fetched)
+	})
+	return migrated, err
+}
@@ -0,0 +1,97 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+func newTestCache(t *testing.T, ref, version string) (context.Context, *RemoteCache) {
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	return New(context.Background(), ref, version, 200, EvictionLRU, &testProvider{store: store})
+}
+
+func recordSources(t *testing.T, ctx context.Context, rc *RemoteCache) map[digest.Digest]Record {
+	records, err := rc.Records(ctx)
+	require.NoError(t, err)
+	sources := map[digest.Digest]Record{}
+	for _, record := range records {
+		sources[record.Source] = record
+	}
+	return sources
+}
+
+func TestMaintain(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ref := registry.Host() + "/library/app:cache"
+
+	a := newTestWriter(t, registry, ref, "a")
+	require.NoError(t, a.push())
+	b := newTestWriter(t, registry, ref, "b")
+	require.NoError(t, b.push())
+
+	ctx, rc := newTestCache(t, ref, "v1")
+	records := recordSources(t, ctx, rc)
+	require.Len(t, records, 2)
+	require.Equal(t, Record{
+		Platform:   platforms.Format(platforms.DefaultSpec()),
+		Version:    "v1",
+		Driver:     "nydus",
+		Source:     a.source.Digest,
+		SourceSize: a.source.Size,
+		Target:     records[a.source.Digest].Target,
+		LastHit:    records[a.source.Digest].LastHit,
+	}, records[a.source.Digest])
+	require.Equal(t, a.target.Digest, records[a.source.Digest].Target.Digest)
+
+	// Nothing to prune.
+	pruned, err := rc.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, pruned)
+
+	// The record of missing target blob is pruned.
+	registry.DeleteBlob(b.target.Digest)
+	pruned, err = rc.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	records = recordSources(t, ctx, rc)
+	require.Len(t, records, 1)
+	require.Contains(t, records, a.source.Digest)
+
+	migrated, err := rc.Migrate(ctx, "v2")
+	require.NoError(t, err)
+	require.Equal(t, 1, migrated)
+	ctx, rc = newTestCache(t, ref, "v2")
+	_, err = rc.Fetch(ctx, platforms.All)
+	require.NoError(t, err)
+	require.NotNil(t, GetTarget(ctx, a.source.Digest))
+
+	// The records of mismatched cache version are pruned.
+	ctx, rc = newTestCache(t, ref, "v3")
+	pruned, err = rc.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+	require.Empty(t, recordSources(t, ctx, rc))
+}
@@ -0,0 +1,293 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+type testProvider struct {
+	store   content.Store
+	mirrors []remote.Mirror
+}
+
+func (pvd *testProvider) Resolver(_ string, op remote.Operation) (remotes.Resolver, error) {
+	hostConfig := &remote.HostConfig{PlainHTTP: true, Mirrors: pvd.mirrors}
+	return remote.NewHostResolver(hostConfig.For(op), nil, nil), nil
+}
+
+func (pvd *testProvider) Pull(_ context.Context, _ string) error {
+	return nil
+}
+
+func (pvd *testProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	resolver, _ := pvd.Resolver(ref, remote.OperationPush)
+	if !strings.Contains(ref, "@") {
+		ref = ref + "@" + desc.Digest.String()
+	}
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return remotes.PushContent(ctx, pusher, desc, pvd.store, nil, platforms.All, nil)
+}
+
+func (pvd *testProvider) ContentStore() content.Store {
+	return pvd.store
+}
+
+// testWriter converts an image of single layer, and pushes the remote cache.
+type testWriter struct {
+	ctx    context.Context
+	cache  *RemoteCache
+	image  ocispec.Descriptor
+	source ocispec.Descriptor
+	target ocispec.Descriptor
+}
+
+func writeJSON(t *testing.T, ctx context.Context, store content.Store, mediaType string, v interface{}) ocispec.Descriptor {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return writeBlob(t, ctx, store, mediaType, data)
+}
+
+func writeBlob(t *testing.T, ctx context.Context, store content.Store, mediaType string, data []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(data), desc))
+	return desc
+}
+
+func newTestWriter(t *testing.T, registry *registrytest.Registry, ref, name string, mirrors ...remote.Mirror) *testWriter {
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	pvd := &testProvider{store: store, mirrors: mirrors}
+	ctx := context.Background()
+
+	source := writeBlob(t, ctx, store, ocispec.MediaTypeImageLayerGzip, []byte("source-"+name))
+	target := writeBlob(t, ctx, store, ocispec.MediaTypeImageLayer, []byte("target-"+name))
+	config := writeJSON(t, ctx, store, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: platforms.DefaultSpec(),
+	})
+	image := writeJSON(t, ctx, store, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{source},
+	})
+	sourceRef := fmt.Sprintf("%s/library/%s:latest", registry.Host(), name)
+	require.NoError(t, pvd.Push(ctx, image, sourceRef))
+
+	ctx, rc := New(ctx, ref, "v1", 200, EvictionLRU, pvd)
+	rc.SetSource(sourceRef)
+	Set(ctx, source, target)
+
+	return &testWriter{ctx: ctx, cache: rc, image: image, source: source, target: target}
+}
+
+func (writer *testWriter) push() error {
+	return writer.cache.Push(writer.ctx, &writer.image, &writer.image, platforms.All)
+}
+
+func TestPushSharedCache(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ref := registry.Host() + "/library/shared-cache:latest"
+
+	var mounted int32
+	registry.OnRequest = func(req *http.Request) {
+		if req.Method == http.MethodPost && req.URL.Query().Get("mount") != "" {
+			atomic.AddInt32(&mounted, 1)
+		}
+	}
+
+	a := newTestWriter(t, registry, ref, "a")
+	require.NoError(t, a.push())
+	b := newTestWriter(t, registry, ref, "b")
+	require.NoError(t, b.push())
+
+	// The source layers are mounted to the shared cache repository.
+	require.Equal(t, int32(2), atomic.LoadInt32(&mounted))
+	require.True(t, registry.HasBlob("library/shared-cache", a.source.Digest))
+	require.True(t, registry.HasBlob("library/shared-cache", b.source.Digest))
+	requireCached(t, ref, a, b)
+}
+
+func TestPushWithMirror(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	mirror := registrytest.New()
+	defer mirror.Close()
+	ref := registry.Host() + "/library/app:cache"
+
+	// The mirror serves a stale cache index without the records of other.
+	stale := newTestWriter(t, mirror, mirror.Host()+"/library/app:cache", "base")
+	require.NoError(t, stale.push())
+	base := newTestWriter(t, registry, ref, "base")
+	require.NoError(t, base.push())
+	other := newTestWriter(t, registry, ref, "other")
+	require.NoError(t, other.push())
+
+	// The cache is resolved and fetched from origin for pushing.
+	a := newTestWriter(t, registry, ref, "a", remote.Mirror{Scheme: "http", Host: mirror.Host(), Path: "/v2"})
+	var mirrored int32
+	mirror.OnRequest = func(*http.Request) {
+		atomic.AddInt32(&mirrored, 1)
+	}
+	require.NoError(t, a.push())
+	require.Zero(t, atomic.LoadInt32(&mirrored))
+	requireCached(t, ref, base, other, a)
+}
+
+func TestFetchDriverVersion(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ref := registry.Host() + "/library/app:cache"
+
+	a := newTestWriter(t, registry, ref, "a")
+	a.cache.SetDriver("estargz", "v1")
+	Set(a.ctx, a.source, a.target)
+	require.NoError(t, a.push())
+
+	fetch := func(version string) *ocispec.Descriptor {
+		store, err := local.NewStore(t.TempDir())
+		require.NoError(t, err)
+		ctx, rc := New(context.Background(), ref, "v1", 200, EvictionLRU, &testProvider{store: store})
+		rc.SetDriver("estargz", version)
+		_, err = rc.Fetch(ctx, platforms.All)
+		require.NoError(t, err)
+		return GetTarget(ctx, a.source.Digest)
+	}
+	require.NotNil(t, fetch("v1"))
+	// The records of another driver version aren't reused.
+	require.Nil(t, fetch("v2"))
+}
+
+// requireCached checks the remote cache contains the records of all writers.
+func requireCached(t *testing.T, ref string, writers ...*testWriter) {
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	ctx, rc := New(context.Background(), ref, "v1", 200, EvictionLRU, &testProvider{store: store})
+	_, err = rc.Fetch(ctx, platforms.All)
+	require.NoError(t, err)
+	for _, writer := range writers {
+		target := GetTarget(ctx, writer.source.Digest)
+		require.NotNil(t, target)
+		require.Equal(t, writer.target.Digest, target.Digest)
+	}
+}
+
+// onceBefore calls fn once before the first request matched by match.
+func onceBefore(registry *registrytest.Registry, match func(req *http.Request) bool, fn func()) {
+	var called int32
+	registry.OnRequest = func(req *http.Request) {
+		if match(req) && atomic.CompareAndSwapInt32(&called, 0, 1) {
+			fn()
+		}
+	}
+}
+
+func isTagPush(req *http.Request) bool {
+	return req.Method == http.MethodPut && strings.HasSuffix(req.URL.Path, "/manifests/cache")
+}
+
+func TestPushConflictWithIfMatch(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	registry.IfMatch = true
+	ref := registry.Host() + "/library/app:cache"
+
+	base := newTestWriter(t, registry, ref, "base")
+	require.NoError(t, base.push())
+
+	a := newTestWriter(t, registry, ref, "a")
+	b := newTestWriter(t, registry, ref, "b")
+	// The writer b updates the cache between the re-resolve and tag push of writer a.
+	onceBefore(registry, isTagPush, func() {
+		require.NoError(t, b.push())
+	})
+	require.NoError(t, a.push())
+
+	requireCached(t, ref, base, a, b)
+}
+
+func TestPushConflictWithoutIfMatch(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ref := registry.Host() + "/library/app:cache"
+
+	base := newTestWriter(t, registry, ref, "base")
+	require.NoError(t, base.push())
+
+	a := newTestWriter(t, registry, ref, "a")
+	b := newTestWriter(t, registry, ref, "b")
+	// The writer b updates the cache between the fetch and re-resolve of writer a.
+	onceBefore(registry, func(req *http.Request) bool {
+		return req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/sha256:")
+	}, func() {
+		require.NoError(t, b.push())
+	})
+	require.NoError(t, a.push())
+
+	requireCached(t, ref, base, a, b)
+}
+
+func TestPushConflictExhausted(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	registry.IfMatch = true
+	ref := registry.Host() + "/library/app:cache"
+
+	base := newTestWriter(t, registry, ref, "base")
+	require.NoError(t, base.push())
+
+	// Other writers always update the cache before the tag push.
+	var updating, updated int32
+	registry.OnRequest = func(req *http.Request) {
+		if isTagPush(req) && atomic.CompareAndSwapInt32(&updating, 0, 1) {
+			defer atomic.StoreInt32(&updating, 0)
+			other := newTestWriter(t, registry, ref, fmt.Sprintf("other-%d", atomic.AddInt32(&updated, 1)))
+			require.NoError(t, other.push())
+		}
+	}
+
+	a := newTestWriter(t, registry, ref, "a")
+	err := a.push()
+	require.True(t, errors.Is(err, ErrConflict))
+	require.Equal(t, int32(maxPushAttempts), atomic.LoadInt32(&updated))
+}
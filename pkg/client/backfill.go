@@ -0,0 +1,60 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/backfill"
+	"github.com/goharbor/acceleration-service/pkg/model"
+)
+
+func (client *Client) CreateBackfill(req model.BackfillRequest) (*backfill.Job, error) {
+	data, err := marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Request(http.MethodPost, "/api/v1/backfills", data, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var job backfill.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	return &job, nil
+}
+
+func (client *Client) ListBackfills() ([]backfill.Job, error) {
+	resp, err := client.Request(http.MethodGet, "/api/v1/backfills", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jobs []backfill.Job
+	if err := json.NewDecoder(resp.Body).Decode(&jobs); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,266 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/task"
+)
+
+// BatchEntry is an image to convert in batch.
+type BatchEntry struct {
+	Source string `yaml:"source"`
+	// Target is the target reference, created by the rules of acceld if empty.
+	Target    string           `yaml:"target"`
+	Overrides *model.Overrides `yaml:"overrides"`
+}
+
+// BatchResult is the result of creating the conversion task of an entry.
+type BatchResult struct {
+	Entry BatchEntry
+	// TaskID is the ID of created task.
+	TaskID  string
+	Err     error
+	Elapsed time.Duration
+}
+
+// ParseBatchFile parses the images to convert from file. A yaml file (`.yaml`
+// or `.yml`) has a list of entries in `images`:
+//
+//	images:
+//	  - source: 192.168.1.1/library/nginx:latest
+//	    target: 192.168.1.1/library/nginx:latest-custom
+//	    overrides:
+//	      platforms: linux/amd64
+//
+// Otherwise each line of file is a source with an optional target separated
+// by whitespace, the empty lines and the lines starting with `#` are ignored.
+func ParseBatchFile(path string) ([]BatchEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file %s", path)
+	}
+
+	var entries []BatchEntry
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		var file struct {
+			Images []BatchEntry `yaml:"images"`
+		}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, errors.Wrapf(err, "parse yaml file %s", path)
+		}
+		entries = file.Images
+	default:
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) > 2 {
+				return nil, fmt.Errorf("invalid line %d of %s: expected source and optional target", lineNo, path)
+			}
+			entry := BatchEntry{Source: fields[0]}
+			if len(fields) == 2 {
+				entry.Target = fields[1]
+			}
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrapf(err, "read file %s", path)
+		}
+	}
+
+	for idx, entry := range entries {
+		if entry.Source == "" {
+			return nil, fmt.Errorf("source of entry %d is required", idx+1)
+		}
+	}
+
+	return entries, nil
+}
+
+// overrides returns the overrides of entry with the target.
+func (entry *BatchEntry) overrides() *model.Overrides {
+	if entry.Target == "" {
+		return entry.Overrides
+	}
+	overrides := model.Overrides{}
+	if entry.Overrides != nil {
+		overrides = *entry.Overrides
+	}
+	overrides.Target = entry.Target
+	return &overrides
+}
+
+// taskPollInterval is the interval of polling the task list for the
+// tasks waited by CreateTasks.
+var taskPollInterval = 2 * time.Second
+
+// maxTaskListRetries is the max retries of polling the task list in a row,
+// the interval is doubled on each retry.
+const maxTaskListRetries = 3
+
+// BatchOptions is the options of creating the tasks in batch.
+type BatchOptions struct {
+	// Concurrency is the max number of requests sent at the same time.
+	Concurrency int
+	// Wait polls the task list until all the created tasks are finished.
+	Wait bool
+	// Timeout is the max duration of waiting for the tasks, no limit if zero.
+	Timeout time.Duration
+}
+
+// CreateTasks creates the asynchronous conversion tasks of entries, the
+// conversions are queued by the workers of acceld. If opts.Wait is true,
+// the task list is polled until all the created tasks are finished. The
+// done function is called serially once a task is created (or finished
+// if wait).
+func (client *Client) CreateTasks(entries []BatchEntry, opts BatchOptions, done func(result BatchResult)) []BatchResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]BatchResult, len(entries))
+	var (
+		wg        sync.WaitGroup
+		doneMutex sync.Mutex
+	)
+	sem := make(chan struct{}, concurrency)
+	for idx := range entries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(idx int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			entry := entries[idx]
+			start := time.Now()
+			taskID, err := client.CreateTask(entry.Source, false, entry.overrides())
+			results[idx] = BatchResult{
+				Entry:   entry,
+				TaskID:  taskID,
+				Err:     err,
+				Elapsed: time.Since(start),
+			}
+			if done != nil && (!opts.Wait || err != nil) {
+				doneMutex.Lock()
+				defer doneMutex.Unlock()
+				done(results[idx])
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	if opts.Wait {
+		client.waitTasks(results, opts.Timeout, done)
+	}
+
+	return results
+}
+
+// waitTasks polls the task list until the tasks of the created entries are
+// finished, or the timeout is exceeded.
+func (client *Client) waitTasks(results []BatchResult, timeout time.Duration, done func(result BatchResult)) {
+	pending := map[int]bool{}
+	finish := func(idx int, err error) {
+		results[idx].Err = err
+		delete(pending, idx)
+		if done != nil {
+			done(results[idx])
+		}
+	}
+	for idx, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		pending[idx] = true
+		if result.TaskID == "" {
+			finish(idx, errors.New("task ID isn't reported by acceld, upgrade acceld to wait for the task"))
+		}
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	failures := 0
+	for len(pending) > 0 {
+		time.Sleep(taskPollInterval << failures)
+		tasks, err := client.ListTask()
+		if err != nil {
+			// The conversions keep running in acceld, retry on transient errors.
+			if failures++; failures > maxTaskListRetries {
+				for idx := range results {
+					if pending[idx] {
+						finish(idx, errors.Wrap(err, "list tasks"))
+					}
+				}
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		byID := make(map[string]task.Task, len(tasks))
+		for _, item := range tasks {
+			byID[item.ID] = item
+		}
+		for idx := range results {
+			if !pending[idx] {
+				continue
+			}
+			item, ok := byID[results[idx].TaskID]
+			if !ok {
+				finish(idx, fmt.Errorf("task %s isn't found in acceld, it may be restarted", results[idx].TaskID))
+				continue
+			}
+			if item.Status == task.StatusProcessing {
+				continue
+			}
+			results[idx].Elapsed = item.Finished.Sub(item.Created)
+			if item.Status != task.StatusFailed {
+				finish(idx, nil)
+			} else if item.Code != "" {
+				finish(idx, fmt.Errorf("%s: %s", item.Code, item.Reason))
+			} else {
+				finish(idx, errors.New(item.Reason))
+			}
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			for idx := range results {
+				if pending[idx] {
+					finish(idx, fmt.Errorf("task %s isn't finished in %s", results[idx].TaskID, timeout))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/server/util"
+	"github.com/goharbor/acceleration-service/pkg/task"
+)
+
+func TestParseBatchFile(t *testing.T) {
+	dir := t.TempDir()
+
+	txt := filepath.Join(dir, "images.txt")
+	require.NoError(t, os.WriteFile(txt, []byte(`
+# catalog
+192.168.1.1/library/nginx:latest
+192.168.1.1/library/redis:7 192.168.1.1/library/redis:7-custom
+`), 0644))
+	entries, err := ParseBatchFile(txt)
+	require.NoError(t, err)
+	require.Equal(t, []BatchEntry{
+		{Source: "192.168.1.1/library/nginx:latest"},
+		{Source: "192.168.1.1/library/redis:7", Target: "192.168.1.1/library/redis:7-custom"},
+	}, entries)
+
+	yml := filepath.Join(dir, "images.yaml")
+	require.NoError(t, os.WriteFile(yml, []byte(`
+images:
+  - source: 192.168.1.1/library/nginx:latest
+    overrides:
+      platforms: linux/amd64
+      driver_config:
+        fs_version: "5"
+  - source: 192.168.1.1/library/redis:7
+    target: 192.168.1.1/library/redis:7-custom
+`), 0644))
+	entries, err = ParseBatchFile(yml)
+	require.NoError(t, err)
+	require.Equal(t, []BatchEntry{
+		{
+			Source: "192.168.1.1/library/nginx:latest",
+			Overrides: &model.Overrides{
+				Platforms:    "linux/amd64",
+				DriverConfig: map[string]string{"fs_version": "5"},
+			},
+		},
+		{Source: "192.168.1.1/library/redis:7", Target: "192.168.1.1/library/redis:7-custom"},
+	}, entries)
+
+	require.NoError(t, os.WriteFile(txt, []byte("a b c\n"), 0644))
+	_, err = ParseBatchFile(txt)
+	require.Error(t, err)
+}
+
+func TestCreateTasks(t *testing.T) {
+	var running, maxRunning int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		var payload model.ConversionPayload
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+		source := payload.EventData.Resources[0].ResourceURL
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(source, "bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":"ERR_ILLEGAL_PARAMETER","message":"bad source"}`))
+			return
+		}
+		if source == "192.168.1.1/library/redis:7" {
+			require.Equal(t, "192.168.1.1/library/redis:7-custom", payload.Overrides.Target)
+		}
+		_, _ = w.Write([]byte(`"Ok"`))
+	}))
+	defer server.Close()
+
+	entries := []BatchEntry{
+		{Source: "192.168.1.1/library/nginx:latest"},
+		{Source: "192.168.1.1/library/redis:7", Target: "192.168.1.1/library/redis:7-custom"},
+		{Source: "192.168.1.1/library/bad:latest"},
+		{Source: "192.168.1.1/library/alpine:latest"},
+		{Source: "192.168.1.1/library/busybox:latest"},
+	}
+	var done int
+	results := NewClient(strings.TrimPrefix(server.URL, "http://")).CreateTasks(entries, BatchOptions{Concurrency: 2}, func(BatchResult) {
+		done++
+	})
+	require.Equal(t, len(entries), done)
+	require.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(2))
+	for idx, result := range results {
+		require.Equal(t, entries[idx], result.Entry)
+		if idx == 2 {
+			require.Error(t, result.Err)
+		} else {
+			require.NoError(t, result.Err)
+		}
+	}
+}
+
+func TestCreateTasksWait(t *testing.T) {
+	taskPollInterval = time.Millisecond
+	defer func() {
+		taskPollInterval = 2 * time.Second
+	}()
+
+	var (
+		mutex   sync.Mutex
+		tasks   []task.Task
+		targets = map[string]string{}
+		created int
+		polled  int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method == http.MethodGet {
+			// The transient error of listing tasks is retried.
+			if polled++; polled == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"code":"ERR_UNKNOWN","message":"unavailable"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(tasks)
+			// The tasks are finished on the next poll.
+			for idx := range tasks {
+				if tasks[idx].Status != task.StatusProcessing || strings.Contains(tasks[idx].Source, "stuck") {
+					continue
+				}
+				tasks[idx].Status = task.StatusCompleted
+				if strings.Contains(targets[tasks[idx].ID], "bad") {
+					tasks[idx].Status = task.StatusFailed
+					tasks[idx].Code = "ERR_CONVERT_FAILED"
+					tasks[idx].Reason = "bad image"
+				}
+			}
+			return
+		}
+
+		// The conversion is queued in acceld instead of blocking the request.
+		require.Equal(t, "false", req.URL.Query().Get("sync"))
+		var payload model.ConversionPayload
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+		source := payload.EventData.Resources[0].ResourceURL
+		id := fmt.Sprintf("task-%d", created)
+		created++
+		if payload.Overrides != nil {
+			targets[id] = payload.Overrides.Target
+		}
+		// The task is lost if acceld is restarted.
+		if !strings.Contains(source, "lost") {
+			tasks = append(tasks, task.Task{ID: id, Created: time.Now(), Source: source, Status: task.StatusProcessing})
+		}
+		w.Header().Set(util.HeaderTaskID, id)
+		_, _ = w.Write([]byte(`"Ok"`))
+	}))
+	defer server.Close()
+
+	// The tasks of the same source are told apart by ID.
+	entries := []BatchEntry{
+		{Source: "192.168.1.1/library/nginx:latest"},
+		{Source: "192.168.1.1/library/nginx:latest", Target: "192.168.1.1/library/nginx:bad"},
+		{Source: "192.168.1.1/library/stuck:latest"},
+		{Source: "192.168.1.1/library/lost:latest"},
+	}
+	var done []string
+	results := NewClient(strings.TrimPrefix(server.URL, "http://")).CreateTasks(entries, BatchOptions{
+		Concurrency: 1,
+		Wait:        true,
+		Timeout:     100 * time.Millisecond,
+	}, func(result BatchResult) {
+		done = append(done, result.TaskID)
+	})
+	require.ElementsMatch(t, []string{"task-0", "task-1", "task-2", "task-3"}, done)
+	require.NoError(t, results[0].Err)
+	require.EqualError(t, results[1].Err, "ERR_CONVERT_FAILED: bad image")
+	require.ErrorContains(t, results[2].Err, "task task-2 isn't finished")
+	require.ErrorContains(t, results[3].Err, "task task-3 isn't found")
+}
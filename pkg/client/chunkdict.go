@@ -0,0 +1,20 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+)
+
+func (client *Client) BuildChunkDict(repository string) error {
+	path := "/api/v1/chunkdicts"
+	if repository != "" {
+		path += "?repository=" + url.QueryEscape(repository)
+	}
+	resp, err := client.Request(http.MethodPost, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
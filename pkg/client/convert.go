@@ -7,35 +7,42 @@ import (
 	"strconv"
 
 	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/server/util"
 	"github.com/goharbor/acceleration-service/pkg/task"
 	"github.com/pkg/errors"
 )
 
-func (client *Client) CreateTask(src string, sync bool) error {
-	payload := model.Payload{
-		Type: model.TopicPushArtifact,
-		EventData: &model.EventData{
-			Resources: []*model.Resource{
-				{
-					ResourceURL: src,
+// CreateTask creates a conversion task of source, the configuration of
+// acceld is overridden for the task if overrides isn't nil. The ID of
+// created task is returned, it's empty if acceld doesn't report it.
+func (client *Client) CreateTask(src string, sync bool, overrides *model.Overrides) (string, error) {
+	payload := model.ConversionPayload{
+		Payload: model.Payload{
+			Type: model.TopicPushArtifact,
+			EventData: &model.EventData{
+				Resources: []*model.Resource{
+					{
+						ResourceURL: src,
+					},
 				},
 			},
 		},
+		Overrides: overrides,
 	}
 
 	data, err := marshal(payload)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	path := fmt.Sprintf("/api/v1/conversions?sync=%s", strconv.FormatBool(sync))
 	resp, err := client.Request(http.MethodPost, path, data, nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	return nil
+	return resp.Header.Get(util.HeaderTaskID), nil
 }
 
 func (client *Client) ListTask() ([]task.Task, error) {
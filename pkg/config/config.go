@@ -20,6 +20,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/docker/cli/cli/config"
 	"github.com/goharbor/acceleration-service/pkg/remote"
@@ -33,6 +34,9 @@ type Config struct {
 
 	Provider  ProviderConfig  `yaml:"provider"`
 	Converter ConverterConfig `yaml:"converter"`
+
+	// credentials caches the credential provider of each source host.
+	credentials sync.Map
 }
 
 type ServerConfig struct {
@@ -47,11 +51,58 @@ type MetricConfig struct {
 }
 
 type ProviderConfig struct {
-	Source       map[string]SourceConfig `yaml:"source"`
-	WorkDir      string                  `yaml:"work_dir"`
-	GCPolicy     GCPolicy                `yaml:"gcpolicy"`
-	CacheSize    int                     `yaml:"cache_size"`
-	CacheVersion string                  `yaml:"cache_version"`
+	Source        map[string]SourceConfig `yaml:"source"`
+	WorkDir       string                  `yaml:"work_dir"`
+	GCPolicy      GCPolicy                `yaml:"gcpolicy"`
+	CacheSize     int                     `yaml:"cache_size"`
+	CacheVersion  string                  `yaml:"cache_version"`
+	CacheEviction string                  `yaml:"cache_eviction"`
+	LocalCache    bool                    `yaml:"local_cache"`
+	// MaxConcurrentDownloads and MaxConcurrentUploads limit the number of layers
+	// transferred concurrently by each pull or push, zero means unlimited.
+	MaxConcurrentDownloads int             `yaml:"max_concurrent_downloads"`
+	MaxConcurrentUploads   int             `yaml:"max_concurrent_uploads"`
+	Bandwidth              BandwidthConfig `yaml:"bandwidth"`
+	// PlainHTTPFallbackTTL is the duration like `10m` to remember the registry
+	// host fallen back to plain HTTP, HTTPS is tried again after it expires.
+	PlainHTTPFallbackTTL string `yaml:"plain_http_fallback_ttl"`
+	// Archive converts the image from local archive instead of registry.
+	Archive ArchiveConfig `yaml:"archive"`
+	// Containerd converts the image in containerd instead of registry.
+	Containerd ContainerdConfig `yaml:"containerd"`
+}
+
+// ContainerdConfig replaces the registry with the image store of a running
+// containerd, it's enabled if Address is configured.
+type ContainerdConfig struct {
+	// Address is the containerd socket like `/run/containerd/containerd.sock`.
+	Address string `yaml:"address"`
+	// Namespace of the source and converted images, defaults to `default`.
+	Namespace string `yaml:"namespace"`
+	// Push pushes the converted image to registry after importing to containerd.
+	Push bool `yaml:"push"`
+}
+
+// ArchiveConfig replaces the registry with local OCI image layout directory
+// or tar archive, it's enabled if Source is configured.
+type ArchiveConfig struct {
+	// Source is the OCI image layout directory, or the tar archive of
+	// OCI image layout or `docker save`.
+	Source string `yaml:"source"`
+	// Target is the tar archive if it ends with `.tar`, otherwise the
+	// OCI image layout directory, the converted image is written to.
+	Target string `yaml:"target"`
+}
+
+// BandwidthConfig limits the bandwidth per second of registry transfers,
+// the limit is a human-readable size like `50MB`, empty means unlimited.
+type BandwidthConfig struct {
+	// PerHost is the default limit of each registry host.
+	PerHost string `yaml:"per_host"`
+	// Hosts overrides the limit of specified registry hosts.
+	Hosts map[string]string `yaml:"hosts"`
+	// Global is the limit shared by all registry hosts and workers.
+	Global string `yaml:"global"`
 }
 
 type GCPolicy struct {
@@ -63,14 +114,90 @@ type Webhook struct {
 }
 
 type SourceConfig struct {
-	Auth     string  `yaml:"auth"`
-	Insecure bool    `yaml:"insecure"`
-	Webhook  Webhook `yaml:"webhook"`
+	Auth     string `yaml:"auth"`
+	Insecure bool   `yaml:"insecure"`
+	// PlainHTTP always uses plain HTTP for the host instead of trying HTTPS first.
+	PlainHTTP bool      `yaml:"plain_http"`
+	Webhook   Webhook   `yaml:"webhook"`
+	TLS       TLSConfig `yaml:",inline"`
+	// Credential selects the credential provider instead of `auth`.
+	Credential CredentialConfig `yaml:"credential"`
+	// Push is the credential used for pushing to the host, the same
+	// as the pull credential if not configured.
+	Push *AuthConfig `yaml:"push"`
+	// Repositories overrides the credentials for the repositories under
+	// the prefix like `library` or `library/nginx`, the longest prefix wins.
+	Repositories map[string]RepositoryConfig `yaml:"repositories"`
+	// Retry is the max number of retries of failed layer download,
+	// zero uses the default value, negative disables retry.
+	Retry int `yaml:"retry"`
+	// Mirrors are the pull-only endpoints tried in order before the source host.
+	Mirrors []MirrorConfig `yaml:"mirrors"`
+	// Harbor lists the repositories and tags of the host by Harbor v2 API
+	// for backfill, instead of the registry catalog API usually disabled
+	// by Harbor.
+	Harbor bool `yaml:"harbor"`
+}
+
+type MirrorConfig struct {
+	// Endpoint is the URL of mirror like `https://mirror.example.com`, the scheme
+	// defaults to `https`, and the API path defaults to `/v2`.
+	Endpoint string `yaml:"endpoint"`
+	// Auth is the base64 encoded `<username>:<password>` of mirror.
+	Auth     string    `yaml:"auth"`
+	Insecure bool      `yaml:"insecure"`
+	TLS      TLSConfig `yaml:",inline"`
+}
+
+// TLSConfig is the TLS configuration of registry, the files are reloaded
+// once modified without restart.
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA bundle trusted in addition to the system CAs.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the PEM encoded client certificate and key for mTLS.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the server name used to verify the server certs.
+	ServerName string `yaml:"server_name"`
+}
+
+func (tlsConfig TLSConfig) remote() *remote.TLSConfig {
+	if tlsConfig == (TLSConfig{}) {
+		return nil
+	}
+	return &remote.TLSConfig{
+		CAFile:     tlsConfig.CAFile,
+		CertFile:   tlsConfig.CertFile,
+		KeyFile:    tlsConfig.KeyFile,
+		ServerName: tlsConfig.ServerName,
+	}
 }
 
+// defaultRetry is the default max number of retries of failed layer download.
+const defaultRetry = 3
+
+const (
+	BlobCopyPush  = "push"
+	BlobCopyMount = "mount"
+)
+
 type ConversionRule struct {
+	// Source limits the rule to the images under the registry/namespace
+	// prefix like `docker.io/library`, empty matches all images.
+	Source string `yaml:"source"`
+	// Target replaces the Source prefix of target image reference like
+	// `harbor.internal/dockerhub`, the credential of target registry is
+	// looked up in `provider.source` as well.
+	Target    string `yaml:"target"`
 	TagSuffix string `yaml:"tag_suffix"`
 	CacheTag  string `yaml:"cache_tag"`
+	CacheRef  string `yaml:"cache_ref"`
+	// BlobCopy is the strategy to copy the blobs left untouched by driver to
+	// the target registry, `push` (default) uploads them, `mount` mounts them
+	// from the repository under MountFrom prefix of target registry, and falls
+	// back to upload if not found there.
+	BlobCopy  string `yaml:"blob_copy"`
+	MountFrom string `yaml:"mount_from"`
 }
 
 type ConverterConfig struct {
@@ -79,6 +206,29 @@ type ConverterConfig struct {
 	HarborAnnotation bool             `yaml:"harbor_annotation"`
 	Platforms        string           `yaml:"platforms"`
 	Rules            []ConversionRule `yaml:"rules"`
+	Verify           VerifyConfig     `yaml:"verify"`
+	ChunkDict        ChunkDictConfig  `yaml:"chunk_dict"`
+	Backfill         BackfillConfig   `yaml:"backfill"`
+}
+
+// BackfillConfig configures the backfill jobs converting the existing
+// images of registry.
+type BackfillConfig struct {
+	// Rate is the max number of conversions enqueued per second by all
+	// backfill jobs, defaults to 1.
+	Rate float64 `yaml:"rate"`
+}
+
+type ChunkDictConfig struct {
+	Ref        string `yaml:"ref"`
+	Repository string `yaml:"repository"`
+	Limit      int    `yaml:"limit"`
+	Interval   string `yaml:"interval"`
+}
+
+type VerifyConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	CompareFS bool `yaml:"compare_fs"`
 }
 
 type DriverConfig struct {
@@ -100,18 +250,26 @@ func Parse(configPath string) (*Config, error) {
 	return &config, nil
 }
 
-func (cfg *Config) Host(ref string) (remote.CredentialFunc, bool, error) {
+func parseHost(ref string) (string, error) {
+	refURL, err := url.Parse(fmt.Sprintf("dummy://%s", ref))
+	if err != nil {
+		return "", errors.Wrap(err, "parse reference of source image")
+	}
+	return refURL.Host, nil
+}
+
+func (cfg *Config) Host(ref string) (*remote.HostConfig, error) {
 	authorizer := func(ref string) (*SourceConfig, error) {
-		refURL, err := url.Parse(fmt.Sprintf("dummy://%s", ref))
+		host, err := parseHost(ref)
 		if err != nil {
-			return nil, errors.Wrap(err, "parse reference of source image")
+			return nil, err
 		}
 
-		auth := cfg.Provider.Source[refURL.Host]
+		auth := cfg.Provider.Source[host]
 		// try to finds auth for a given host in docker's config.json settings.
 		if len(auth.Auth) == 0 {
 			config := config.LoadDefaultConfigFile(os.Stderr)
-			authConfig, err := config.GetAuthConfig(refURL.Host)
+			authConfig, err := config.GetAuthConfig(host)
 			if err != nil {
 				return nil, err
 			}
@@ -124,34 +282,110 @@ func (cfg *Config) Host(ref string) (remote.CredentialFunc, bool, error) {
 
 	auth, err := authorizer(ref)
 	if err != nil {
-		return nil, false, err
+		return nil, err
 	}
 
-	return func(host string) (string, string, error) {
+	retry := auth.Retry
+	if retry == 0 {
+		retry = defaultRetry
+	} else if retry < 0 {
+		retry = 0
+	}
+
+	credFunc := func(host string) (string, string, error) {
 		auth, err := authorizer(host)
 		if err != nil {
 			return "", "", err
 		}
+		return decodeAuth(auth.Auth)
+	}
 
-		// Leave auth empty if no authorization be required
-		if strings.TrimSpace(auth.Auth) == "" {
-			return "", "", nil
-		}
-		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	host, err := parseHost(ref)
+	if err != nil {
+		return nil, err
+	}
+	credential, pushCredential, err := cfg.credentialProviders(host, ref, cfg.Provider.Source[host])
+	if err != nil {
+		return nil, err
+	}
+	if credential == nil {
+		// Falls back to the docker config of the default location.
+		credential = remote.NewFuncCredentialProvider(credFunc)
+	}
+	if pushCredential == nil {
+		pushCredential = credential
+	}
+
+	mirrors := []remote.Mirror{}
+	for _, mirrorConfig := range auth.Mirrors {
+		mirror, err := parseMirror(mirrorConfig)
 		if err != nil {
-			return "", "", errors.Wrap(err, "decode base64 encoded auth string")
+			return nil, err
 		}
-		ary := strings.Split(string(decoded), ":")
-		if len(ary) != 2 {
-			return "", "", errors.New("invalid base64 encoded auth string")
-		}
-		return ary[0], ary[1], nil
-	}, auth.Insecure, nil
+		mirrors = append(mirrors, *mirror)
+	}
+
+	return &remote.HostConfig{
+		Credential:     credential,
+		PushCredential: pushCredential,
+		Insecure:       auth.Insecure,
+		PlainHTTP:      auth.PlainHTTP,
+		TLS:            auth.TLS.remote(),
+		Retry:          retry,
+		Mirrors:        mirrors,
+	}, nil
+}
+
+// decodeAuth decodes the base64 encoded `<username>:<password>` auth string.
+func decodeAuth(auth string) (string, string, error) {
+	// Leave auth empty if no authorization be required
+	if strings.TrimSpace(auth) == "" {
+		return "", "", nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return "", "", errors.Wrap(err, "decode base64 encoded auth string")
+	}
+	ary := strings.Split(string(decoded), ":")
+	if len(ary) != 2 {
+		return "", "", errors.New("invalid base64 encoded auth string")
+	}
+	return ary[0], ary[1], nil
+}
+
+func parseMirror(mirrorConfig MirrorConfig) (*remote.Mirror, error) {
+	endpoint := mirrorConfig.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse mirror endpoint %s", mirrorConfig.Endpoint)
+	}
+	if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme of mirror endpoint %s", mirrorConfig.Endpoint)
+	}
+	apiPath := strings.TrimSuffix(endpointURL.Path, "/")
+	if !strings.HasSuffix(apiPath, "/v2") {
+		apiPath += "/v2"
+	}
+
+	auth := mirrorConfig.Auth
+	return &remote.Mirror{
+		Scheme: endpointURL.Scheme,
+		Host:   endpointURL.Host,
+		Path:   apiPath,
+		Credential: remote.NewFuncCredentialProvider(func(string) (string, string, error) {
+			return decodeAuth(auth)
+		}),
+		Insecure: mirrorConfig.Insecure,
+		TLS:      mirrorConfig.TLS.remote(),
+	}, nil
 }
 
 func (cfg *Config) EnableRemoteCache() bool {
 	for _, rule := range cfg.Converter.Rules {
-		if rule.CacheTag != "" {
+		if rule.CacheTag != "" || rule.CacheRef != "" {
 			return true
 		}
 	}
@@ -0,0 +1,234 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/pkg/errors"
+)
+
+const (
+	CredentialTypeDocker = "docker"
+	CredentialTypeToken  = "token"
+	CredentialTypeFile   = "file"
+	CredentialTypeExec   = "exec"
+)
+
+// CredentialConfig selects the credential provider of source host, the `auth`
+// of source and the default docker config are used if the type is empty.
+type CredentialConfig struct {
+	// Type is one of `docker`, `token`, `file` and `exec`.
+	Type string `yaml:"type"`
+
+	// ConfigDir is the docker config directory for `docker` type,
+	// defaults to `$DOCKER_CONFIG` or `~/.docker`.
+	ConfigDir string `yaml:"config_dir"`
+	// Helper forces to use the credential helper `docker-credential-<helper>`
+	// for `docker` type, instead of the `credsStore` and `credHelpers` in config.
+	Helper string `yaml:"helper"`
+
+	// Token is the static token for `token` type.
+	Token string `yaml:"token"`
+	// TokenType is `bearer` (default) for the registry token sent directly,
+	// or `identity` for the identity token exchanged by OAuth.
+	TokenType string `yaml:"token_type"`
+
+	// Path is the file in docker config format for `file` type,
+	// like the mounted `.dockerconfigjson` of Kubernetes secret.
+	Path string `yaml:"path"`
+
+	// Command, Args and Env are the plugin executed for `exec` type.
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Env     []string `yaml:"env"`
+	// Timeout of each plugin execution like `10s`.
+	Timeout string `yaml:"timeout"`
+	// CacheDuration is the default duration to cache the credential returned
+	// by plugin like `10m`, the plugin response may override it.
+	CacheDuration string `yaml:"cache_duration"`
+}
+
+func parseDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(duration)
+}
+
+// newProvider creates the credential provider, it returns nil if the type is empty.
+func (cfg CredentialConfig) newProvider() (remote.CredentialProvider, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case CredentialTypeDocker:
+		return remote.NewDockerCredentialProvider(cfg.ConfigDir, cfg.Helper), nil
+	case CredentialTypeToken:
+		switch cfg.TokenType {
+		case "", "bearer":
+			return remote.NewTokenCredentialProvider(cfg.Token, false), nil
+		case "identity":
+			return remote.NewTokenCredentialProvider(cfg.Token, true), nil
+		default:
+			return nil, fmt.Errorf("unsupported token type %s", cfg.TokenType)
+		}
+	case CredentialTypeFile:
+		if cfg.Path == "" {
+			return nil, errors.New("credential file path is required")
+		}
+		return remote.NewFileCredentialProvider(cfg.Path), nil
+	case CredentialTypeExec:
+		if cfg.Command == "" {
+			return nil, errors.New("credential plugin command is required")
+		}
+		timeout, err := parseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse credential plugin timeout")
+		}
+		cacheDuration, err := parseDuration(cfg.CacheDuration)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse credential cache duration")
+		}
+		return remote.NewExecCredentialProvider(remote.ExecCredentialOptions{
+			Command:       cfg.Command,
+			Args:          cfg.Args,
+			Env:           cfg.Env,
+			Timeout:       timeout,
+			CacheDuration: cacheDuration,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported credential type %s", cfg.Type)
+	}
+}
+
+// AuthConfig is the credential of registry, the `credential` provider
+// takes precedence over the static `auth`.
+type AuthConfig struct {
+	// Auth is the base64 encoded `<username>:<password>`.
+	Auth       string           `yaml:"auth"`
+	Credential CredentialConfig `yaml:"credential"`
+}
+
+// RepositoryConfig overrides the credentials of source host for the repositories
+// under the prefix, the push credential defaults to its pull credential.
+type RepositoryConfig struct {
+	AuthConfig `yaml:",inline"`
+	// Push is the credential used for pushing to the repositories.
+	Push *AuthConfig `yaml:"push"`
+}
+
+// authProvider returns the credential provider of auth config, it returns nil
+// if neither `credential` nor `auth` is configured. The provider is created once
+// for the scope and reused, so that the credential cache of provider is kept
+// across conversions.
+func (cfg *Config) authProvider(scope string, auth AuthConfig) (remote.CredentialProvider, error) {
+	if auth.Credential.Type == "" {
+		if auth.Auth == "" {
+			return nil, nil
+		}
+		return remote.NewFuncCredentialProvider(func(string) (string, string, error) {
+			return decodeAuth(auth.Auth)
+		}), nil
+	}
+
+	if provider, ok := cfg.credentials.Load(scope); ok {
+		return provider.(remote.CredentialProvider), nil
+	}
+	provider, err := auth.Credential.newProvider()
+	if err != nil {
+		return nil, errors.Wrapf(err, "create credential provider of %s", scope)
+	}
+	actual, _ := cfg.credentials.LoadOrStore(scope, provider)
+	return actual.(remote.CredentialProvider), nil
+}
+
+// matchRepository returns the repository config of the longest prefix matched
+// with the repository by path components, nil if not matched.
+func matchRepository(repositories map[string]RepositoryConfig, repository string) (string, *RepositoryConfig) {
+	var matched string
+	var matchedConfig *RepositoryConfig
+	for prefix, repoConfig := range repositories {
+		prefix = strings.Trim(prefix, "/")
+		if repository != prefix && !strings.HasPrefix(repository, prefix+"/") {
+			continue
+		}
+		if matchedConfig == nil || len(prefix) > len(matched) {
+			repoConfig := repoConfig
+			matched, matchedConfig = prefix, &repoConfig
+		}
+	}
+	return matched, matchedConfig
+}
+
+// parseRepository returns the repository of image reference, or empty
+// string if the reference doesn't contain a repository.
+func parseRepository(ref string) string {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	parts := strings.SplitN(refspec.Locator, "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// credentialProviders returns the pull and push credential providers of the image
+// reference, nil if not configured.
+func (cfg *Config) credentialProviders(host, ref string, source SourceConfig) (remote.CredentialProvider, remote.CredentialProvider, error) {
+	pull, err := cfg.authProvider(host, AuthConfig{Auth: source.Auth, Credential: source.Credential})
+	if err != nil {
+		return nil, nil, err
+	}
+	push := pull
+	if source.Push != nil {
+		provider, err := cfg.authProvider(host+"#push", *source.Push)
+		if err != nil {
+			return nil, nil, err
+		}
+		if provider != nil {
+			push = provider
+		}
+	}
+
+	prefix, repoConfig := matchRepository(source.Repositories, parseRepository(ref))
+	if repoConfig == nil {
+		return pull, push, nil
+	}
+	scope := host + "/" + prefix
+	provider, err := cfg.authProvider(scope, repoConfig.AuthConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if provider != nil {
+		pull, push = provider, provider
+	}
+	if repoConfig.Push != nil {
+		provider, err := cfg.authProvider(scope+"#push", *repoConfig.Push)
+		if err != nil {
+			return nil, nil, err
+		}
+		if provider != nil {
+			push = provider
+		}
+	}
+
+	return pull, push, nil
+}
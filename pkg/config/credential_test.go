@@ -0,0 +1,69 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/remote"
+)
+
+func basicAuth(username string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":secret"))
+}
+
+func TestHostCredentials(t *testing.T) {
+	cfg := &Config{}
+	cfg.Provider.Source = map[string]SourceConfig{
+		"hub.harbor.com": {
+			Auth: basicAuth("reader"),
+			Push: &AuthConfig{Auth: basicAuth("writer")},
+			Repositories: map[string]RepositoryConfig{
+				"library": {
+					AuthConfig: AuthConfig{Auth: basicAuth("library-reader")},
+				},
+				"library/nginx": {
+					Push: &AuthConfig{Auth: basicAuth("nginx-writer")},
+				},
+				"partner/": {
+					AuthConfig: AuthConfig{Credential: CredentialConfig{Type: CredentialTypeToken, Token: "token"}},
+				},
+			},
+		},
+	}
+
+	requireCredential := func(ref string, op remote.Operation, expected *remote.Credential) {
+		hostConfig, err := cfg.Host(ref)
+		require.NoError(t, err)
+		cred, err := hostConfig.For(op).Credential.Credential("hub.harbor.com")
+		require.NoError(t, err)
+		require.Equal(t, expected, cred, ref)
+	}
+
+	requireCredential("hub.harbor.com/app/nginx:latest", remote.OperationPull, &remote.Credential{Username: "reader", Password: "secret"})
+	requireCredential("hub.harbor.com/app/nginx:latest-nydus", remote.OperationPush, &remote.Credential{Username: "writer", Password: "secret"})
+	// The repository config overrides the credentials of host.
+	requireCredential("hub.harbor.com/library/redis:latest", remote.OperationPull, &remote.Credential{Username: "library-reader", Password: "secret"})
+	requireCredential("hub.harbor.com/library/redis:latest-nydus", remote.OperationPush, &remote.Credential{Username: "library-reader", Password: "secret"})
+	// The longest prefix wins, and the pull credential of host is used if not configured.
+	requireCredential("hub.harbor.com/library/nginx:latest", remote.OperationPull, &remote.Credential{Username: "reader", Password: "secret"})
+	requireCredential("hub.harbor.com/library/nginx:latest-nydus", remote.OperationPush, &remote.Credential{Username: "nginx-writer", Password: "secret"})
+	// The prefix matches by path components.
+	requireCredential("hub.harbor.com/library-x/nginx:latest", remote.OperationPull, &remote.Credential{Username: "reader", Password: "secret"})
+	requireCredential("hub.harbor.com/partner/app:latest", remote.OperationPull, &remote.Credential{RegistryToken: "token"})
+}
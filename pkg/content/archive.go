@@ -0,0 +1,378 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/images/archive"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ArchiveProvider reads the source image from a local OCI image layout directory
+// or a tar archive of OCI layout or `docker save`, and writes the target image
+// out as an OCI image layout directory or tar archive, no registry is required.
+type ArchiveProvider struct {
+	mutex      sync.Mutex
+	images     map[string]*ocispec.Descriptor
+	content    *Content
+	source     string
+	target     string
+	platformMC platforms.MatchComparer
+	localCache bool
+
+	// layoutMutex serializes the updates of target layout directory.
+	layoutMutex sync.Mutex
+}
+
+func NewArchiveProvider(cfg *config.Config, platformMC platforms.MatchComparer) (Provider, *Content, error) {
+	if cfg.Provider.Archive.Target == "" {
+		return nil, nil, errors.New("target of archive is required")
+	}
+	contentDir := filepath.Join(cfg.Provider.WorkDir, "content")
+	if err := os.MkdirAll(contentDir, 0755); err != nil {
+		return nil, nil, errors.Wrap(err, "create archive provider work directory")
+	}
+	content, err := NewContent(nil, contentDir, cfg.Provider.WorkDir, cfg.Provider.GCPolicy.Threshold)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create archive provider content")
+	}
+	return &ArchiveProvider{
+		images:     make(map[string]*ocispec.Descriptor),
+		content:    content,
+		source:     cfg.Provider.Archive.Source,
+		target:     cfg.Provider.Archive.Target,
+		platformMC: platformMC,
+		localCache: cfg.Provider.LocalCache,
+	}, content, nil
+}
+
+func (pvd *ArchiveProvider) UsePlainHTTP(_ string) error {
+	return nil
+}
+
+func (pvd *ArchiveProvider) Resolver(ref string, _ remote.Operation) (remotes.Resolver, error) {
+	return nil, fmt.Errorf("resolve %s: registry isn't supported by archive provider", ref)
+}
+
+// Pull imports the source archive into content store, the image is
+// matched by the name annotation of archive, or the only image in
+// archive is used regardless of the name.
+func (pvd *ArchiveProvider) Pull(ctx context.Context, ref string) error {
+	reader, err := openArchive(pvd.source)
+	if err != nil {
+		return errors.Wrapf(err, "open archive %s", pvd.source)
+	}
+	defer reader.Close()
+
+	desc, err := archive.ImportIndex(ctx, pvd.content, reader)
+	if err != nil {
+		return errors.Wrapf(err, "import archive %s", pvd.source)
+	}
+	var index ocispec.Index
+	if _, err := utils.ReadJSON(ctx, pvd.content, &index, desc); err != nil {
+		return errors.Wrap(err, "read index of archive")
+	}
+
+	image, err := matchImage(index.Manifests, ref)
+	if err != nil {
+		return errors.Wrapf(err, "find image in archive %s", pvd.source)
+	}
+	pvd.setImage(ref, image)
+
+	return nil
+}
+
+// Resolve imports the archive as Pull does, the archive is local.
+func (pvd *ArchiveProvider) Resolve(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	if err := pvd.Pull(ctx, ref); err != nil {
+		return nil, err
+	}
+	return pvd.Image(ctx, ref)
+}
+
+// Push exports the image to the target, it's a tar archive if the path ends
+// with `.tar`, otherwise an OCI image layout directory.
+func (pvd *ArchiveProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	opts := []archive.ExportOpt{
+		archive.WithManifest(desc, ref),
+		archive.WithPlatform(pvd.platformMC),
+	}
+
+	if strings.HasSuffix(pvd.target, ".tar") {
+		// Write to a temporary file first, so that the target is never incomplete.
+		tmp := pvd.target + ".tmp"
+		file, err := os.Create(tmp)
+		if err != nil {
+			return errors.Wrapf(err, "create archive %s", tmp)
+		}
+		defer os.Remove(tmp)
+		if err := archive.Export(ctx, pvd.content, file, opts...); err != nil {
+			file.Close()
+			return errors.Wrapf(err, "export image %s", ref)
+		}
+		if err := file.Close(); err != nil {
+			return errors.Wrapf(err, "close archive %s", tmp)
+		}
+		return os.Rename(tmp, pvd.target)
+	}
+
+	// The images pushed to the same layout directory are merged into its index.
+	pvd.layoutMutex.Lock()
+	defer pvd.layoutMutex.Unlock()
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.Export(ctx, pvd.content, writer, opts...))
+	}()
+	defer reader.Close()
+	indexBytes, err := untarDirectory(reader, pvd.target)
+	if err != nil {
+		return errors.Wrapf(err, "export image %s to %s", ref, pvd.target)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return errors.Wrapf(err, "unmarshal index of image %s", ref)
+	}
+	if err := mergeIndex(filepath.Join(pvd.target, ocispec.ImageIndexFile), index); err != nil {
+		return errors.Wrapf(err, "update index of %s", pvd.target)
+	}
+
+	return nil
+}
+
+// imageName returns the name identifying the image in the index of OCI
+// layout, the containerd image name is preferred since the OCI reference
+// name may be a tag only.
+func imageName(desc ocispec.Descriptor) string {
+	if name := desc.Annotations[images.AnnotationImageName]; name != "" {
+		return name
+	}
+	return desc.Annotations[ocispec.AnnotationRefName]
+}
+
+// mergeIndex writes the index to path, the manifests of other images in the
+// existing index are kept, and the ones with the same name are replaced.
+func mergeIndex(path string, index ocispec.Index) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var existing ocispec.Index
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return errors.Wrap(err, "unmarshal existing index")
+		}
+		names := make(map[string]bool)
+		for _, desc := range index.Manifests {
+			names[imageName(desc)] = true
+		}
+		for _, desc := range existing.Manifests {
+			if name := imageName(desc); name == "" || !names[name] {
+				index.Manifests = append(index.Manifests, desc)
+			}
+		}
+	}
+
+	data, err = json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that the index is never incomplete.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (pvd *ArchiveProvider) Image(_ context.Context, ref string) (*ocispec.Descriptor, error) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if desc, ok := pvd.images[ref]; ok {
+		return desc, nil
+	}
+	return nil, errdefs.ErrNotFound
+}
+
+func (pvd *ArchiveProvider) ContentStore() content.Store {
+	return pvd.content
+}
+
+func (pvd *ArchiveProvider) NewRemoteCache(ctx context.Context, _ string) (context.Context, *cache.RemoteCache) {
+	// The remote cache requires registry.
+	return ctx, nil
+}
+
+func (pvd *ArchiveProvider) LocalCache() cache.LocalCache {
+	if pvd.localCache {
+		return pvd.content.LocalCache()
+	}
+	return nil
+}
+
+func (pvd *ArchiveProvider) setImage(ref string, image *ocispec.Descriptor) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.images[ref] = image
+}
+
+// matchImage finds the image of reference in the manifests of archive index
+// by the containerd image name annotation or OCI reference name annotation.
+func matchImage(manifests []ocispec.Descriptor, ref string) (*ocispec.Descriptor, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse reference")
+	}
+	var tag string
+	if tagged, ok := named.(docker.Tagged); ok {
+		tag = tagged.Tag()
+	}
+
+	for idx := range manifests {
+		desc := manifests[idx]
+		if name := desc.Annotations[images.AnnotationImageName]; name != "" {
+			if name == named.String() {
+				return &desc, nil
+			}
+			continue
+		}
+		// The OCI reference name may be a tag only.
+		if name := desc.Annotations[ocispec.AnnotationRefName]; name != "" && (name == named.String() || name == tag) {
+			return &desc, nil
+		}
+	}
+	if len(manifests) == 1 {
+		return &manifests[0], nil
+	}
+
+	return nil, errors.Wrapf(errdefs.ErrNotFound, "image %s", named.String())
+}
+
+// openArchive opens the tar archive, or the OCI image layout directory as
+// a tar stream.
+func openArchive(path string) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.Open(path)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarDirectory(path, writer))
+	}()
+	return reader, nil
+}
+
+func tarDirectory(dir string, writer io.Writer) error {
+	tw := tar.NewWriter(writer)
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// untarDirectory extracts the tar stream of OCI layout into dir, the index
+// isn't extracted but returned, so that it can be merged with the existing one.
+func untarDirectory(reader io.Reader, dir string) ([]byte, error) {
+	var index []byte
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Clean the name against root to keep the file in dir.
+		name := filepath.Clean("/" + hdr.Name)
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			if name == "/"+ocispec.ImageIndexFile {
+				if index, err = io.ReadAll(tr); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, err
+			}
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+)
+
+func newTestArchiveProvider(t *testing.T, source, target string) Provider {
+	cfg := &config.Config{}
+	cfg.Provider.WorkDir = t.TempDir()
+	cfg.Provider.GCPolicy.Threshold = "1000MB"
+	cfg.Provider.Archive = config.ArchiveConfig{Source: source, Target: target}
+	pvd, _, err := NewArchiveProvider(cfg, platforms.All)
+	require.NoError(t, err)
+	return pvd
+}
+
+func TestArchiveProvider(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+	dir := t.TempDir()
+	layoutDir := filepath.Join(dir, "layout")
+	tarPath := filepath.Join(dir, "image.tar")
+
+	// Writes an image to the OCI layout directory.
+	pvd := newTestArchiveProvider(t, "", layoutDir)
+	layer := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	configBytes, err := json.Marshal(ocispec.Image{Platform: platforms.DefaultSpec()})
+	require.NoError(t, err)
+	imageConfig := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageConfig, configBytes)
+	manifestBytes, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    imageConfig,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	manifest := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageManifest, manifestBytes)
+	require.NoError(t, pvd.Push(ctx, manifest, "docker.io/library/app:latest"))
+
+	// Converts from the layout directory to the tar archive.
+	pvd = newTestArchiveProvider(t, layoutDir, tarPath)
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/app:latest"))
+	image, err := pvd.Image(ctx, "docker.io/library/app:latest")
+	require.NoError(t, err)
+	require.Equal(t, manifest.Digest, image.Digest)
+	require.NoError(t, pvd.Push(ctx, *image, "docker.io/library/app:latest-nydus"))
+
+	// The image is found by name in the tar archive.
+	pvd = newTestArchiveProvider(t, tarPath, layoutDir)
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/app:latest-nydus"))
+	image, err = pvd.Image(ctx, "docker.io/library/app:latest-nydus")
+	require.NoError(t, err)
+	require.Equal(t, manifest.Digest, image.Digest)
+	data, err := ctrcontent.ReadBlob(ctx, pvd.ContentStore(), layer)
+	require.NoError(t, err)
+	require.Equal(t, []byte("layer"), data)
+
+	// The only image is used regardless of the name.
+	require.NoError(t, pvd.Pull(ctx, "docker.io/library/other:latest"))
+
+	_, err = matchImage([]ocispec.Descriptor{manifest, manifest}, "docker.io/library/other:latest")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
+
+func TestArchiveLayoutMerge(t *testing.T) {
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+	layoutDir := filepath.Join(t.TempDir(), "layout")
+
+	pvd := newTestArchiveProvider(t, "", layoutDir)
+	amd64 := writeTestManifest(t, ctx, pvd.ContentStore(), ocispec.Platform{OS: "linux", Architecture: "amd64"})
+	arm64 := writeTestManifest(t, ctx, pvd.ContentStore(), ocispec.Platform{OS: "linux", Architecture: "arm64"})
+	// The images of the same tag in different repositories are kept.
+	require.NoError(t, pvd.Push(ctx, amd64, "docker.io/library/app:latest"))
+	require.NoError(t, pvd.Push(ctx, amd64, "docker.io/library/other:latest"))
+	require.NoError(t, pvd.Push(ctx, amd64, "docker.io/library/app:v1"))
+	// The image of the same name is replaced.
+	require.NoError(t, pvd.Push(ctx, arm64, "docker.io/library/app:latest"))
+
+	data, err := os.ReadFile(filepath.Join(layoutDir, ocispec.ImageIndexFile))
+	require.NoError(t, err)
+	var index ocispec.Index
+	require.NoError(t, json.Unmarshal(data, &index))
+	require.Len(t, index.Manifests, 3)
+
+	pvd = newTestArchiveProvider(t, layoutDir, filepath.Join(t.TempDir(), "target"))
+	for ref, manifest := range map[string]ocispec.Descriptor{
+		"docker.io/library/app:latest":   arm64,
+		"docker.io/library/other:latest": amd64,
+		"docker.io/library/app:v1":       amd64,
+	} {
+		image, err := pvd.Resolve(ctx, ref)
+		require.NoError(t, err)
+		require.Equal(t, manifest.Digest, image.Digest, ref)
+	}
+}
@@ -29,6 +29,10 @@ var (
 	bucketKeyVersion   = []byte("v1")
 	bucketKeyNamespace = []byte(accelerationServiceNamespace)
 	bucketKeySize      = []byte("size")
+
+	// bucketKeyLayerCache is the top level bucket of local layer cache,
+	// it's separated from the buckets managed by containerd.
+	bucketKeyLayerCache = []byte("layercache")
 )
 
 const (
@@ -0,0 +1,328 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultContainerdNamespace = "default"
+	// containerdLeaseExpiration is the expiration of the lease protecting the blobs
+	// written by conversion from containerd GC, a new lease is used once half of
+	// the expiration has passed, so that the blobs are kept for at least 12 hours.
+	containerdLeaseExpiration = 24 * time.Hour
+)
+
+// containerdStore wraps the content store of containerd, the namespace of provider
+// and the lease are set for each call, regardless of the namespace in context.
+type containerdStore struct {
+	content.Store
+	namespace string
+	lease     func(ctx context.Context) (string, error)
+}
+
+func (store *containerdStore) withNamespace(ctx context.Context) context.Context {
+	return namespaces.WithNamespace(ctx, store.namespace)
+}
+
+func (store *containerdStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	return store.Store.Info(store.withNamespace(ctx), dgst)
+}
+
+func (store *containerdStore) Update(ctx context.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return store.Store.Update(store.withNamespace(ctx), info, fieldpaths...)
+}
+
+func (store *containerdStore) Walk(ctx context.Context, fn content.WalkFunc, filters ...string) error {
+	return store.Store.Walk(store.withNamespace(ctx), fn, filters...)
+}
+
+func (store *containerdStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return store.Store.Delete(store.withNamespace(ctx), dgst)
+}
+
+func (store *containerdStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	return store.Store.ReaderAt(store.withNamespace(ctx), desc)
+}
+
+func (store *containerdStore) Status(ctx context.Context, ref string) (content.Status, error) {
+	return store.Store.Status(store.withNamespace(ctx), ref)
+}
+
+func (store *containerdStore) ListStatuses(ctx context.Context, filters ...string) ([]content.Status, error) {
+	return store.Store.ListStatuses(store.withNamespace(ctx), filters...)
+}
+
+func (store *containerdStore) Abort(ctx context.Context, ref string) error {
+	return store.Store.Abort(store.withNamespace(ctx), ref)
+}
+
+func (store *containerdStore) Writer(ctx context.Context, opts ...content.WriterOpt) (content.Writer, error) {
+	ctx = store.withNamespace(ctx)
+	leaseID, err := store.lease(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "create lease")
+	}
+	return store.Store.Writer(leases.WithLease(ctx, leaseID), opts...)
+}
+
+// ContainerdProvider converts the images in the image store of a running containerd,
+// the converted image is imported back to containerd, and pushed to registry if
+// enabled.
+type ContainerdProvider struct {
+	mutex        sync.Mutex
+	images       map[string]*ocispec.Descriptor
+	client       *containerd.Client
+	imageStore   images.Store
+	leaseManager leases.Manager
+	namespace    string
+	store        *containerdStore
+	hosts        remote.HostFunc
+	plainHTTP    *remote.PlainHTTPFallback
+	platformMC   platforms.MatchComparer
+	push         bool
+	maxUploads   int
+	bandwidth    *remote.BandwidthLimiter
+
+	leaseMutex   sync.Mutex
+	leaseID      string
+	leaseCreated time.Time
+}
+
+func NewContainerdProvider(cfg *config.Config, platformMC platforms.MatchComparer) (Provider, *Content, error) {
+	namespace := cfg.Provider.Containerd.Namespace
+	if namespace == "" {
+		namespace = defaultContainerdNamespace
+	}
+	bandwidth, err := newBandwidthLimiter(cfg.Provider.Bandwidth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse bandwidth limit")
+	}
+	client, err := containerd.New(cfg.Provider.Containerd.Address, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "connect to containerd %s", cfg.Provider.Containerd.Address)
+	}
+	var fallbackTTL time.Duration
+	if cfg.Provider.PlainHTTPFallbackTTL != "" {
+		if fallbackTTL, err = time.ParseDuration(cfg.Provider.PlainHTTPFallbackTTL); err != nil {
+			return nil, nil, errors.Wrap(err, "parse plain HTTP fallback ttl")
+		}
+	}
+	pvd := &ContainerdProvider{
+		images:       make(map[string]*ocispec.Descriptor),
+		client:       client,
+		imageStore:   client.ImageService(),
+		leaseManager: client.LeasesService(),
+		namespace:    namespace,
+		hosts:        cfg.Host,
+		plainHTTP:    remote.NewPlainHTTPFallback(fallbackTTL),
+		platformMC:   platformMC,
+		push:         cfg.Provider.Containerd.Push,
+		maxUploads:   cfg.Provider.MaxConcurrentUploads,
+		bandwidth:    bandwidth,
+	}
+	pvd.store = &containerdStore{
+		Store:     client.ContentStore(),
+		namespace: namespace,
+		lease:     pvd.lease,
+	}
+	// The content is managed by containerd GC.
+	return pvd, nil, nil
+}
+
+// lease returns the lease for the blobs written by conversion.
+func (pvd *ContainerdProvider) lease(ctx context.Context) (string, error) {
+	pvd.leaseMutex.Lock()
+	defer pvd.leaseMutex.Unlock()
+
+	if pvd.leaseID != "" && time.Since(pvd.leaseCreated) < containerdLeaseExpiration/2 {
+		return pvd.leaseID, nil
+	}
+	lease, err := pvd.leaseManager.Create(ctx, leases.WithRandomID(), leases.WithExpiration(containerdLeaseExpiration))
+	if err != nil {
+		return "", err
+	}
+	pvd.leaseID = lease.ID
+	pvd.leaseCreated = time.Now()
+	return pvd.leaseID, nil
+}
+
+func (pvd *ContainerdProvider) UsePlainHTTP(ref string) error {
+	return pvd.plainHTTP.Fallback(ref)
+}
+
+func (pvd *ContainerdProvider) Resolver(ref string, op remote.Operation) (remotes.Resolver, error) {
+	hostConfig, err := pvd.hosts(ref)
+	if err != nil {
+		return nil, err
+	}
+	return remote.NewHostResolver(hostConfig.For(op), pvd.plainHTTP, nil), nil
+}
+
+// Pull gets the source image from the image store of containerd, the image
+// isn't pulled from registry, and its content of the converted platforms must
+// exist in containerd.
+func (pvd *ContainerdProvider) Pull(ctx context.Context, ref string) error {
+	ctx = namespaces.WithNamespace(ctx, pvd.namespace)
+	image, err := pvd.imageStore.Get(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "get image %s from containerd namespace %s", ref, pvd.namespace)
+	}
+	if err := pvd.checkContent(ctx, image.Target); err != nil {
+		return errors.Wrapf(err, "check content of image %s", ref)
+	}
+	pvd.setImage(ref, &image.Target)
+	return nil
+}
+
+// checkContent checks the blobs of the platforms to be converted exist in
+// containerd, the image may be pulled for a part of the platforms only, e.g.
+// by `ctr image pull` without `--all-platforms`.
+func (pvd *ContainerdProvider) checkContent(ctx context.Context, target ocispec.Descriptor) error {
+	missing := []string{}
+	checkHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, err := pvd.store.Info(ctx, desc.Digest); err != nil {
+			if !errdefs.IsNotFound(err) {
+				return nil, errors.Wrapf(err, "get info of blob %s", desc.Digest)
+			}
+			if desc.Platform != nil {
+				missing = append(missing, fmt.Sprintf("%s (%s)", desc.Digest, platforms.Format(*desc.Platform)))
+			} else {
+				missing = append(missing, desc.Digest.String())
+			}
+			return nil, images.ErrSkipDesc
+		}
+		return nil, nil
+	})
+	handler := images.Handlers(checkHandler, images.FilterPlatforms(images.ChildrenHandler(pvd.store), pvd.platformMC))
+	if err := images.Walk(ctx, handler, target); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return errors.Wrapf(errdefs.ErrNotFound, "blobs %s don't exist in containerd, pull the image for all converted platforms first", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Resolve gets the image from containerd as Pull does, nothing is pulled from registry.
+func (pvd *ContainerdProvider) Resolve(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	if err := pvd.Pull(ctx, ref); err != nil {
+		return nil, err
+	}
+	return pvd.Image(ctx, ref)
+}
+
+// Push imports the target image into containerd by the reference, and pushes
+// it to registry if enabled.
+func (pvd *ContainerdProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
+	// Label the children of target image, so that they're referenced by
+	// the image record and kept by containerd GC after the lease expires.
+	labelHandler := images.SetChildrenLabels(pvd.store, images.FilterPlatforms(images.ChildrenHandler(pvd.store), pvd.platformMC))
+	if err := images.Walk(ctx, labelHandler, desc); err != nil {
+		return errors.Wrap(err, "set gc labels of image")
+	}
+
+	nsCtx := namespaces.WithNamespace(ctx, pvd.namespace)
+	image := images.Image{
+		Name:   ref,
+		Target: desc,
+	}
+	if _, err := pvd.imageStore.Create(nsCtx, image); err != nil {
+		if !errdefs.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "create image %s in containerd", ref)
+		}
+		if _, err := pvd.imageStore.Update(nsCtx, image, "target"); err != nil {
+			return errors.Wrapf(err, "update image %s in containerd", ref)
+		}
+	}
+	logrus.Infof("imported image %s to containerd namespace %s", ref, pvd.namespace)
+
+	if !pvd.push {
+		return nil
+	}
+	hostConfig, err := pvd.hosts(ref)
+	if err != nil {
+		return err
+	}
+	rc := &containerd.RemoteContext{
+		Resolver:                    remote.NewHostResolver(hostConfig.For(remote.OperationPush), pvd.plainHTTP, remote.NewStatusTracker(ctx)),
+		PlatformMatcher:             pvd.platformMC,
+		MaxConcurrentUploadedLayers: pvd.maxUploads,
+	}
+
+	ctx = remote.WithBandwidthLimiter(ctx, pvd.bandwidth)
+	return push(ctx, pvd.store, rc, desc, ref)
+}
+
+func (pvd *ContainerdProvider) Image(_ context.Context, ref string) (*ocispec.Descriptor, error) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	if desc, ok := pvd.images[ref]; ok {
+		return desc, nil
+	}
+	return nil, errdefs.ErrNotFound
+}
+
+func (pvd *ContainerdProvider) ContentStore() content.Store {
+	return pvd.store
+}
+
+func (pvd *ContainerdProvider) NewRemoteCache(ctx context.Context, _ string) (context.Context, *cache.RemoteCache) {
+	// The blobs of remote cache can't be fetched on demand into containerd.
+	return ctx, nil
+}
+
+func (pvd *ContainerdProvider) LocalCache() cache.LocalCache {
+	return nil
+}
+
+// CheckHealth checks the containerd is serving.
+func (pvd *ContainerdProvider) CheckHealth(ctx context.Context) error {
+	serving, err := pvd.client.IsServing(ctx)
+	if err != nil {
+		return errors.Wrap(err, "check containerd serving")
+	}
+	if !serving {
+		return fmt.Errorf("containerd isn't serving")
+	}
+	return nil
+}
+
+func (pvd *ContainerdProvider) setImage(ref string, image *ocispec.Descriptor) {
+	pvd.mutex.Lock()
+	defer pvd.mutex.Unlock()
+	pvd.images[ref] = image
+}
@@ -0,0 +1,257 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeContentStore records the namespace and lease of the calls.
+type fakeContentStore struct {
+	ctrcontent.Store
+	mutex      sync.Mutex
+	namespaces []string
+	leases     []string
+}
+
+func (store *fakeContentStore) record(ctx context.Context) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	namespace, _ := namespaces.Namespace(ctx)
+	store.namespaces = append(store.namespaces, namespace)
+	if lease, ok := leases.FromContext(ctx); ok {
+		store.leases = append(store.leases, lease)
+	}
+}
+
+func (store *fakeContentStore) Info(ctx context.Context, dgst digest.Digest) (ctrcontent.Info, error) {
+	store.record(ctx)
+	return store.Store.Info(ctx, dgst)
+}
+
+func (store *fakeContentStore) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (ctrcontent.ReaderAt, error) {
+	store.record(ctx)
+	return store.Store.ReaderAt(ctx, desc)
+}
+
+func (store *fakeContentStore) Writer(ctx context.Context, opts ...ctrcontent.WriterOpt) (ctrcontent.Writer, error) {
+	store.record(ctx)
+	return store.Store.Writer(ctx, opts...)
+}
+
+type fakeImageStore struct {
+	images.Store
+	images map[string]images.Image
+}
+
+func (store *fakeImageStore) Get(ctx context.Context, name string) (images.Image, error) {
+	if _, err := namespaces.NamespaceRequired(ctx); err != nil {
+		return images.Image{}, err
+	}
+	image, ok := store.images[name]
+	if !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	return image, nil
+}
+
+func (store *fakeImageStore) Create(ctx context.Context, image images.Image) (images.Image, error) {
+	if _, err := namespaces.NamespaceRequired(ctx); err != nil {
+		return images.Image{}, err
+	}
+	if _, ok := store.images[image.Name]; ok {
+		return images.Image{}, errdefs.ErrAlreadyExists
+	}
+	store.images[image.Name] = image
+	return image, nil
+}
+
+func (store *fakeImageStore) Update(ctx context.Context, image images.Image, _ ...string) (images.Image, error) {
+	if _, err := namespaces.NamespaceRequired(ctx); err != nil {
+		return images.Image{}, err
+	}
+	if _, ok := store.images[image.Name]; !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	store.images[image.Name] = image
+	return image, nil
+}
+
+type fakeLeaseManager struct {
+	leases.Manager
+	created []leases.Lease
+}
+
+func (manager *fakeLeaseManager) Create(_ context.Context, opts ...leases.Opt) (leases.Lease, error) {
+	var lease leases.Lease
+	for _, opt := range opts {
+		if err := opt(&lease); err != nil {
+			return leases.Lease{}, err
+		}
+	}
+	manager.created = append(manager.created, lease)
+	return lease, nil
+}
+
+func newTestContainerdProvider(t *testing.T, platformMC platforms.MatchComparer) (*ContainerdProvider, *fakeContentStore, *fakeImageStore, *fakeLeaseManager) {
+	cs, err := local.NewLabeledStore(t.TempDir(), newMemoryLabelStore())
+	require.NoError(t, err)
+	contentStore := &fakeContentStore{Store: cs}
+	imageStore := &fakeImageStore{images: make(map[string]images.Image)}
+	leaseManager := &fakeLeaseManager{}
+	pvd := &ContainerdProvider{
+		images:       make(map[string]*ocispec.Descriptor),
+		imageStore:   imageStore,
+		leaseManager: leaseManager,
+		namespace:    "k8s.io",
+		platformMC:   platformMC,
+	}
+	pvd.store = &containerdStore{
+		Store:     contentStore,
+		namespace: pvd.namespace,
+		lease:     pvd.lease,
+	}
+	return pvd, contentStore, imageStore, leaseManager
+}
+
+// writeTestManifest writes a manifest of single layer for the platform.
+func writeTestManifest(t *testing.T, ctx context.Context, cs ctrcontent.Store, platform ocispec.Platform) ocispec.Descriptor {
+	layer := writeTestBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte(platforms.Format(platform)))
+	configBytes, err := json.Marshal(ocispec.Image{Platform: platform})
+	require.NoError(t, err)
+	imageConfig := writeTestBlob(t, ctx, cs, ocispec.MediaTypeImageConfig, configBytes)
+	manifestBytes, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    imageConfig,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	manifest := writeTestBlob(t, ctx, cs, ocispec.MediaTypeImageManifest, manifestBytes)
+	manifest.Platform = &platform
+	return manifest
+}
+
+func TestContainerdStore(t *testing.T) {
+	pvd, contentStore, _, leaseManager := newTestContainerdProvider(t, platforms.All)
+	ctx := namespaces.WithNamespace(context.Background(), "acceleration-service")
+
+	// The namespace of provider is used regardless of the namespace in context,
+	// and the blobs are written with the lease of provider.
+	desc := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	_, err := pvd.ContentStore().Info(ctx, desc.Digest)
+	require.NoError(t, err)
+	require.NotEmpty(t, contentStore.namespaces)
+	for _, namespace := range contentStore.namespaces {
+		require.Equal(t, "k8s.io", namespace)
+	}
+	require.Len(t, leaseManager.created, 1)
+	require.Equal(t, []string{leaseManager.created[0].ID}, contentStore.leases)
+
+	// The lease is reused until half of the expiration has passed.
+	leaseID, err := pvd.lease(ctx)
+	require.NoError(t, err)
+	require.Equal(t, leaseManager.created[0].ID, leaseID)
+	pvd.leaseCreated = time.Now().Add(-containerdLeaseExpiration / 2)
+	leaseID, err = pvd.lease(ctx)
+	require.NoError(t, err)
+	require.Len(t, leaseManager.created, 2)
+	require.Equal(t, leaseManager.created[1].ID, leaseID)
+	require.NotEqual(t, leaseManager.created[0].ID, leaseID)
+	leaseID, err = pvd.lease(ctx)
+	require.NoError(t, err)
+	require.Len(t, leaseManager.created, 2)
+	require.Equal(t, leaseManager.created[1].ID, leaseID)
+}
+
+func TestContainerdPush(t *testing.T) {
+	pvd, _, imageStore, _ := newTestContainerdProvider(t, platforms.All)
+	ctx := context.Background()
+	ref := "docker.io/library/nginx:latest-nydus"
+
+	manifest := writeTestManifest(t, ctx, pvd.ContentStore(), platforms.DefaultSpec())
+	require.NoError(t, pvd.Push(ctx, manifest, ref))
+	require.Equal(t, manifest, imageStore.images[ref].Target)
+	// The children are labeled to be kept by containerd GC.
+	info, err := pvd.ContentStore().Info(ctx, manifest.Digest)
+	require.NoError(t, err)
+	require.Contains(t, info.Labels, "containerd.io/gc.ref.content.config")
+	require.Contains(t, info.Labels, "containerd.io/gc.ref.content.l.0")
+
+	// The existing image is updated to the new target.
+	manifest = writeTestManifest(t, ctx, pvd.ContentStore(), ocispec.Platform{OS: "linux", Architecture: "arm64"})
+	require.NoError(t, pvd.Push(ctx, manifest, ref))
+	require.Equal(t, manifest, imageStore.images[ref].Target)
+}
+
+func TestContainerdPull(t *testing.T) {
+	amd64 := ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	ctx := context.Background()
+	ref := "docker.io/library/nginx:latest"
+
+	newProvider := func(platformMC platforms.MatchComparer) *ContainerdProvider {
+		pvd, _, imageStore, _ := newTestContainerdProvider(t, platformMC)
+		// Only the amd64 manifest of multi-platform image exists in containerd.
+		amd64Manifest := writeTestManifest(t, ctx, pvd.ContentStore(), amd64)
+		arm64Manifest := ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromString("arm64"),
+			Size:      6,
+			Platform:  &arm64,
+		}
+		indexBytes, err := json.Marshal(ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []ocispec.Descriptor{amd64Manifest, arm64Manifest},
+		})
+		require.NoError(t, err)
+		index := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageIndex, indexBytes)
+		imageStore.images[ref] = images.Image{Name: ref, Target: index}
+		return pvd
+	}
+
+	pvd := newProvider(platforms.All)
+	err := pvd.Pull(ctx, ref)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+	require.Contains(t, err.Error(), "linux/arm64")
+	_, err = pvd.Image(ctx, ref)
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+
+	pvd = newProvider(platforms.Only(amd64))
+	require.NoError(t, pvd.Pull(ctx, ref))
+	image, err := pvd.Image(ctx, ref)
+	require.NoError(t, err)
+	require.Equal(t, ocispec.MediaTypeImageIndex, image.MediaType)
+
+	err = pvd.Pull(ctx, "docker.io/library/redis:latest")
+	require.ErrorIs(t, err, errdefs.ErrNotFound)
+}
@@ -59,6 +59,8 @@ type Content struct {
 	store ctrcontent.Store
 	// hosts provides remote registry access methods.
 	hosts remote.HostFunc
+	// plainHTTP remembers the registry hosts fallen back to plain HTTP.
+	plainHTTP *remote.PlainHTTPFallback
 	// Threshold is the maximum capacity of the local caches storage
 	Threshold int64
 }
@@ -162,7 +164,7 @@ func (content *Content) garbageCollect(ctx context.Context, size int64) error {
 		return err
 	}
 	logrus.Infof("garbage collect, elapse %s", gcStatus.Elapsed())
-	return nil
+	return content.pruneLayerCache()
 }
 
 // cleanLeases use lease to manage content blob, delete lease of content which should be gc
@@ -251,11 +253,16 @@ func (content *Content) updateLease(digest *digest.Digest) error {
 }
 
 func (content *Content) Info(ctx context.Context, dgst digest.Digest) (ctrcontent.Info, error) {
-	if _, cached := cache.Get(ctx, dgst); cached != nil {
+	if rc, cached := cache.Get(ctx, dgst); cached != nil {
+		// The cached blob can be mounted from the cache repository on push.
+		labels, err := remote.AppendDistributionSource(cached.Annotations, rc.Ref)
+		if err != nil {
+			return ctrcontent.Info{}, err
+		}
 		return ctrcontent.Info{
 			Digest: cached.Digest,
 			Size:   cached.Size,
-			Labels: cached.Annotations,
+			Labels: labels,
 		}, nil
 	}
 
@@ -298,7 +305,7 @@ func (content *Content) ReaderAt(ctx context.Context, desc ocispec.Descriptor) (
 	ra, err := content.store.ReaderAt(ctx, desc)
 	if errors.Is(err, errdefs.ErrNotFound) {
 		if rc, cached := cache.Get(ctx, desc.Digest); cached != nil {
-			return remote.Fetch(ctx, rc.Ref, desc, content.hosts, true)
+			return remote.Fetch(ctx, rc.Ref, desc, content.hosts, content.plainHTTP)
 		}
 	}
 
@@ -0,0 +1,133 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+)
+
+// layerCache implements cache.LocalCache, the records are stored in
+// the bolt database of content, and the converted blobs are managed
+// by the lease based gc as other blobs.
+type layerCache struct {
+	content *Content
+}
+
+// LocalCache returns the local cache of converted layers.
+func (content *Content) LocalCache() cache.LocalCache {
+	return &layerCache{content: content}
+}
+
+func (lc *layerCache) Get(ctx context.Context, key cache.LayerKey) (*ocispec.Descriptor, error) {
+	var target *ocispec.Descriptor
+	if err := lc.content.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketKeyLayerCache)
+		if bucket == nil {
+			return nil
+		}
+		value := bucket.Get([]byte(key.String()))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &target)
+	}); err != nil {
+		return nil, errors.Wrap(err, "read layer cache")
+	}
+	if target == nil {
+		return nil, nil
+	}
+
+	// The converted blob may have been garbage collected.
+	if _, err := lc.content.store.Info(ctx, target.Digest); err != nil {
+		if !errors.Is(err, errdefs.ErrNotFound) {
+			return nil, err
+		}
+		if err := lc.content.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(bucketKeyLayerCache)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.Delete([]byte(key.String()))
+		}); err != nil {
+			return nil, errors.Wrap(err, "delete stale layer cache")
+		}
+		return nil, nil
+	}
+
+	// Update the lease so that the hit blob is less likely to be collected.
+	if err := lc.content.updateLease(&target.Digest); err != nil {
+		logrus.WithError(err).Warnf("update lease of layer %s", target.Digest)
+	}
+
+	return target, nil
+}
+
+func (lc *layerCache) Set(_ context.Context, key cache.LayerKey, target ocispec.Descriptor) error {
+	value, err := json.Marshal(target)
+	if err != nil {
+		return errors.Wrap(err, "marshal layer cache")
+	}
+	return lc.content.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketKeyLayerCache)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key.String()), value)
+	})
+}
+
+// pruneLayerCache removes the records of which the converted blob
+// has been garbage collected.
+func (content *Content) pruneLayerCache() error {
+	return content.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketKeyLayerCache)
+		if bucket == nil {
+			return nil
+		}
+		blobs := getBlobsBucket(tx)
+		stale := [][]byte{}
+		if err := bucket.ForEach(func(key, value []byte) error {
+			var target ocispec.Descriptor
+			if err := json.Unmarshal(value, &target); err != nil {
+				stale = append(stale, key)
+				return nil
+			}
+			if blobs == nil || blobs.Bucket([]byte(target.Digest.String())) == nil {
+				stale = append(stale, key)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		if len(stale) > 0 {
+			logrus.Infof("pruned %d stale layer cache records", len(stale))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+)
+
+func TestLayerCache(t *testing.T) {
+	dir := t.TempDir()
+	content, err := NewContent(nil, dir, dir, "1000MB")
+	require.NoError(t, err)
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+
+	data := []byte("converted layer")
+	target := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, ctrcontent.WriteBlob(ctx, content, target.Digest.String(), bytes.NewReader(data), target))
+
+	key := cache.LayerKey{
+		Source:         digest.FromString("source layer"),
+		Driver:         "nydus",
+		ConfigHash:     "hash",
+		BuilderVersion: "v2.2.4",
+	}
+	lc := content.LocalCache()
+	cached, err := lc.Get(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	require.NoError(t, lc.Set(ctx, key, target))
+	cached, err = lc.Get(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, target.Digest, cached.Digest)
+
+	// Unmatched builder version.
+	otherKey := key
+	otherKey.BuilderVersion = "v2.2.5"
+	cached, err = lc.Get(ctx, otherKey)
+	require.NoError(t, err)
+	require.Nil(t, cached)
+
+	// The record is removed once the blob is collected.
+	require.NoError(t, content.Delete(ctx, target.Digest))
+	require.NoError(t, content.pruneLayerCache())
+	cached, err = lc.Get(ctx, key)
+	require.NoError(t, err)
+	require.Nil(t, cached)
+}
+
+func TestRemoteCacheInfo(t *testing.T) {
+	dir := t.TempDir()
+	content, err := NewContent(nil, dir, dir, "1000MB")
+	require.NoError(t, err)
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+	ctx, _ = cache.New(ctx, "192.168.1.1:5000/library/shared-cache:latest", "v1", 200, cache.EvictionLRU, nil)
+
+	source := ocispec.Descriptor{Digest: digest.FromString("source layer"), Size: 1}
+	target := ocispec.Descriptor{Digest: digest.FromString("target layer"), Size: 2}
+	cache.Set(ctx, source, target)
+
+	// The cached blob can be mounted from the cache repository.
+	info, err := content.Info(ctx, target.Digest)
+	require.NoError(t, err)
+	require.Equal(t, target.Size, info.Size)
+	require.Equal(t, "library/shared-cache", info.Labels["containerd.io/distribution.source.192.168.1.1"])
+}
@@ -19,12 +19,15 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
 	"github.com/containerd/containerd/remotes"
+	"github.com/dustin/go-humanize"
 	"github.com/goharbor/acceleration-service/pkg/cache"
 	"github.com/goharbor/acceleration-service/pkg/config"
 	"github.com/goharbor/acceleration-service/pkg/remote"
@@ -35,12 +38,49 @@ import (
 type LocalProvider struct {
 	mutex        sync.Mutex
 	images       map[string]*ocispec.Descriptor
-	usePlainHTTP bool
+	plainHTTP    *remote.PlainHTTPFallback
 	content      *Content
 	hosts        remote.HostFunc
 	platformMC   platforms.MatchComparer
 	cacheSize    int
 	cacheVersion string
+	cacheEvict   cache.EvictionPolicy
+	localCache   bool
+	maxDownloads int
+	maxUploads   int
+	bandwidth    *remote.BandwidthLimiter
+}
+
+func parseBandwidth(limit string) (uint64, error) {
+	if limit == "" {
+		return 0, nil
+	}
+	bps, err := humanize.ParseBytes(limit)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse bandwidth %s", limit)
+	}
+	return bps, nil
+}
+
+func newBandwidthLimiter(cfg config.BandwidthConfig) (*remote.BandwidthLimiter, error) {
+	perHost, err := parseBandwidth(cfg.PerHost)
+	if err != nil {
+		return nil, err
+	}
+	global, err := parseBandwidth(cfg.Global)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]uint64)
+	for host, limit := range cfg.Hosts {
+		if hosts[host], err = parseBandwidth(limit); err != nil {
+			return nil, err
+		}
+	}
+	if perHost == 0 && global == 0 && len(hosts) == 0 {
+		return nil, nil
+	}
+	return remote.NewBandwidthLimiter(perHost, hosts, global), nil
 }
 
 func NewLocalProvider(cfg *config.Config, platformMC platforms.MatchComparer) (Provider, *Content, error) {
@@ -48,10 +88,26 @@ func NewLocalProvider(cfg *config.Config, platformMC platforms.MatchComparer) (P
 	if err := os.MkdirAll(contentDir, 0755); err != nil {
 		return nil, nil, errors.Wrap(err, "create local provider work directory")
 	}
+	cacheEvict, err := cache.ParseEvictionPolicy(cfg.Provider.CacheEviction)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse cache eviction policy")
+	}
+	bandwidth, err := newBandwidthLimiter(cfg.Provider.Bandwidth)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse bandwidth limit")
+	}
+	var fallbackTTL time.Duration
+	if cfg.Provider.PlainHTTPFallbackTTL != "" {
+		if fallbackTTL, err = time.ParseDuration(cfg.Provider.PlainHTTPFallbackTTL); err != nil {
+			return nil, nil, errors.Wrap(err, "parse plain HTTP fallback ttl")
+		}
+	}
 	content, err := NewContent(cfg.Host, contentDir, cfg.Provider.WorkDir, cfg.Provider.GCPolicy.Threshold)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "create local provider content")
 	}
+	// The fallback is shared with content for fetching the blobs of remote cache.
+	content.plainHTTP = remote.NewPlainHTTPFallback(fallbackTTL)
 	return &LocalProvider{
 		content:      content,
 		images:       make(map[string]*ocispec.Descriptor),
@@ -59,34 +115,41 @@ func NewLocalProvider(cfg *config.Config, platformMC platforms.MatchComparer) (P
 		platformMC:   platformMC,
 		cacheSize:    cfg.Provider.CacheSize,
 		cacheVersion: cfg.Provider.CacheVersion,
+		cacheEvict:   cacheEvict,
+		localCache:   cfg.Provider.LocalCache,
+		maxDownloads: cfg.Provider.MaxConcurrentDownloads,
+		maxUploads:   cfg.Provider.MaxConcurrentUploads,
+		bandwidth:    bandwidth,
+		plainHTTP:    content.plainHTTP,
 	}, content, nil
 }
 
-func (pvd *LocalProvider) UsePlainHTTP() {
-	pvd.usePlainHTTP = true
+func (pvd *LocalProvider) UsePlainHTTP(ref string) error {
+	return pvd.plainHTTP.Fallback(ref)
 }
 
-func (pvd *LocalProvider) Resolver(ref string) (remotes.Resolver, error) {
-	credFunc, insecure, err := pvd.hosts(ref)
+func (pvd *LocalProvider) Resolver(ref string, op remote.Operation) (remotes.Resolver, error) {
+	hostConfig, err := pvd.hosts(ref)
 	if err != nil {
 		return nil, err
 	}
-	return remote.NewResolver(insecure, pvd.usePlainHTTP, credFunc), nil
+	return remote.NewHostResolver(hostConfig.For(op), pvd.plainHTTP, nil), nil
 }
 
 func (pvd *LocalProvider) Pull(ctx context.Context, ref string) error {
-	resolver, err := pvd.Resolver(ref)
+	hostConfig, err := pvd.hosts(ref)
 	if err != nil {
 		return err
 	}
 
-	// TODO: sets max concurrent downloaded layer limit by containerd.WithMaxConcurrentDownloads.
 	rc := &containerd.RemoteContext{
-		Resolver:        resolver,
-		PlatformMatcher: pvd.platformMC,
+		Resolver:               remote.NewHostResolver(hostConfig.For(remote.OperationPull), pvd.plainHTTP, nil),
+		PlatformMatcher:        pvd.platformMC,
+		MaxConcurrentDownloads: pvd.maxDownloads,
 	}
 
-	img, err := fetch(ctx, pvd.ContentStore(), rc, ref, 0)
+	ctx = remote.WithBandwidthLimiter(ctx, pvd.bandwidth)
+	img, err := fetch(ctx, pvd.ContentStore(), rc, ref, 0, hostConfig.Retry)
 	if err != nil {
 		return errors.Wrap(err, "pull source image")
 	}
@@ -95,17 +158,60 @@ func (pvd *LocalProvider) Pull(ctx context.Context, ref string) error {
 	return nil
 }
 
+func (pvd *LocalProvider) Resolve(ctx context.Context, ref string) (*ocispec.Descriptor, error) {
+	hostConfig, err := pvd.hosts(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolver := remote.NewHostResolver(hostConfig.For(remote.OperationPull), pvd.plainHTTP, nil)
+
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve reference %s", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get fetcher for %s", name)
+	}
+
+	// Skip the layers, the manifests of all platforms are fetched
+	// to know which of them are matched.
+	childrenHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		children, err := images.ChildrenHandler(pvd.content)(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		metadata := []ocispec.Descriptor{}
+		for _, child := range children {
+			if !images.IsLayerType(child.MediaType) {
+				metadata = append(metadata, child)
+			}
+		}
+		return metadata, nil
+	})
+	if err := images.Dispatch(ctx, images.Handlers(remotes.FetchHandler(pvd.content, fetcher), childrenHandler), nil, desc); err != nil {
+		return nil, errors.Wrapf(err, "fetch metadata of image %s", ref)
+	}
+
+	return &desc, nil
+}
+
 func (pvd *LocalProvider) Push(ctx context.Context, desc ocispec.Descriptor, ref string) error {
-	resolver, err := pvd.Resolver(ref)
+	hostConfig, err := pvd.hosts(ref)
 	if err != nil {
 		return err
 	}
+	// The blobs with distribution source label of the same registry are cross-repo
+	// mounted, the mounted bytes are counted by the tracker.
+	resolver := remote.NewHostResolver(hostConfig.For(remote.OperationPush), pvd.plainHTTP, remote.NewStatusTracker(ctx))
 
 	rc := &containerd.RemoteContext{
-		Resolver:        resolver,
-		PlatformMatcher: pvd.platformMC,
+		Resolver:                    resolver,
+		PlatformMatcher:             pvd.platformMC,
+		MaxConcurrentUploadedLayers: pvd.maxUploads,
 	}
 
+	ctx = remote.WithBandwidthLimiter(ctx, pvd.bandwidth)
 	return push(ctx, pvd.ContentStore(), rc, desc, ref)
 }
 
@@ -119,11 +225,18 @@ func (pvd *LocalProvider) ContentStore() content.Store {
 
 func (pvd *LocalProvider) NewRemoteCache(ctx context.Context, cacheRef string) (context.Context, *cache.RemoteCache) {
 	if cacheRef != "" {
-		return cache.New(ctx, cacheRef, pvd.cacheVersion, pvd.cacheSize, pvd)
+		return cache.New(ctx, cacheRef, pvd.cacheVersion, pvd.cacheSize, pvd.cacheEvict, pvd)
 	}
 	return ctx, nil
 }
 
+func (pvd *LocalProvider) LocalCache() cache.LocalCache {
+	if pvd.localCache {
+		return pvd.content.LocalCache()
+	}
+	return nil
+}
+
 func (pvd *LocalProvider) setImage(ref string, image *ocispec.Descriptor) {
 	pvd.mutex.Lock()
 	defer pvd.mutex.Unlock()
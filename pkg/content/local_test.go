@@ -0,0 +1,292 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package content
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+// newTestProvider creates a provider using plain HTTP for the hosts.
+func newTestProvider(t *testing.T, hosts ...string) Provider {
+	source := make(map[string]config.SourceConfig)
+	for _, host := range hosts {
+		source[host] = config.SourceConfig{PlainHTTP: true}
+	}
+	return newTestProviderWithSource(t, source)
+}
+
+func newTestProviderWithSource(t *testing.T, source map[string]config.SourceConfig) Provider {
+	cfg := &config.Config{}
+	cfg.Provider.Source = source
+	cfg.Provider.WorkDir = t.TempDir()
+	cfg.Provider.GCPolicy.Threshold = "1000MB"
+	pvd, _, err := NewLocalProvider(cfg, platforms.All)
+	require.NoError(t, err)
+	return pvd
+}
+
+func writeTestBlob(t *testing.T, ctx context.Context, cs ctrcontent.Store, mediaType string, data []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, ctrcontent.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+	return desc
+}
+
+// pushTestImage pushes an image of single layer to ref by a new provider.
+func pushTestImage(t *testing.T, ctx context.Context, ref string, layerData []byte) (ocispec.Descriptor, ocispec.Descriptor, ocispec.Descriptor) {
+	pvd := newTestProvider(t, strings.SplitN(ref, "/", 2)[0])
+	return pushTestImageBy(t, ctx, pvd, ref, layerData)
+}
+
+func pushTestImageBy(t *testing.T, ctx context.Context, pvd Provider, ref string, layerData []byte) (ocispec.Descriptor, ocispec.Descriptor, ocispec.Descriptor) {
+	layer := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageLayerGzip, layerData)
+	configBytes, err := json.Marshal(ocispec.Image{Platform: platforms.DefaultSpec()})
+	require.NoError(t, err)
+	imageConfig := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageConfig, configBytes)
+	manifestBytes, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    imageConfig,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	manifest := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageManifest, manifestBytes)
+	require.NoError(t, pvd.Push(ctx, manifest, ref))
+	return manifest, imageConfig, layer
+}
+
+func TestPushMount(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+
+	manifest, imageConfig, layer := pushTestImage(t, ctx, registry.Host()+"/library/base:latest", []byte("base layer"))
+
+	// The pulled blobs are labeled with distribution source, they're mounted
+	// instead of uploaded when pushing to another repository.
+	pvd := newTestProvider(t, registry.Host())
+	require.NoError(t, pvd.Pull(ctx, registry.Host()+"/library/base:latest"))
+	pushCtx, stats := remote.WithPushStats(ctx)
+	require.NoError(t, pvd.Push(pushCtx, manifest, registry.Host()+"/library/app:latest"))
+	require.Equal(t, layer.Size+imageConfig.Size, stats.MountedBytes())
+	require.True(t, registry.HasBlob("library/app", layer.Digest))
+}
+
+func TestPullResume(t *testing.T) {
+	fetchRetryInterval = 10 * time.Millisecond
+	registry := registrytest.New()
+	defer registry.Close()
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+
+	layerData := bytes.Repeat([]byte("layer"), 1024)
+	_, _, layer := pushTestImage(t, ctx, registry.Host()+"/library/app:latest", layerData)
+
+	// The first download of layer is interrupted at the half, and the reconnection
+	// fails, so the pull is retried from the written offset.
+	var requests int32
+	ranges := make(chan string, 10)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/blobs/"+layer.Digest.String()) {
+			switch atomic.AddInt32(&requests, 1) {
+			case 1:
+				w.Header().Set("Content-Length", strconv.Itoa(len(layerData)))
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write(layerData[:len(layerData)/2])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			case 2:
+				panic(http.ErrAbortHandler)
+			default:
+				ranges <- req.Header.Get("Range")
+			}
+		}
+		registry.ServeHTTP(w, req)
+	}))
+	defer flaky.Close()
+
+	flakyHost := strings.TrimPrefix(flaky.URL, "http://")
+	pvd := newTestProvider(t, flakyHost)
+	require.NoError(t, pvd.Pull(ctx, flakyHost+"/library/app:latest"))
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	require.Equal(t, fmt.Sprintf("bytes=%d-", len(layerData)/2), <-ranges)
+
+	data, err := ctrcontent.ReadBlob(ctx, pvd.ContentStore(), layer)
+	require.NoError(t, err)
+	require.Equal(t, layerData, data)
+}
+
+func TestPullMirror(t *testing.T) {
+	origin := registrytest.New()
+	defer origin.Close()
+	mirror := registrytest.New()
+	defer mirror.Close()
+	down := registrytest.New()
+	down.Close()
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+
+	pushTestImage(t, ctx, mirror.Host()+"/library/mirrored:latest", []byte("mirrored layer"))
+	pushTestImage(t, ctx, origin.Host()+"/library/origin:latest", []byte("origin layer"))
+
+	var mirrored int32
+	mirror.OnRequest = func(req *http.Request) {
+		// The namespace of origin host is passed to mirror.
+		if req.URL.Query().Get("ns") == origin.Host() {
+			atomic.AddInt32(&mirrored, 1)
+		}
+	}
+	origin.OnRequest = func(req *http.Request) {
+		require.NotContains(t, req.URL.Path, "mirrored")
+	}
+
+	pvd := newTestProviderWithSource(t, map[string]config.SourceConfig{
+		origin.Host(): {
+			PlainHTTP: true,
+			Mirrors: []config.MirrorConfig{
+				{Endpoint: down.URL},
+				{Endpoint: mirror.URL},
+			},
+		},
+	})
+	// Pulls from the mirror, and falls back to the origin if not found in mirrors.
+	require.NoError(t, pvd.Pull(ctx, origin.Host()+"/library/mirrored:latest"))
+	require.NotZero(t, atomic.LoadInt32(&mirrored))
+	require.NoError(t, pvd.Pull(ctx, origin.Host()+"/library/origin:latest"))
+}
+
+func TestPlainHTTPFallback(t *testing.T) {
+	httpRegistry := registrytest.New()
+	defer httpRegistry.Close()
+	httpsRegistry := registrytest.NewTLS()
+	defer httpsRegistry.Close()
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+
+	httpRef := httpRegistry.Host() + "/library/app:latest"
+	httpsRef := httpsRegistry.Host() + "/library/app:latest"
+	pushTestImage(t, ctx, httpRef, []byte("http layer"))
+
+	cfg := &config.Config{}
+	cfg.Provider.Source = map[string]config.SourceConfig{
+		httpsRegistry.Host(): {Insecure: true},
+	}
+	cfg.Provider.WorkDir = t.TempDir()
+	cfg.Provider.GCPolicy.Threshold = "1000MB"
+	cfg.Provider.PlainHTTPFallbackTTL = "10m"
+	pvd, _, err := NewLocalProvider(cfg, platforms.All)
+	require.NoError(t, err)
+	now := time.Now()
+	pvd.(*LocalProvider).plainHTTP.SetClock(func() time.Time { return now })
+	pushTestImageBy(t, ctx, pvd, httpsRef, []byte("https layer"))
+
+	// HTTPS is tried first for the HTTP-only registry.
+	err = pvd.Pull(ctx, httpRef)
+	require.True(t, errdefs.NeedsRetryWithHTTP(err), err)
+
+	// The fallback of HTTP-only registry doesn't affect the HTTPS one.
+	require.NoError(t, pvd.UsePlainHTTP(httpRef))
+	require.NoError(t, pvd.Pull(ctx, httpRef))
+	require.NoError(t, pvd.Pull(ctx, httpsRef))
+
+	// HTTPS is tried again after the fallback expires.
+	now = now.Add(9 * time.Minute)
+	require.NoError(t, pvd.Pull(ctx, httpRef))
+	now = now.Add(2 * time.Minute)
+	err = pvd.Pull(ctx, httpRef)
+	require.True(t, errdefs.NeedsRetryWithHTTP(err), err)
+
+	// The host configured with `plain_http` never tries HTTPS.
+	cfg.Provider.Source[httpRegistry.Host()] = config.SourceConfig{PlainHTTP: true}
+	require.NoError(t, pvd.Pull(ctx, httpRef))
+}
+
+func TestWithRemoteCache(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ctx := namespaces.WithNamespace(context.Background(), accelerationServiceNamespace)
+	ref := registry.Host() + "/library/app:nydus-cache"
+
+	// Push a remote cache recording a target layer of the pushed source layer.
+	_, _, source := pushTestImage(t, ctx, registry.Host()+"/library/app:latest", []byte("source layer"))
+	pvd := newTestProvider(t, registry.Host())
+	target := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageLayer, []byte("target layer"))
+	target.Annotations = map[string]string{
+		cache.SourceDigestAnnotation("nydus", ""): source.Digest.String(),
+	}
+	imageConfig := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageConfig, []byte("{}"))
+	manifestBytes, err := json.Marshal(ocispec.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Config:      imageConfig,
+		Layers:      []ocispec.Descriptor{target},
+		Annotations: map[string]string{cache.LayerAnnotationCacheVersion: "v1"},
+	})
+	require.NoError(t, err)
+	manifest := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageManifest, manifestBytes)
+	platform := platforms.DefaultSpec()
+	manifest.Platform = &platform
+	// The cache index is indented as written by RemoteCache.
+	indexBytes, err := json.MarshalIndent(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{manifest},
+	}, "", "  ")
+	require.NoError(t, err)
+	index := writeTestBlob(t, ctx, pvd.ContentStore(), ocispec.MediaTypeImageIndex, indexBytes)
+	require.NoError(t, pvd.Push(ctx, index, ref))
+
+	// The remote cache is read by a provider without namespace in context,
+	// and falls back to plain HTTP for the registry.
+	pvd = newTestProviderWithSource(t, nil)
+	calls := 0
+	require.NoError(t, WithRemoteCache(context.Background(), pvd, ref, func(ctx context.Context, rc *cache.RemoteCache) error {
+		calls++
+		records, err := rc.Records(ctx)
+		if err != nil {
+			return err
+		}
+		require.Len(t, records, 1)
+		require.Equal(t, source.Digest, records[0].Source)
+		require.Equal(t, source.Size, records[0].SourceSize)
+		require.Equal(t, "v1", records[0].Version)
+		return nil
+	}))
+	require.Equal(t, 2, calls)
+}
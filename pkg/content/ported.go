@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
@@ -40,9 +41,17 @@ import (
 
 var fetchSingleflight = &singleflight.Group{}
 
+var (
+	// fetchRetryInterval is the initial backoff interval of layer download retry.
+	fetchRetryInterval    = time.Second
+	maxFetchRetryInterval = 30 * time.Second
+)
+
 // Ported from containerd project, copyright The containerd Authors.
 // github.com/containerd/containerd/blob/main/pull.go
-func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteContext, ref string, limit int) (images.Image, error) {
+//
+// The failed layer download is retried for at most retry times.
+func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteContext, ref string, limit int, retry int) (images.Image, error) {
 	name, desc, err := rCtx.Resolver.Resolve(ctx, ref)
 	if err != nil {
 		return images.Image{}, fmt.Errorf("failed to resolve reference %q: %w", ref, err)
@@ -107,7 +116,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 		}
 
 		handlers := append(rCtx.BaseHandlers,
-			fetchHandler(store, fetcher),
+			fetchHandler(store, fetcher, retry),
 			convertibleHandler,
 			childrenHandler,
 			appendDistSrcLabelHandler,
@@ -147,7 +156,7 @@ func fetch(ctx context.Context, store content.Store, rCtx *containerd.RemoteCont
 
 // Ported from containerd project, copyright The containerd Authors.
 // https://github.com/containerd/containerd/blob/main/remotes/handlers.go
-func fetchHandler(ingester content.Ingester, fetcher remotes.Fetcher) images.HandlerFunc {
+func fetchHandler(ingester content.Ingester, fetcher remotes.Fetcher, retry int) images.HandlerFunc {
 	return func(ctx context.Context, desc ocispec.Descriptor) (subdescs []ocispec.Descriptor, err error) {
 		ctx = log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
 			"digest":    desc.Digest,
@@ -160,7 +169,7 @@ func fetchHandler(ingester content.Ingester, fetcher remotes.Fetcher) images.Han
 			return nil, fmt.Errorf("%v not supported", desc.MediaType)
 		default:
 			_, err, _ := fetchSingleflight.Do(string(desc.Digest), func() (interface{}, error) {
-				return nil, remotes.Fetch(ctx, ingester, fetcher, desc)
+				return nil, fetchWithRetry(ctx, ingester, fetcher, desc, retry)
 			})
 			if errdefs.IsAlreadyExists(err) {
 				return nil, nil
@@ -170,6 +179,46 @@ func fetchHandler(ingester content.Ingester, fetcher remotes.Fetcher) images.Han
 	}
 }
 
+// fetchWithRetry retries the failed download with exponential backoff, the
+// partially written content is kept in ingest, so the retry resumes from the
+// written offset by ranged request.
+func fetchWithRetry(ctx context.Context, ingester content.Ingester, fetcher remotes.Fetcher, desc ocispec.Descriptor, retry int) error {
+	interval := fetchRetryInterval
+	for attempt := 0; ; attempt++ {
+		err := remotes.Fetch(ctx, ingester, fetcher, desc)
+		if err == nil || attempt >= retry || !retryable(ctx, err) {
+			return err
+		}
+
+		if errdefs.IsFailedPrecondition(err) {
+			// The written content doesn't match the descriptor, discard it and restart.
+			if manager, ok := ingester.(content.IngestManager); ok {
+				if err := manager.Abort(ctx, remotes.MakeRefKey(ctx, desc)); err != nil && !errdefs.IsNotFound(err) {
+					return fmt.Errorf("failed to abort ingest: %w", err)
+				}
+			}
+		}
+
+		log.G(ctx).WithError(err).Warnf("failed to fetch, retrying in %s (%d/%d)", interval, attempt+1, retry)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxFetchRetryInterval {
+			interval = maxFetchRetryInterval
+		}
+	}
+}
+
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return !errdefs.IsAlreadyExists(err) && !errdefs.IsNotFound(err) &&
+		!errdefs.IsInvalidArgument(err) && !errdefs.IsNotImplemented(err)
+}
+
 // Ported from containerd project, copyright The containerd Authors.
 // github.com/containerd/containerd/blob/main/client.go
 func push(ctx context.Context, store content.Store, pushCtx *containerd.RemoteContext, desc ocispec.Descriptor, ref string) error {
@@ -18,23 +18,32 @@ import (
 	"context"
 
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/remotes"
 	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/remote"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Provider provides necessary image utils, image content
 // store for image conversion.
 type Provider interface {
-	// Use plain HTTP to communicate with registry.
-	UsePlainHTTP()
+	// UsePlainHTTP uses plain HTTP to communicate with the registry host of
+	// the reference, the fallback expires after a while.
+	UsePlainHTTP(ref string) error
 
-	// Resolve attempts to resolve the reference into a name and descriptor.
-	Resolver(ref string) (remotes.Resolver, error)
+	// Resolver returns the resolver of the reference, the credential is
+	// chosen by the operation and the repository of reference.
+	Resolver(ref string, op remote.Operation) (remotes.Resolver, error)
 	// Pull pulls source image from remote registry by specified reference.
 	// This pulls all platforms of the image but Image() returns containerd.Image for
 	// the default platform.
 	Pull(ctx context.Context, ref string) error
+	// Resolve fetches the index, manifests and configs of source image into
+	// content store without the layers, and returns the image descriptor,
+	// it's used to plan a conversion without pulling the image.
+	Resolve(ctx context.Context, ref string) (*ocispec.Descriptor, error)
 	// Push pushes target image to remote registry by specified reference,
 	// the desc parameter represents the manifest of targe image.
 	Push(ctx context.Context, desc ocispec.Descriptor, ref string) error
@@ -45,4 +54,29 @@ type Provider interface {
 	ContentStore() content.Store
 	// RemoteCache gets the remote cache for conversion.
 	NewRemoteCache(ctx context.Context, ref string) (context.Context, *cache.RemoteCache)
+	// LocalCache gets the local cache of converted layers, nil if disabled.
+	LocalCache() cache.LocalCache
+}
+
+// HealthChecker is implemented by the provider depending on an external
+// service, like containerd.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// WithRemoteCache opens the remote cache of ref by provider and calls fn with
+// it, the context is namespaced as the content store requires. fn is called
+// again with a new remote cache over plain HTTP if the registry doesn't serve
+// HTTPS, so it should fail before changing anything in that case.
+func WithRemoteCache(ctx context.Context, pvd Provider, ref string, fn func(ctx context.Context, rc *cache.RemoteCache) error) error {
+	ctx = namespaces.WithNamespace(ctx, accelerationServiceNamespace)
+	cacheCtx, rc := pvd.NewRemoteCache(ctx, ref)
+	err := fn(cacheCtx, rc)
+	if errdefs.NeedsRetryWithHTTP(err) {
+		if err = pvd.UsePlainHTTP(ref); err == nil {
+			cacheCtx, rc = pvd.NewRemoteCache(ctx, ref)
+			err = fn(cacheCtx, rc)
+		}
+	}
+	return err
 }
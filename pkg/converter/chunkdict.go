@@ -0,0 +1,99 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/driver"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+)
+
+// BuildChunkDict pulls the specified converted images, generates a chunk dict
+// image from them by driver and pushes it to the ref, then the subsequent
+// conversions will use the new chunk dict image for deduplication.
+func (cvt *Converter) BuildChunkDict(ctx context.Context, images []string, ref string) error {
+	builder, ok := cvt.driver.(driver.ChunkDictBuilder)
+	if !ok {
+		return fmt.Errorf("driver %s doesn't support chunk dict", cvt.driver.Name())
+	}
+
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse chunk dict reference")
+	}
+	ref = named.String()
+
+	sources := []string{}
+	for _, image := range images {
+		named, err := docker.ParseDockerRef(image)
+		if err != nil {
+			return errors.Wrapf(err, "parse image reference %s", image)
+		}
+		image = named.String()
+		logger.Infof("pulling image %s for chunk dict", image)
+		if err := cvt.provider.Pull(ctx, image); err != nil {
+			if errdefs.NeedsRetryWithHTTP(err) {
+				logger.Infof("try to pull with plain HTTP for %s", image)
+				if err = cvt.provider.UsePlainHTTP(image); err == nil {
+					err = cvt.provider.Pull(ctx, image)
+				}
+			}
+			if err != nil {
+				return errors.Wrapf(err, "pull image %s", image)
+			}
+		}
+		sources = append(sources, image)
+	}
+
+	logger.Infof("building chunk dict %s from %d images", ref, len(sources))
+	start := time.Now()
+	desc, err := builder.BuildChunkDict(ctx, cvt.provider, sources)
+	if err != nil {
+		return errors.Wrap(err, "build chunk dict")
+	}
+	logger.Infof("built chunk dict %s, elapse %s", ref, time.Since(start))
+
+	logger.Infof("pushing chunk dict %s", ref)
+	if err := cvt.provider.Push(ctx, *desc, ref); err != nil {
+		if errdefs.NeedsRetryWithHTTP(err) {
+			logger.Infof("try to push with plain HTTP for %s", ref)
+			if err = cvt.provider.UsePlainHTTP(ref); err == nil {
+				err = cvt.provider.Push(ctx, *desc, ref)
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "push chunk dict")
+		}
+	}
+	logger.Infof("pushed chunk dict %s", ref)
+
+	builder.SetChunkDictRef(ref)
+
+	return nil
+}
+
+// SetChunkDictRef updates the chunk dict image used by the subsequent
+// conversions, it's ignored if the driver doesn't support chunk dict.
+func (cvt *Converter) SetChunkDictRef(ref string) {
+	if builder, ok := cvt.driver.(driver.ChunkDictBuilder); ok {
+		builder.SetChunkDictRef(ref)
+	}
+}
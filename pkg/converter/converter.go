@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/goharbor/acceleration-service/pkg/content"
 	"github.com/goharbor/acceleration-service/pkg/driver"
 	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/remote"
 	"github.com/goharbor/acceleration-service/pkg/utils"
 )
 
@@ -40,6 +42,8 @@ type Converter struct {
 	provider         content.Provider
 	platformMC       platforms.MatchComparer
 	extraAnnotations map[string]string
+	verify           bool
+	verifyFS         bool
 }
 
 func New(opts ...ConvertOpt) (*Converter, error) {
@@ -65,6 +69,8 @@ func New(opts ...ConvertOpt) (*Converter, error) {
 		provider:         options.provider,
 		platformMC:       platformMC,
 		extraAnnotations: options.annotations,
+		verify:           options.verify,
+		verifyFS:         options.verifyFS,
 	}
 
 	return handler, nil
@@ -103,15 +109,21 @@ func (cvt *Converter) Convert(ctx context.Context, source, target, cacheRef stri
 	source = sourceNamed.String()
 	target = targetNamed.String()
 
+	if localCache := cvt.provider.LocalCache(); localCache != nil {
+		ctx = cache.WithLocalCache(ctx, localCache)
+	}
 	ctx, cache := cvt.provider.NewRemoteCache(ctx, cacheRef)
 	if cache != nil {
+		cache.SetDriver(cvt.driver.Name(), cvt.driver.Version())
+		cache.SetSource(source)
 		logger.Infof("pulling cache %s", cacheRef)
 		cacheManifest, err := cache.Fetch(ctx, cvt.platformMC)
 		if err != nil {
 			if errdefs.NeedsRetryWithHTTP(err) {
 				logger.Infof("try to pull cache with plain HTTP for %s", cacheRef)
-				cvt.provider.UsePlainHTTP()
-				cacheManifest, err = cache.Fetch(ctx, cvt.platformMC)
+				if err = cvt.provider.UsePlainHTTP(cacheRef); err == nil {
+					cacheManifest, err = cache.Fetch(ctx, cvt.platformMC)
+				}
 			}
 			if err != nil {
 				if errors.Is(err, ctrErrdefs.ErrNotFound) {
@@ -131,7 +143,9 @@ func (cvt *Converter) Convert(ctx context.Context, source, target, cacheRef stri
 	if err := cvt.pull(ctx, source); err != nil {
 		if errdefs.NeedsRetryWithHTTP(err) {
 			logger.Infof("try to pull with plain HTTP for %s", source)
-			cvt.provider.UsePlainHTTP()
+			if err := cvt.provider.UsePlainHTTP(source); err != nil {
+				return nil, errors.Wrap(err, "use plain HTTP")
+			}
 			if err := cvt.pull(ctx, source); err != nil {
 				return nil, errors.Wrap(err, "try to pull image")
 			}
@@ -169,6 +183,24 @@ func (cvt *Converter) Convert(ctx context.Context, source, target, cacheRef stri
 	}
 	logger.Infof("converted image %s %s, elapse %s", target, hitInfo, metric.ConversionElapsed)
 
+	// The verification runs after the conversion, so the blobs pushed to the
+	// storage backend by driver (like nydus `backend_type`) are left there if
+	// it fails, only the image isn't pushed. The driver skips the checks of
+	// the blobs which aren't in content store.
+	if cvt.verify {
+		logger.Infof("verifying image %s", target)
+		start = time.Now()
+		sourceImage, err := cvt.provider.Image(ctx, source)
+		if err != nil {
+			return nil, errors.Wrap(err, "get source image")
+		}
+		if err := cvt.verifyImage(ctx, *sourceImage, *desc); err != nil {
+			return nil, errors.Wrap(err, "verify image")
+		}
+		metric.VerifyElapsed = time.Since(start)
+		logger.Infof("verified image %s, elapse %s", target, metric.VerifyElapsed)
+	}
+
 	if cache != nil {
 		sourceImage, err := cvt.provider.Image(ctx, source)
 		if err != nil {
@@ -181,13 +213,26 @@ func (cvt *Converter) Convert(ctx context.Context, source, target, cacheRef stri
 		logger.Infof("pushed cache %s", cacheRef)
 	}
 
+	if mountRef := mountFrom(ctx); mountRef != "" {
+		sourceImage, err := cvt.provider.Image(ctx, source)
+		if err != nil {
+			return nil, errors.Wrap(err, "get source image")
+		}
+		if err := cvt.labelMountSource(ctx, *sourceImage, *desc, mountRef); err != nil {
+			return nil, errors.Wrap(err, "label mount source")
+		}
+	}
+
 	start = time.Now()
 	logger.Infof("pushing image %s", target)
-	if err := cvt.provider.Push(ctx, *desc, target); err != nil {
+	pushCtx, pushStats := remote.WithPushStats(ctx)
+	if err := cvt.provider.Push(pushCtx, *desc, target); err != nil {
 		if errdefs.NeedsRetryWithHTTP(err) {
 			logger.Infof("try to push with plain HTTP for %s", target)
-			cvt.provider.UsePlainHTTP()
-			if err := cvt.provider.Push(ctx, *desc, target); err != nil {
+			if err := cvt.provider.UsePlainHTTP(target); err != nil {
+				return nil, errors.Wrap(err, "use plain HTTP")
+			}
+			if err := cvt.provider.Push(pushCtx, *desc, target); err != nil {
 				return nil, errors.Wrap(err, "try to push image")
 			}
 		} else {
@@ -195,7 +240,8 @@ func (cvt *Converter) Convert(ctx context.Context, source, target, cacheRef stri
 		}
 	}
 	metric.TargetPushElapsed = time.Since(start)
-	logger.Infof("pushed image %s, elapse %s", target, metric.TargetPushElapsed)
+	metric.TargetMountedSize = pushStats.MountedBytes()
+	logger.Infof("pushed image %s, elapse %s, mounted %s", target, metric.TargetPushElapsed, humanize.Bytes(uint64(metric.TargetMountedSize)))
 
 	return &metric, nil
 }
@@ -0,0 +1,184 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images/converter"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+)
+
+// convertSingleflight is shared by all conversions in the process,
+// so that the concurrent conversions of different images can share
+// the same in-flight layer conversion.
+var convertSingleflight = &singleflight.Group{}
+
+// waitHook is called once a caller waits on the in-flight conversion,
+// it's used by tests.
+var waitHook func()
+
+// ConfigHash returns a stable hash of driver config, the config
+// affecting the layer conversion output must be included.
+func ConfigHash(name string, cfg map[string]string) string {
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString(name)
+	for _, key := range keys {
+		fmt.Fprintf(&builder, "\n%s=%s", key, cfg[key])
+	}
+
+	return digest.FromString(builder.String()).Encoded()
+}
+
+// detach returns a context which isn't cancelled with ctx, only the values
+// shared by all conversions are kept, so the in-flight conversion isn't
+// bound to the caller starting it.
+func detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if namespace, ok := namespaces.Namespace(ctx); ok {
+		detached = namespaces.WithNamespace(detached, namespace)
+	}
+	if lease, ok := leases.FromContext(ctx); ok {
+		detached = leases.WithLease(detached, lease)
+	}
+	if localCache := cache.GetLocalCache(ctx); localCache != nil {
+		detached = cache.WithLocalCache(detached, localCache)
+	}
+	return detached
+}
+
+// addLease adds the converted blob to the lease in ctx. The in-flight
+// conversion writes the blob with the lease of the caller starting it, which
+// may be deleted before the other callers use the blob. Opening a writer of
+// an existing blob adds it to the lease as containerd content store does.
+func addLease(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	if _, ok := leases.FromContext(ctx); !ok {
+		return nil
+	}
+	writer, err := content.OpenWriter(ctx, cs, content.WithRef("lease-"+desc.Digest.String()), content.WithDescriptor(desc))
+	if err == nil {
+		writer.Close()
+		return errors.Errorf("converted blob %s isn't found", desc.Digest)
+	}
+	if !errdefs.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "add converted blob %s to lease", desc.Digest)
+	}
+	return nil
+}
+
+// Dedup wraps the layer convert func, the concurrent conversions of the
+// same source layer with the same config hash wait on a single in-flight
+// conversion, and share the converted blob in content store. Each caller
+// stops waiting once its own context is done, while the in-flight
+// conversion runs on a detached context. The shared blob is added to the
+// lease of each caller.
+func Dedup(configHash string, convertFunc converter.ConvertFunc) converter.ConvertFunc {
+	return func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		key := fmt.Sprintf("%s@%s", configHash, desc.Digest)
+		detached := detach(ctx)
+		resultChan := convertSingleflight.DoChan(key, func() (interface{}, error) {
+			return convertFunc(detached, cs, desc)
+		})
+		if waitHook != nil {
+			waitHook()
+		}
+
+		var result singleflight.Result
+		select {
+		case result = <-resultChan:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		newDesc, _ := result.Val.(*ocispec.Descriptor)
+		if newDesc == nil {
+			return nil, nil
+		}
+		if result.Shared {
+			logrus.Debugf("shared in-flight conversion of layer %s", desc.Digest)
+			if err := addLease(ctx, cs, *newDesc); err != nil {
+				return nil, err
+			}
+		}
+
+		// The descriptor may be modified by the caller, return a copy.
+		copied := *newDesc
+		if newDesc.Annotations != nil {
+			copied.Annotations = make(map[string]string, len(newDesc.Annotations))
+			for key, value := range newDesc.Annotations {
+				copied.Annotations[key] = value
+			}
+		}
+		return &copied, nil
+	}
+}
+
+// Cached wraps the layer convert func with the local cache in context, the
+// converted layer is reused if the source digest, driver, config hash and
+// builder version are all matched.
+func Cached(driver, configHash, builderVersion string, convertFunc converter.ConvertFunc) converter.ConvertFunc {
+	return func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		localCache := cache.GetLocalCache(ctx)
+		if localCache == nil {
+			return convertFunc(ctx, cs, desc)
+		}
+
+		key := cache.LayerKey{
+			Source:         desc.Digest,
+			Driver:         driver,
+			ConfigHash:     configHash,
+			BuilderVersion: builderVersion,
+		}
+		target, err := localCache.Get(ctx, key)
+		if err != nil {
+			logrus.WithError(err).Warnf("get local cache of layer %s", desc.Digest)
+		}
+		if target != nil {
+			logrus.Debugf("hit local cache of layer %s: %s", desc.Digest, target.Digest)
+			return target, nil
+		}
+
+		target, err = convertFunc(ctx, cs, desc)
+		if err != nil || target == nil {
+			return target, err
+		}
+		if err := localCache.Set(ctx, key, *target); err != nil {
+			logrus.WithError(err).Warnf("set local cache of layer %s", desc.Digest)
+		}
+
+		return target, nil
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package layer
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/leases"
+	"github.com/containerd/containerd/metadata"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestConfigHash(t *testing.T) {
+	hash := ConfigHash("nydus", map[string]string{"fs_version": "6", "compressor": "zstd"})
+	require.Equal(t, hash, ConfigHash("nydus", map[string]string{"compressor": "zstd", "fs_version": "6"}))
+	require.NotEqual(t, hash, ConfigHash("nydus", map[string]string{"compressor": "lz4_block", "fs_version": "6"}))
+	require.NotEqual(t, hash, ConfigHash("estargz", map[string]string{"compressor": "zstd", "fs_version": "6"}))
+}
+
+// joinedWaiter installs the wait hook, a value is sent to the returned
+// channel once a caller waits on the in-flight conversion.
+func joinedWaiter(t *testing.T) <-chan struct{} {
+	joined := make(chan struct{}, 16)
+	waitHook = func() {
+		joined <- struct{}{}
+	}
+	t.Cleanup(func() {
+		waitHook = nil
+	})
+	return joined
+}
+
+func TestDedup(t *testing.T) {
+	joined := joinedWaiter(t)
+	var calls int32
+	release := make(chan struct{})
+	convertFunc := func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &ocispec.Descriptor{
+			Digest:      digest.FromString("target"),
+			Annotations: map[string]string{"key": "value"},
+		}, nil
+	}
+
+	source := ocispec.Descriptor{Digest: digest.FromString("source")}
+	dedup := Dedup("hash", convertFunc)
+
+	var wg sync.WaitGroup
+	targets := make([]*ocispec.Descriptor, 3)
+	for idx := range targets {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			target, err := dedup(context.Background(), nil, source)
+			require.NoError(t, err)
+			targets[idx] = target
+		}(idx)
+	}
+	// Wait for all conversions to join the in-flight one.
+	for range targets {
+		<-joined
+	}
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, target := range targets {
+		require.Equal(t, digest.FromString("target"), target.Digest)
+	}
+	targets[0].Annotations["key"] = "modified"
+	require.Equal(t, "value", targets[1].Annotations["key"])
+
+	// A different config doesn't share the conversion.
+	_, err := Dedup("other", convertFunc)(context.Background(), nil, source)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestDedupCancel(t *testing.T) {
+	joined := joinedWaiter(t)
+	release := make(chan struct{})
+	convertFunc := func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		<-release
+		// The conversion isn't cancelled with the caller starting it.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &ocispec.Descriptor{Digest: digest.FromString("target")}, nil
+	}
+	source := ocispec.Descriptor{Digest: digest.FromString("source")}
+	dedup := Dedup("hash", convertFunc)
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := dedup(leaderCtx, nil, source)
+		leaderErr <- err
+	}()
+	<-joined
+	waiterTarget := make(chan *ocispec.Descriptor, 1)
+	go func() {
+		target, err := dedup(context.Background(), nil, source)
+		require.NoError(t, err)
+		waiterTarget <- target
+	}()
+	<-joined
+
+	cancel()
+	require.ErrorIs(t, <-leaderErr, context.Canceled)
+	close(release)
+	require.Equal(t, digest.FromString("target"), (<-waiterTarget).Digest)
+}
+
+func TestDedupLease(t *testing.T) {
+	joined := joinedWaiter(t)
+	dir := t.TempDir()
+	store, err := local.NewStore(filepath.Join(dir, "content"))
+	require.NoError(t, err)
+	bdb, err := bolt.Open(filepath.Join(dir, "meta.db"), 0600, nil)
+	require.NoError(t, err)
+	defer bdb.Close()
+	db := metadata.NewDB(bdb, store, nil)
+	require.NoError(t, db.Init(context.Background()))
+	cs := db.ContentStore()
+	lm := metadata.NewLeaseManager(db)
+
+	ctx := namespaces.WithNamespace(context.Background(), "acceleration-service")
+	newLeaseCtx := func() (context.Context, leases.Lease) {
+		lease, err := lm.Create(ctx, leases.WithRandomID())
+		require.NoError(t, err)
+		return leases.WithLease(ctx, lease.ID), lease
+	}
+
+	release := make(chan struct{})
+	convertFunc := func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		<-release
+		data := []byte("target")
+		target := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+		if err := content.WriteBlob(ctx, cs, "target", bytes.NewReader(data), target); err != nil {
+			return nil, err
+		}
+		return &target, nil
+	}
+	source := ocispec.Descriptor{Digest: digest.FromString("source")}
+	dedup := Dedup("hash", convertFunc)
+
+	leaderCtx, leaderLease := newLeaseCtx()
+	leaderTarget := make(chan *ocispec.Descriptor, 1)
+	go func() {
+		target, err := dedup(leaderCtx, cs, source)
+		require.NoError(t, err)
+		leaderTarget <- target
+	}()
+	<-joined
+	waiterCtx, _ := newLeaseCtx()
+	waiterTarget := make(chan *ocispec.Descriptor, 1)
+	go func() {
+		target, err := dedup(waiterCtx, cs, source)
+		require.NoError(t, err)
+		waiterTarget <- target
+	}()
+	<-joined
+	close(release)
+	<-leaderTarget
+	target := <-waiterTarget
+
+	// The blob is kept by the lease of waiter once the leader is done.
+	require.NoError(t, lm.Delete(ctx, leaderLease))
+	_, err = db.GarbageCollect(ctx)
+	require.NoError(t, err)
+	_, err = cs.Info(ctx, target.Digest)
+	require.NoError(t, err)
+}
@@ -37,6 +37,10 @@ type Metric struct {
 	ConversionElapsed time.Duration
 	// Elapsed time of converting source image to target image
 	TargetPushElapsed time.Duration
+	// Elapsed time of verifying target image
+	VerifyElapsed time.Duration
+	// Total size of the target blobs cross-repo mounted instead of uploaded in bytes
+	TargetMountedSize int64
 }
 
 func (metric *Metric) SetTargetImageSize(ctx context.Context, cvt *Converter, desc *ocispec.Descriptor) error {
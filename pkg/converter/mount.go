@@ -0,0 +1,104 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/remote"
+)
+
+type mountFromKey struct{}
+
+// WithMountFrom mounts the blobs left untouched by driver from the repository
+// of ref when pushing the target image, instead of uploading them. It's used
+// when the target registry differs from the source registry but already has
+// the source image, like a proxy cache project of Harbor.
+func WithMountFrom(ctx context.Context, ref string) context.Context {
+	if ref == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, mountFromKey{}, ref)
+}
+
+func mountFrom(ctx context.Context) string {
+	ref, _ := ctx.Value(mountFromKey{}).(string)
+	return ref
+}
+
+// blobs returns the digests of all blobs referenced by the image.
+func blobs(ctx context.Context, cs content.Store, image ocispec.Descriptor) (map[digest.Digest]bool, error) {
+	found := make(map[digest.Digest]bool)
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		found[desc.Digest] = true
+		return images.ChildrenHandler(cs)(ctx, desc)
+	})
+	if err := images.Walk(ctx, handler, image); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// labelMountSource labels the blobs shared by the source and target image with the
+// distribution source of mountRef, so that the pusher tries to mount them from the
+// repository of mountRef, and falls back to upload if they're not found there.
+func (cvt *Converter) labelMountSource(ctx context.Context, source ocispec.Descriptor, target ocispec.Descriptor, mountRef string) error {
+	cs := cvt.provider.ContentStore()
+	sourceBlobs, err := blobs(ctx, cs, source)
+	if err != nil {
+		return errors.Wrap(err, "walk source image")
+	}
+	targetBlobs, err := blobs(ctx, cs, target)
+	if err != nil {
+		return errors.Wrap(err, "walk target image")
+	}
+
+	for dgst := range targetBlobs {
+		if !sourceBlobs[dgst] {
+			continue
+		}
+		info, err := cs.Info(ctx, dgst)
+		if err != nil {
+			return errors.Wrapf(err, "get info of blob %s", dgst)
+		}
+		newLabels, err := remote.AppendDistributionSource(info.Labels, mountRef)
+		if err != nil {
+			return errors.Wrapf(err, "append distribution source %s", mountRef)
+		}
+		fieldpaths := []string{}
+		for key, value := range newLabels {
+			if strings.HasPrefix(key, labels.LabelDistributionSource) && info.Labels[key] != value {
+				fieldpaths = append(fieldpaths, "labels."+key)
+			}
+		}
+		if len(fieldpaths) == 0 {
+			continue
+		}
+		info.Labels = newLabels
+		if _, err := cs.Update(ctx, info, fieldpaths...); err != nil {
+			return errors.Wrapf(err, "update labels of blob %s", dgst)
+		}
+	}
+
+	return nil
+}
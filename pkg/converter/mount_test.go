@@ -0,0 +1,82 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/content"
+)
+
+func writeManifest(t *testing.T, ctx context.Context, cs ctrcontent.Store, imageConfig ocispec.Descriptor, layers ...ocispec.Descriptor) ocispec.Descriptor {
+	data, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    imageConfig,
+		Layers:    layers,
+	})
+	require.NoError(t, err)
+	return writeBlob(t, ctx, cs, ocispec.MediaTypeImageManifest, data)
+}
+
+func writeBlob(t *testing.T, ctx context.Context, cs ctrcontent.Store, mediaType string, data []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	require.NoError(t, ctrcontent.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(data), desc))
+	return desc
+}
+
+func TestLabelMountSource(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Provider.WorkDir = t.TempDir()
+	cfg.Provider.GCPolicy.Threshold = "1000MB"
+	provider, _, err := content.NewLocalProvider(cfg, platforms.All)
+	require.NoError(t, err)
+	cvt := &Converter{provider: provider}
+	ctx := namespaces.WithNamespace(context.Background(), "acceleration-service")
+	cs := provider.ContentStore()
+
+	untouched := writeBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte("untouched"))
+	sourceLayer := writeBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte("source"))
+	targetLayer := writeBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte("target"))
+	imageConfig := writeBlob(t, ctx, cs, ocispec.MediaTypeImageConfig, []byte("{}"))
+	source := writeManifest(t, ctx, cs, imageConfig, untouched, sourceLayer)
+	target := writeManifest(t, ctx, cs, imageConfig, untouched, targetLayer)
+
+	require.NoError(t, cvt.labelMountSource(ctx, source, target, "192.168.1.1/proxy/nginx:latest"))
+
+	label := "containerd.io/distribution.source.192.168.1.1"
+	for _, desc := range []ocispec.Descriptor{untouched, imageConfig} {
+		info, err := cs.Info(ctx, desc.Digest)
+		require.NoError(t, err)
+		require.Equal(t, "proxy/nginx", info.Labels[label])
+	}
+	for _, desc := range []ocispec.Descriptor{sourceLayer, targetLayer, target} {
+		info, err := cs.Info(ctx, desc.Digest)
+		require.NoError(t, err)
+		require.Empty(t, info.Labels[label])
+	}
+}
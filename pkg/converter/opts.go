@@ -25,6 +25,8 @@ type ConvertOpts struct {
 	driverConfig map[string]string
 	platformMC   platforms.MatchComparer
 	annotations  map[string]string
+	verify       bool
+	verifyFS     bool
 }
 
 type ConvertOpt func(opts *ConvertOpts) error
@@ -61,3 +63,14 @@ func WithAnnotation(annotations map[string]string) ConvertOpt {
 		return nil
 	}
 }
+
+// WithVerify enables verifying the converted image before pushing, the
+// filesystem tree of source and target layers are compared if compareFS
+// is specified.
+func WithVerify(compareFS bool) ConvertOpt {
+	return func(opts *ConvertOpts) error {
+		opts.verify = true
+		opts.verifyFS = compareFS
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+
+	ctrContent "github.com/containerd/containerd/content"
+	ctrErrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/cache"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+)
+
+// Plan is the result of a dry run conversion, it reports what the conversion
+// would do, nothing is converted or pushed.
+type Plan struct {
+	Source    string `json:"source"`
+	Target    string `json:"target,omitempty"`
+	CacheRef  string `json:"cache_ref,omitempty"`
+	MountFrom string `json:"mount_from,omitempty"`
+	// Skipped is the reason if the source won't be converted, like it
+	// has been converted.
+	Skipped string `json:"skipped,omitempty"`
+
+	Digest digest.Digest `json:"digest,omitempty"`
+	// PullError is the error of resolving the source with pull credential.
+	PullError string `json:"pull_error,omitempty"`
+	// CacheError is the error of fetching the remote cache, a missing cache
+	// isn't an error.
+	CacheError string `json:"cache_error,omitempty"`
+	// PushError is the error of checking the push credential of target.
+	PushError string         `json:"push_error,omitempty"`
+	Platforms []PlatformPlan `json:"platforms,omitempty"`
+}
+
+// PlatformPlan reports a platform of source image, only the matched
+// platforms are converted.
+type PlatformPlan struct {
+	Platform string        `json:"platform"`
+	Digest   digest.Digest `json:"digest"`
+	Matched  bool          `json:"matched"`
+	Layers   int           `json:"layers"`
+	// CachedLayers is the number of layers hit by the remote cache,
+	// only counted for the matched platforms.
+	CachedLayers int `json:"cached_layers"`
+}
+
+// Plan resolves the source image and the remote cache as Convert does, and
+// reports the platforms and cache hits of conversion, without pulling the
+// layers, converting or pushing anything.
+func (cvt *Converter) Plan(ctx context.Context, source, target, cacheRef string) (*Plan, error) {
+	sourceNamed, err := docker.ParseDockerRef(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse source reference")
+	}
+	targetNamed, err := docker.ParseDockerRef(target)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse target reference")
+	}
+	plan := Plan{
+		Source:   sourceNamed.String(),
+		Target:   targetNamed.String(),
+		CacheRef: cacheRef,
+	}
+	source = plan.Source
+
+	ctx, cache := cvt.provider.NewRemoteCache(ctx, cacheRef)
+	if cache != nil {
+		cache.SetDriver(cvt.driver.Name(), cvt.driver.Version())
+		cache.SetSource(source)
+		_, err := cache.Fetch(ctx, cvt.platformMC)
+		if errdefs.NeedsRetryWithHTTP(err) {
+			if err = cvt.provider.UsePlainHTTP(cacheRef); err == nil {
+				_, err = cache.Fetch(ctx, cvt.platformMC)
+			}
+		}
+		if err != nil && !errors.Is(err, ctrErrdefs.ErrNotFound) {
+			plan.CacheError = err.Error()
+		}
+	}
+
+	desc, err := cvt.provider.Resolve(ctx, source)
+	if errdefs.NeedsRetryWithHTTP(err) {
+		if err = cvt.provider.UsePlainHTTP(source); err == nil {
+			desc, err = cvt.provider.Resolve(ctx, source)
+		}
+	}
+	if err != nil {
+		plan.PullError = err.Error()
+		return &plan, nil
+	}
+	plan.Digest = desc.Digest
+
+	manifests, err := platformManifests(ctx, cvt.provider.ContentStore(), *desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "get manifests of source image")
+	}
+	for _, manifest := range manifests {
+		platformPlan, err := cvt.planPlatform(ctx, manifest, cache)
+		if err != nil {
+			return nil, errors.Wrapf(err, "plan manifest %s", manifest.Digest)
+		}
+		plan.Platforms = append(plan.Platforms, *platformPlan)
+	}
+
+	return &plan, nil
+}
+
+func (cvt *Converter) planPlatform(ctx context.Context, desc ocispec.Descriptor, cache *cache.RemoteCache) (*PlatformPlan, error) {
+	var manifest ocispec.Manifest
+	if _, err := utils.ReadJSON(ctx, cvt.provider.ContentStore(), &manifest, desc); err != nil {
+		return nil, errors.Wrap(err, "read manifest")
+	}
+	platformPlan := PlatformPlan{
+		Platform: platforms.Format(*desc.Platform),
+		Digest:   desc.Digest,
+		Matched:  cvt.platformMC.Match(*desc.Platform),
+		Layers:   len(manifest.Layers),
+	}
+	if cache != nil && platformPlan.Matched {
+		cached, _, err := cache.HitCount(ctx, desc, cvt.platformMC)
+		if err != nil {
+			return nil, errors.Wrap(err, "get cache hit count")
+		}
+		platformPlan.CachedLayers = int(cached)
+	}
+	return &platformPlan, nil
+}
+
+// platformManifests returns the manifests of all platforms in image, the
+// platform of descriptor is read from image config if unspecified.
+func platformManifests(ctx context.Context, cs ctrContent.Store, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	switch {
+	case images.IsIndexType(desc.MediaType):
+		var index ocispec.Index
+		if _, err := utils.ReadJSON(ctx, cs, &index, desc); err != nil {
+			return nil, errors.Wrap(err, "read index")
+		}
+		manifests := []ocispec.Descriptor{}
+		for _, child := range index.Manifests {
+			childManifests, err := platformManifests(ctx, cs, child)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, childManifests...)
+		}
+		return manifests, nil
+	case images.IsManifestType(desc.MediaType):
+		if desc.Platform == nil {
+			var manifest ocispec.Manifest
+			if _, err := utils.ReadJSON(ctx, cs, &manifest, desc); err != nil {
+				return nil, errors.Wrap(err, "read manifest")
+			}
+			var config ocispec.Image
+			if _, err := utils.ReadJSON(ctx, cs, &config, manifest.Config); err != nil {
+				return nil, errors.Wrap(err, "read image config")
+			}
+			desc.Platform = &ocispec.Platform{
+				OS:           config.OS,
+				Architecture: config.Architecture,
+				Variant:      config.Variant,
+			}
+		}
+		return []ocispec.Descriptor{desc}, nil
+	}
+	// Skip the unknown types like attestation.
+	return nil, nil
+}
@@ -0,0 +1,89 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/content"
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+func newPlanTestProvider(t *testing.T, host string, platformMC platforms.MatchComparer) content.Provider {
+	cfg := &config.Config{}
+	cfg.Provider.Source = map[string]config.SourceConfig{host: {PlainHTTP: true}}
+	cfg.Provider.WorkDir = t.TempDir()
+	cfg.Provider.GCPolicy.Threshold = "1000MB"
+	provider, _, err := content.NewLocalProvider(cfg, platformMC)
+	require.NoError(t, err)
+	return provider
+}
+
+func TestPlan(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	ctx := namespaces.WithNamespace(context.Background(), "acceleration-service")
+	source := registry.Host() + "/library/nginx:latest"
+
+	// Push an image index of amd64 and arm64.
+	pusher := newPlanTestProvider(t, registry.Host(), platforms.All)
+	cs := pusher.ContentStore()
+	amd64Layer := writeBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte("amd64"))
+	arm64Layer := writeBlob(t, ctx, cs, ocispec.MediaTypeImageLayerGzip, []byte("arm64"))
+	amd64 := writeManifest(t, ctx, cs, writeBlob(t, ctx, cs, ocispec.MediaTypeImageConfig, []byte(`{"os":"linux","architecture":"amd64"}`)), amd64Layer)
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := writeManifest(t, ctx, cs, writeBlob(t, ctx, cs, ocispec.MediaTypeImageConfig, []byte(`{"os":"linux","architecture":"arm64"}`)), arm64Layer, arm64Layer)
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	indexBytes, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{amd64, arm64},
+	})
+	require.NoError(t, err)
+	index := writeBlob(t, ctx, cs, ocispec.MediaTypeImageIndex, indexBytes)
+	require.NoError(t, pusher.Push(ctx, index, source))
+
+	platformMC := platforms.Ordered(platforms.MustParse("linux/amd64"))
+	provider := newPlanTestProvider(t, registry.Host(), platformMC)
+	cvt := &Converter{provider: provider, platformMC: platformMC}
+
+	plan, err := cvt.Plan(ctx, source, source+"-nydus", "")
+	require.NoError(t, err)
+	require.Equal(t, source+"-nydus", plan.Target)
+	require.Equal(t, index.Digest, plan.Digest)
+	require.Empty(t, plan.PullError)
+	require.Equal(t, []PlatformPlan{
+		{Platform: "linux/amd64", Digest: amd64.Digest, Matched: true, Layers: 1},
+		{Platform: "linux/arm64", Digest: arm64.Digest, Matched: false, Layers: 2},
+	}, plan.Platforms)
+
+	// The layers aren't pulled.
+	_, err = provider.ContentStore().Info(ctx, amd64Layer.Digest)
+	require.True(t, errdefs.IsNotFound(err))
+
+	plan, err = cvt.Plan(ctx, registry.Host()+"/library/notfound:latest", source+"-nydus", "")
+	require.NoError(t, err)
+	require.NotEmpty(t, plan.PullError)
+}
@@ -0,0 +1,310 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/goharbor/acceleration-service/pkg/driver"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+)
+
+const (
+	CheckManifest   = "manifest"
+	CheckLayerCount = "layer_count"
+	CheckDiffID     = "diff_id"
+	CheckLayer      = "layer"
+	CheckFilesystem = "filesystem"
+)
+
+// VerifyError describes which check the converted image failed.
+type VerifyError struct {
+	// Manifest is the digest of the converted image manifest.
+	Manifest digest.Digest
+	// Check is the name of the failed check.
+	Check string
+	// Message gives the details of the failure.
+	Message string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s: check %s of manifest %s: %s", errdefs.ErrVerifyFailed, e.Check, e.Manifest, e.Message)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return errdefs.ErrVerifyFailed
+}
+
+// verifyImage checks the converted image before pushing, it returns
+// a *VerifyError if any check fails.
+func (cvt *Converter) verifyImage(ctx context.Context, source, target ocispec.Descriptor) error {
+	cs := cvt.provider.ContentStore()
+
+	sourceDescs, err := utils.GetManifests(ctx, cs, source, cvt.platformMC)
+	if err != nil {
+		return errors.Wrap(err, "get source manifests")
+	}
+	targetDescs, err := utils.GetManifests(ctx, cs, target, cvt.platformMC)
+	if err != nil {
+		return errors.Wrap(err, "get target manifests")
+	}
+
+	for _, targetDesc := range targetDescs {
+		if isOriginalManifest(targetDesc, sourceDescs) {
+			// The original manifest may be kept in target image (for
+			// example, nydus `merge_manifest` option), skip it.
+			continue
+		}
+		sourceDesc, err := matchManifest(ctx, cs, targetDesc, sourceDescs)
+		if err != nil {
+			return &VerifyError{Manifest: targetDesc.Digest, Check: CheckManifest, Message: err.Error()}
+		}
+		if err := cvt.verifyManifest(ctx, cs, *sourceDesc, targetDesc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cvt *Converter) verifyManifest(ctx context.Context, cs content.Store, sourceDesc, targetDesc ocispec.Descriptor) error {
+	fail := func(check string, err error) error {
+		return &VerifyError{Manifest: targetDesc.Digest, Check: check, Message: err.Error()}
+	}
+
+	var source, target ocispec.Manifest
+	if _, err := utils.ReadJSON(ctx, cs, &source, sourceDesc); err != nil {
+		return errors.Wrap(err, "read source manifest")
+	}
+	if _, err := utils.ReadJSON(ctx, cs, &target, targetDesc); err != nil {
+		return fail(CheckManifest, errors.Wrap(err, "read manifest"))
+	}
+
+	verifier, _ := cvt.driver.(driver.Verifier)
+	var sourceLayers []ocispec.Descriptor
+	if verifier != nil {
+		layers, extra, err := verifier.ConvertedLayers(ctx, cs, source)
+		if err != nil {
+			return errors.Wrap(err, "get converted source layers")
+		}
+		if layers != nil {
+			if expected := len(layers) + extra; expected != len(target.Layers) {
+				return fail(CheckLayerCount, fmt.Errorf("expected %d layers, got %d", expected, len(target.Layers)))
+			}
+		}
+		sourceLayers = layers
+	}
+
+	if err := verifyDiffIDs(ctx, cs, target); err != nil {
+		return fail(CheckDiffID, err)
+	}
+
+	if verifier == nil {
+		return nil
+	}
+
+	if err := verifier.VerifyLayers(ctx, cs, target); err != nil {
+		return fail(CheckLayer, err)
+	}
+
+	if cvt.verifyFS && sourceLayers != nil {
+		if err := compareLayers(ctx, cs, verifier, sourceLayers, target.Layers); err != nil {
+			return fail(CheckFilesystem, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyDiffIDs checks the diff ids in image config match the layers of manifest.
+func verifyDiffIDs(ctx context.Context, cs content.Store, manifest ocispec.Manifest) error {
+	diffIDs, err := images.RootFS(ctx, cs, manifest.Config)
+	if err != nil {
+		return errors.Wrap(err, "get diff ids from config")
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("unmatched layers between manifest and config: %d != %d", len(manifest.Layers), len(diffIDs))
+	}
+	for idx, layer := range manifest.Layers {
+		diffID := digest.Digest(layer.Annotations[labels.LabelUncompressed])
+		if diffID == "" {
+			if diffID, err = images.GetDiffID(ctx, cs, layer); err != nil {
+				return errors.Wrapf(err, "get diff id of layer %s", layer.Digest)
+			}
+		}
+		if diffID != diffIDs[idx] {
+			return fmt.Errorf("unmatched diff id of layer %s: %s != %s", layer.Digest, diffID, diffIDs[idx])
+		}
+	}
+	return nil
+}
+
+type fileEntry struct {
+	typeflag byte
+	size     int64
+	linkname string
+}
+
+// compareLayers compares the filesystem tree of each converted source layer
+// with the target layer in the same position, the layers which can't be
+// unpacked standalone by driver are skipped.
+func compareLayers(ctx context.Context, cs content.Store, verifier driver.Verifier, sourceLayers, targetLayers []ocispec.Descriptor) error {
+	for idx, sourceLayer := range sourceLayers {
+		targetLayer := targetLayers[idx]
+		tr, err := verifier.UnpackLayer(ctx, cs, targetLayer)
+		if err != nil {
+			return errors.Wrapf(err, "unpack layer %s", targetLayer.Digest)
+		}
+		if tr == nil {
+			continue
+		}
+		targetTree, err := readTree(tr)
+		tr.Close()
+		if err != nil {
+			return errors.Wrapf(err, "read filesystem tree of layer %s", targetLayer.Digest)
+		}
+
+		sourceTree, err := readLayerTree(ctx, cs, sourceLayer)
+		if err != nil {
+			return errors.Wrapf(err, "read filesystem tree of layer %s", sourceLayer.Digest)
+		}
+
+		if err := compareTree(sourceTree, targetTree); err != nil {
+			return errors.Wrapf(err, "compare layer %s with %s", sourceLayer.Digest, targetLayer.Digest)
+		}
+	}
+	return nil
+}
+
+func readLayerTree(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (map[string]fileEntry, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer ra.Close()
+	rdr, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return readTree(rdr)
+}
+
+// readTree collects the non-directory entries in a tar stream,
+// directories are ignored since the parents may be implicitly
+// created by different formats.
+func readTree(reader io.Reader) (map[string]fileEntry, error) {
+	tree := map[string]fileEntry{}
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		entry := fileEntry{
+			typeflag: hdr.Typeflag,
+			linkname: hdr.Linkname,
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			entry.size = hdr.Size
+		}
+		tree[path.Clean("/"+hdr.Name)] = entry
+	}
+	return tree, nil
+}
+
+func compareTree(source, target map[string]fileEntry) error {
+	for name, sourceEntry := range source {
+		targetEntry, ok := target[name]
+		if !ok {
+			return fmt.Errorf("file %s is missing", name)
+		}
+		if sourceEntry != targetEntry {
+			return fmt.Errorf("file %s is mismatched", name)
+		}
+	}
+	for name := range target {
+		if _, ok := source[name]; !ok {
+			return fmt.Errorf("unexpected file %s", name)
+		}
+	}
+	return nil
+}
+
+func isOriginalManifest(desc ocispec.Descriptor, sources []ocispec.Descriptor) bool {
+	for _, source := range sources {
+		if source.Digest == desc.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+// matchManifest finds the source manifest with the same platform as target manifest.
+func matchManifest(ctx context.Context, cs content.Store, target ocispec.Descriptor, sources []ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	if len(sources) == 1 && images.IsManifestType(target.MediaType) && target.Platform == nil {
+		return &sources[0], nil
+	}
+	platform, err := manifestPlatform(ctx, cs, target)
+	if err != nil {
+		return nil, err
+	}
+	matcher := platforms.NewMatcher(*platform)
+	for idx := range sources {
+		sourcePlatform, err := manifestPlatform(ctx, cs, sources[idx])
+		if err != nil {
+			return nil, err
+		}
+		if matcher.Match(*sourcePlatform) {
+			return &sources[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("no source manifest matches platform %s", platforms.Format(*platform))
+}
+
+func manifestPlatform(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Platform, error) {
+	if desc.Platform != nil {
+		return desc.Platform, nil
+	}
+	// platform of manifest maybe lost, get from config platform
+	platforms, err := images.Platforms(ctx, cs, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "get manifest platform")
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platform found for manifest %s", desc.Digest)
+	}
+	return &platforms[0], nil
+}
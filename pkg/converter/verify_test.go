@@ -0,0 +1,217 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	ctrcontent "github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/content"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+)
+
+func makeTar(t *testing.T, hdrs ...*tar.Header) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, hdr := range hdrs {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write(make([]byte, hdr.Size))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf
+}
+
+func TestCompareTree(t *testing.T) {
+	source, err := readTree(makeTar(t,
+		&tar.Header{Name: "etc/", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Size: 3},
+		&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+	))
+	require.NoError(t, err)
+
+	// Directories are ignored.
+	target, err := readTree(makeTar(t,
+		&tar.Header{Name: "./etc/hosts", Typeflag: tar.TypeReg, Size: 3},
+		&tar.Header{Name: "./bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+	))
+	require.NoError(t, err)
+	require.NoError(t, compareTree(source, target))
+
+	target, err = readTree(makeTar(t,
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Size: 4},
+		&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+	))
+	require.NoError(t, err)
+	require.EqualError(t, compareTree(source, target), "file /etc/hosts is mismatched")
+
+	target, err = readTree(makeTar(t,
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Size: 3},
+	))
+	require.NoError(t, err)
+	require.EqualError(t, compareTree(source, target), "file /bin/sh is missing")
+
+	target, err = readTree(makeTar(t,
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Size: 3},
+		&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+		&tar.Header{Name: "tmp/foo", Typeflag: tar.TypeReg},
+	))
+	require.NoError(t, err)
+	require.EqualError(t, compareTree(source, target), "unexpected file /tmp/foo")
+}
+
+func TestVerifyError(t *testing.T) {
+	err := &VerifyError{
+		Manifest: "sha256:6cdd1b26d54d5852fbea95a81cbb25383975b70b4ffad9f9b6d25c7a434a51eb",
+		Check:    CheckLayerCount,
+		Message:  "expected 3 layers, got 2",
+	}
+	require.True(t, errors.Is(err, errdefs.ErrVerifyFailed))
+	require.Equal(t, "ERR_VERIFY_FAILED: check layer_count of manifest sha256:6cdd1b26d54d5852fbea95a81cbb25383975b70b4ffad9f9b6d25c7a434a51eb: expected 3 layers, got 2", err.Error())
+}
+
+type verifyTestProvider struct {
+	content.Provider
+	store ctrcontent.Store
+}
+
+func (pvd *verifyTestProvider) ContentStore() ctrcontent.Store {
+	return pvd.store
+}
+
+// verifyTestDriver keeps the source layers except the dropped ones, and
+// appends an extra layer.
+type verifyTestDriver struct {
+	dropped map[digest.Digest]bool
+}
+
+func (d *verifyTestDriver) Convert(context.Context, content.Provider, string) (*ocispec.Descriptor, error) {
+	return nil, nil
+}
+
+func (d *verifyTestDriver) Name() string {
+	return "test"
+}
+
+func (d *verifyTestDriver) Version() string {
+	return ""
+}
+
+func (d *verifyTestDriver) ConvertedLayers(_ context.Context, _ ctrcontent.Store, source ocispec.Manifest) ([]ocispec.Descriptor, int, error) {
+	layers := []ocispec.Descriptor{}
+	for _, layer := range source.Layers {
+		if !d.dropped[layer.Digest] {
+			layers = append(layers, layer)
+		}
+	}
+	return layers, 1, nil
+}
+
+func (d *verifyTestDriver) VerifyLayers(context.Context, ctrcontent.Store, ocispec.Manifest) error {
+	return nil
+}
+
+func (d *verifyTestDriver) UnpackLayer(context.Context, ctrcontent.Store, ocispec.Descriptor) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func writeTestBlob(t *testing.T, store ctrcontent.Store, mediaType string, data []byte) ocispec.Descriptor {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	require.NoError(t, ctrcontent.WriteBlob(context.Background(), store, desc.Digest.String(), bytes.NewReader(data), desc))
+	return desc
+}
+
+// writeTestImage writes the manifest of layers, the diff ids in config are
+// the digests of layers (uncompressed) unless diffIDs is given.
+func writeTestImage(t *testing.T, store ctrcontent.Store, layers []ocispec.Descriptor, diffIDs ...digest.Digest) ocispec.Descriptor {
+	if diffIDs == nil {
+		for _, layer := range layers {
+			diffIDs = append(diffIDs, layer.Digest)
+		}
+	}
+	config, err := json.Marshal(ocispec.Image{
+		Platform: platforms.DefaultSpec(),
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: diffIDs},
+	})
+	require.NoError(t, err)
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    writeTestBlob(t, store, ocispec.MediaTypeImageConfig, config),
+		Layers:    layers,
+	})
+	require.NoError(t, err)
+	return writeTestBlob(t, store, ocispec.MediaTypeImageManifest, manifest)
+}
+
+func TestVerifyImage(t *testing.T) {
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	layer := func(name string) ocispec.Descriptor {
+		return writeTestBlob(t, store, ocispec.MediaTypeImageLayer, makeTar(t,
+			&tar.Header{Name: name, Typeflag: tar.TypeReg, Size: 1},
+		).Bytes())
+	}
+	data, empty, extra := layer("data"), layer("empty"), layer("extra")
+	source := writeTestImage(t, store, []ocispec.Descriptor{data, empty})
+
+	cvt := &Converter{
+		provider:   &verifyTestProvider{store: store},
+		driver:     &verifyTestDriver{dropped: map[digest.Digest]bool{empty.Digest: true}},
+		platformMC: platforms.All,
+	}
+	requireCheck := func(check string, err error) {
+		var verifyErr *VerifyError
+		require.ErrorAs(t, err, &verifyErr)
+		require.Equal(t, check, verifyErr.Check)
+	}
+
+	// The dropped layer isn't counted.
+	target := writeTestImage(t, store, []ocispec.Descriptor{data, extra})
+	require.NoError(t, cvt.verifyImage(ctx, source, target))
+
+	// The original manifest kept in target is skipped.
+	require.NoError(t, cvt.verifyImage(ctx, source, source))
+
+	target = writeTestImage(t, store, []ocispec.Descriptor{data, empty, extra})
+	requireCheck(CheckLayerCount, cvt.verifyImage(ctx, source, target))
+
+	target = writeTestImage(t, store, []ocispec.Descriptor{data, extra}, data.Digest, empty.Digest)
+	requireCheck(CheckDiffID, cvt.verifyImage(ctx, source, target))
+
+	target = writeTestImage(t, store, []ocispec.Descriptor{data, extra}, data.Digest)
+	requireCheck(CheckDiffID, cvt.verifyImage(ctx, source, target))
+}
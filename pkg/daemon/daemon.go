@@ -33,6 +33,7 @@ func NewDaemon(cfg *config.Config) (*Daemon, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "create api handler")
 	}
+	handler.ResumeBackfills()
 
 	router := router.NewLocalRouter(handler)
 	srv, err := server.NewHTTPServer(&cfg.Server, &cfg.Metric, router)
@@ -17,7 +17,9 @@ package driver
 import (
 	"context"
 	"fmt"
+	"io"
 
+	ctrcontent "github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/platforms"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
@@ -46,6 +48,36 @@ type Driver interface {
 	Version() string
 }
 
+// Verifier is an optional interface implemented by drivers to check the
+// format-specific parts of a converted image before it's pushed.
+type Verifier interface {
+	// ConvertedLayers returns the source layers converted to the leading
+	// layers of the converted manifest in order, excluding the layers dropped
+	// by driver, and the number of extra layers following them. The layer
+	// count check and filesystem comparison are skipped if layers is nil.
+	ConvertedLayers(ctx context.Context, cs ctrcontent.Store, source ocispec.Manifest) (layers []ocispec.Descriptor, extra int, err error)
+
+	// VerifyLayers checks that the format-specific metadata of the converted
+	// manifest is present and parseable, such as nydus bootstrap or estargz TOC.
+	VerifyLayers(ctx context.Context, cs ctrcontent.Store, target ocispec.Manifest) error
+
+	// UnpackLayer returns the uncompressed OCI tar stream of a converted layer,
+	// it's used to compare the filesystem tree between source and target layers.
+	// A nil reader is returned if the layer can't be unpacked standalone.
+	UnpackLayer(ctx context.Context, cs ctrcontent.Store, desc ocispec.Descriptor) (io.ReadCloser, error)
+}
+
+type ChunkDictBuilder interface {
+	// BuildChunkDict generates a chunk dict image from the specified
+	// converted images which have been pulled into content store,
+	// and returns the manifest of chunk dict image.
+	BuildChunkDict(ctx context.Context, content content.Provider, images []string) (*ocispec.Descriptor, error)
+
+	// SetChunkDictRef updates the chunk dict image reference used by
+	// the subsequent conversions.
+	SetChunkDictRef(ref string)
+}
+
 func NewLocalDriver(typ string, config map[string]string, platformMC platforms.MatchComparer) (Driver, error) {
 	switch typ {
 	case "nydus":
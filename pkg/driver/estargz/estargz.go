@@ -18,11 +18,15 @@ import (
 	"context"
 	"strconv"
 
+	ctrcontent "github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images/converter"
 	"github.com/containerd/containerd/platforms"
 	"github.com/containerd/stargz-snapshotter/estargz"
 	estargzconvert "github.com/containerd/stargz-snapshotter/nativeconverter/estargz"
+	"github.com/goharbor/acceleration-service/pkg/cache"
 	"github.com/goharbor/acceleration-service/pkg/content"
+	"github.com/goharbor/acceleration-service/pkg/converter/layer"
+	"github.com/goharbor/acceleration-service/pkg/utils"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
@@ -45,16 +49,37 @@ func (d *Driver) Convert(ctx context.Context, p content.Provider, ref string) (*
 	if err != nil {
 		return nil, errors.Wrap(err, "get source image")
 	}
-	return converter.DefaultIndexConvertFunc(estargzconvert.LayerConvertFunc(opts...), docker2oci, d.platformMC)(
+	configHash := layer.ConfigHash(d.Name(), d.cfg)
+	layerConvertFunc := remoteCached(layer.Dedup(configHash, layer.Cached(
+		d.Name(), configHash, d.Version(), estargzconvert.LayerConvertFunc(opts...),
+	)))
+	return converter.DefaultIndexConvertFunc(layerConvertFunc, docker2oci, d.platformMC)(
 		ctx, p.ContentStore(), *image)
 }
 
+// remoteCached consults the remote cache before converting a layer, and
+// records the converted layer into the remote cache for next conversion.
+func remoteCached(convertFunc converter.ConvertFunc) converter.ConvertFunc {
+	return func(ctx context.Context, cs ctrcontent.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
+		if target := cache.GetTarget(ctx, desc.Digest); target != nil {
+			return target, nil
+		}
+		target, err := convertFunc(ctx, cs, desc)
+		if err == nil && target != nil {
+			cache.Set(ctx, desc, *target)
+		}
+		return target, err
+	}
+}
+
 func (d *Driver) Name() string {
 	return "estargz"
 }
 
+// Version returns the version of estargz converter, the layers converted by
+// a different version aren't reused from the remote cache.
 func (d *Driver) Version() string {
-	return ""
+	return utils.ModuleVersion("github.com/containerd/stargz-snapshotter/estargz")
 }
 
 func getESGZConvertOpts(cfg map[string]string) (opts []estargz.Option, docker2oci bool, err error) {
@@ -0,0 +1,118 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package estargz
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/stargz-snapshotter/estargz"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+func (d *Driver) ConvertedLayers(_ context.Context, _ content.Store, source ocispec.Manifest) ([]ocispec.Descriptor, int, error) {
+	return source.Layers, 0, nil
+}
+
+func (d *Driver) VerifyLayers(ctx context.Context, cs content.Store, target ocispec.Manifest) error {
+	for _, desc := range target.Layers {
+		if err := verifyTOC(ctx, cs, desc); err != nil {
+			return errors.Wrapf(err, "verify layer %s", desc.Digest)
+		}
+	}
+	return nil
+}
+
+// verifyTOC checks the TOC of estargz layer can be parsed, and matches
+// the TOC digest recorded in layer annotation.
+func verifyTOC(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrap(err, "get layer reader")
+	}
+	defer ra.Close()
+
+	reader, err := estargz.Open(io.NewSectionReader(ra, 0, desc.Size))
+	if err != nil {
+		return errors.Wrap(err, "open estargz TOC")
+	}
+
+	tocDigest, ok := desc.Annotations[estargz.TOCJSONDigestAnnotation]
+	if !ok {
+		return errors.New("missing TOC digest annotation")
+	}
+	dgst, err := digest.Parse(tocDigest)
+	if err != nil {
+		return errors.Wrap(err, "invalid TOC digest annotation")
+	}
+	if _, err := reader.VerifyTOC(dgst); err != nil {
+		return errors.Wrap(err, "unmatched TOC digest")
+	}
+
+	return nil
+}
+
+func (d *Driver) UnpackLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "get layer reader")
+	}
+	rdr, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		ra.Close()
+		return nil, errors.Wrap(err, "decompress layer")
+	}
+
+	// Strip the estargz specific entries, so that the stream is
+	// comparable with the tar stream of source layer.
+	pr, pw := io.Pipe()
+	go func() {
+		defer ra.Close()
+		defer rdr.Close()
+
+		tr := tar.NewReader(rdr)
+		tw := tar.NewWriter(pw)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			switch hdr.Name {
+			case estargz.TOCTarName, estargz.PrefetchLandmark, estargz.NoPrefetchLandmark:
+				continue
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+
+	return pr, nil
+}
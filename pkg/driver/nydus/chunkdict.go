@@ -0,0 +1,292 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	ctrErrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	accelcontent "github.com/goharbor/acceleration-service/pkg/content"
+	"github.com/goharbor/acceleration-service/pkg/driver/nydus/parser"
+	nydusutils "github.com/goharbor/acceleration-service/pkg/driver/nydus/utils"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/metrics"
+	"github.com/goharbor/acceleration-service/pkg/remote"
+)
+
+const chunkDictBootstrapSuffix = ".boot"
+
+type chunkDictInfo struct {
+	BootstrapPath string
+	// release must be called once the bootstrap isn't used by conversion.
+	release func()
+}
+
+// chunkDictCache caches the unpacked bootstrap of chunk dict image on disk,
+// it's keyed by the manifest digest of chunk dict image, so that the
+// bootstrap is only re-pulled when the remote tag moves.
+type chunkDictCache struct {
+	// mutex protects the fields below.
+	mutex sync.Mutex
+	// dir is the directory to store the unpacked bootstraps.
+	dir string
+	// current is the manifest digest of latest chunk dict image.
+	current digest.Digest
+	// refs records the count of conversions using the bootstrap of each digest,
+	// the bootstrap can be removed only if it's stale and not in use.
+	refs map[digest.Digest]int
+}
+
+func newChunkDictCache(workDir string) *chunkDictCache {
+	return &chunkDictCache{
+		dir:  filepath.Join(workDir, "nydus-chunk-dict"),
+		refs: make(map[digest.Digest]int),
+	}
+}
+
+func (cache *chunkDictCache) bootstrapPath(dgst digest.Digest) string {
+	return filepath.Join(cache.dir, dgst.Encoded()+chunkDictBootstrapSuffix)
+}
+
+// acquire returns the cached bootstrap of specified digest,
+// it returns nil if the bootstrap isn't cached on disk.
+func (cache *chunkDictCache) acquire(dgst digest.Digest) *chunkDictInfo {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	bootstrapPath := cache.bootstrapPath(dgst)
+	if _, err := os.Stat(bootstrapPath); err != nil {
+		return nil
+	}
+	cache.current = dgst
+
+	return cache.ref(dgst)
+}
+
+// ref must be called with mutex held.
+func (cache *chunkDictCache) ref(dgst digest.Digest) *chunkDictInfo {
+	cache.refs[dgst]++
+	var once sync.Once
+	return &chunkDictInfo{
+		BootstrapPath: cache.bootstrapPath(dgst),
+		release: func() {
+			once.Do(func() {
+				cache.mutex.Lock()
+				defer cache.mutex.Unlock()
+				cache.refs[dgst]--
+				if cache.refs[dgst] <= 0 {
+					delete(cache.refs, dgst)
+				}
+				cache.clean()
+			})
+		},
+	}
+}
+
+// store moves the unpacked bootstrap file into cache directory
+// as the latest chunk dict, and cleans up the stale ones.
+func (cache *chunkDictCache) store(dgst digest.Digest, file string) (*chunkDictInfo, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if err := os.Rename(file, cache.bootstrapPath(dgst)); err != nil {
+		return nil, errors.Wrap(err, "move chunk dict bootstrap")
+	}
+	cache.current = dgst
+	info := cache.ref(dgst)
+	cache.clean()
+
+	return info, nil
+}
+
+// clean removes the stale bootstraps that are not in use, it must be
+// called with mutex held.
+func (cache *chunkDictCache) clean() {
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		logrus.WithError(err).Warnf("read chunk dict directory %s", cache.dir)
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		dgst := digest.NewDigestFromEncoded(digest.SHA256, strings.TrimSuffix(name, chunkDictBootstrapSuffix))
+		if !strings.HasSuffix(name, chunkDictBootstrapSuffix) || dgst == cache.current || cache.refs[dgst] > 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cache.dir, name)); err != nil {
+			logrus.WithError(err).Warnf("remove stale chunk dict bootstrap %s", name)
+			continue
+		}
+		logrus.Infof("removed stale chunk dict bootstrap %s", dgst)
+	}
+}
+
+func (d *Driver) getChunkDictRef() string {
+	d.chunkDictMutex.RLock()
+	defer d.chunkDictMutex.RUnlock()
+	return d.chunkDictRef
+}
+
+// SetChunkDictRef updates the chunk dict image reference used by
+// the subsequent conversions, the bootstrap of new chunk dict image
+// will be pulled on next conversion.
+func (d *Driver) SetChunkDictRef(ref string) {
+	d.chunkDictMutex.Lock()
+	defer d.chunkDictMutex.Unlock()
+	if d.chunkDictRef != ref {
+		d.chunkDictRef = ref
+		// Don't fall back to the bootstrap of previous chunk dict image.
+		d.chunkDictCache.mutex.Lock()
+		d.chunkDictCache.current = ""
+		d.chunkDictCache.mutex.Unlock()
+	}
+}
+
+// resolveChunkDict resolves the manifest digest of chunk dict image.
+func (d *Driver) resolveChunkDict(ctx context.Context, provider accelcontent.Provider, ref string) (digest.Digest, error) {
+	resolve := func() (digest.Digest, error) {
+		resolver, err := provider.Resolver(ref, remote.OperationPull)
+		if err != nil {
+			return "", err
+		}
+		_, desc, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		return desc.Digest, nil
+	}
+
+	dgst, err := resolve()
+	if errdefs.NeedsRetryWithHTTP(err) {
+		logrus.Infof("try to resolve chunk dict image with plain HTTP for %s", ref)
+		if err = provider.UsePlainHTTP(ref); err == nil {
+			dgst, err = resolve()
+		}
+	}
+	return dgst, err
+}
+
+// getChunkDict gets the bootstrap of chunk dict image, the bootstrap is
+// cached on disk and only refreshed if the chunk dict image is updated.
+// The returned chunkDictInfo must be released after the conversion, it's
+// nil if the chunk dict image isn't built yet.
+func (d *Driver) getChunkDict(ctx context.Context, provider accelcontent.Provider) (*chunkDictInfo, error) {
+	chunkDictRef := d.getChunkDictRef()
+	if chunkDictRef == "" {
+		return nil, nil
+	}
+
+	dgst, err := d.resolveChunkDict(ctx, provider, chunkDictRef)
+	if err != nil {
+		d.chunkDictCache.mutex.Lock()
+		current := d.chunkDictCache.current
+		d.chunkDictCache.mutex.Unlock()
+		if current == "" {
+			// The chunk dict image may not be built yet.
+			if errors.Is(err, ctrErrdefs.ErrNotFound) {
+				logrus.Warnf("chunk dict image %s isn't found, convert without chunk dict", chunkDictRef)
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "resolve chunk dict image %s", chunkDictRef)
+		}
+		logrus.WithError(err).Warnf("failed to resolve chunk dict image %s, use the cached %s", chunkDictRef, current)
+		dgst = current
+	}
+
+	if info := d.chunkDictCache.acquire(dgst); info != nil {
+		metrics.CountInc(nil, metrics.ChunkDict.OpTotal, nil, "hit")
+		logrus.Debugf("use cached chunk dict %s@%s", chunkDictRef, dgst)
+		return info, nil
+	}
+
+	var info *chunkDictInfo
+	if err := metrics.ChunkDict.OpWrap(func() error {
+		var err error
+		info, err = d.pullChunkDict(ctx, provider, chunkDictRef, dgst)
+		return err
+	}, "refresh"); err != nil {
+		return nil, err
+	}
+	logrus.Infof("refreshed chunk dict %s to %s", chunkDictRef, dgst)
+
+	return info, nil
+}
+
+// pinnedRef returns the reference of chunk dict image pinned to the
+// manifest digest, the tag is dropped.
+func pinnedRef(ref string, dgst digest.Digest) (string, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "", err
+	}
+	pinned, err := docker.WithDigest(docker.TrimNamed(named), dgst)
+	if err != nil {
+		return "", err
+	}
+	return pinned.String(), nil
+}
+
+// pullChunkDict pulls chunk dict image by the resolved digest, so that the
+// cached bootstrap matches the digest even if the tag is updated meanwhile,
+// and unpacks the bootstrap into cache.
+func (d *Driver) pullChunkDict(ctx context.Context, provider accelcontent.Provider, ref string, dgst digest.Digest) (*chunkDictInfo, error) {
+	parser, err := parser.New(provider)
+	if err != nil {
+		return nil, errors.Wrap(err, "create chunk dict parser")
+	}
+	if ref, err = pinnedRef(ref, dgst); err != nil {
+		return nil, errors.Wrap(err, "parse chunk dict image reference")
+	}
+
+	bootstrapReader, _, err := parser.PullAsChunkDict(ctx, ref, false)
+	if err != nil {
+		if errdefs.NeedsRetryWithHTTP(err) {
+			logrus.Infof("try to pull chunk dict image with plain HTTP for %s", ref)
+			bootstrapReader, _, err = parser.PullAsChunkDict(ctx, ref, true)
+			if err != nil {
+				return nil, errors.Wrapf(err, "try to pull chunk dict image %s", ref)
+			}
+		} else {
+			return nil, errors.Wrapf(err, "pull chunk dict image %s", ref)
+		}
+	}
+	defer bootstrapReader.Close()
+
+	if err := os.MkdirAll(d.chunkDictCache.dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create chunk dict directory")
+	}
+	bootstrapFile, err := os.CreateTemp(d.chunkDictCache.dir, "unpacking-")
+	if err != nil {
+		return nil, errors.Wrapf(err, "create temp file for chunk dict bootstrap")
+	}
+	defer os.Remove(bootstrapFile.Name())
+	defer bootstrapFile.Close()
+
+	if err := nydusutils.UnpackFile(content.NewReader(bootstrapReader), nydusutils.BootstrapFileNameInLayer, bootstrapFile.Name()); err != nil {
+		return nil, errors.Wrap(err, "unpack nydus bootstrap")
+	}
+
+	return d.chunkDictCache.store(dgst, bootstrapFile.Name())
+}
@@ -0,0 +1,221 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/platforms"
+	nydusify "github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	accelcontent "github.com/goharbor/acceleration-service/pkg/content"
+	nydusutils "github.com/goharbor/acceleration-service/pkg/driver/nydus/utils"
+	"github.com/goharbor/acceleration-service/pkg/utils"
+)
+
+// BuildChunkDict generates a chunk dict image from the bootstraps of the
+// specified nydus images by `nydus-image chunkdict generate`. The chunk dict
+// image consists of the generated bootstrap layer and the blob layers of
+// source images, only the manifests of the default platform are used.
+func (d *Driver) BuildChunkDict(ctx context.Context, provider accelcontent.Provider, images []string) (*ocispec.Descriptor, error) {
+	cs := provider.ContentStore()
+
+	workDir, err := os.MkdirTemp(d.workDir, "nydus-chunk-dict-build-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create chunk dict work directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	platform := platforms.DefaultSpec()
+	matcher := platforms.Only(platform)
+
+	bootstrapPaths := []string{}
+	blobs := []ocispec.Descriptor{}
+	blobDigests := map[digest.Digest]bool{}
+	for _, ref := range images {
+		image, err := provider.Image(ctx, ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get image %s", ref)
+		}
+		manifestDescs, err := utils.GetManifests(ctx, cs, *image, matcher)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get manifests of image %s", ref)
+		}
+		for _, manifestDesc := range manifestDescs {
+			var manifest ocispec.Manifest
+			if _, err := utils.ReadJSON(ctx, cs, &manifest, manifestDesc); err != nil {
+				return nil, errors.Wrapf(err, "read manifest of image %s", ref)
+			}
+			if !nydusutils.IsNydusManifest(&manifest) {
+				continue
+			}
+			bootstrapDesc := manifest.Layers[len(manifest.Layers)-1]
+			bootstrapPath := filepath.Join(workDir, fmt.Sprintf("source-%d.boot", len(bootstrapPaths)))
+			if err := unpackBootstrap(ctx, cs, bootstrapDesc, bootstrapPath); err != nil {
+				return nil, errors.Wrapf(err, "unpack bootstrap of image %s", ref)
+			}
+			bootstrapPaths = append(bootstrapPaths, bootstrapPath)
+
+			for _, layer := range manifest.Layers {
+				if nydusify.IsNydusBlob(layer) && !blobDigests[layer.Digest] {
+					blobDigests[layer.Digest] = true
+					blobs = append(blobs, layer)
+				}
+			}
+			break
+		}
+	}
+	if len(bootstrapPaths) == 0 {
+		return nil, fmt.Errorf("no nydus image found for platform %s", platforms.Format(platform))
+	}
+
+	bootstrapPath := filepath.Join(workDir, "chunk-dict.boot")
+	if err := d.generateChunkDict(ctx, workDir, bootstrapPaths, bootstrapPath); err != nil {
+		return nil, err
+	}
+
+	bootstrapDesc, err := writeBootstrapLayer(ctx, cs, bootstrapPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "write chunk dict bootstrap layer")
+	}
+
+	return writeChunkDictManifest(ctx, cs, platform, append(blobs, *bootstrapDesc))
+}
+
+func unpackBootstrap(ctx context.Context, cs content.Store, desc ocispec.Descriptor, target string) error {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrap(err, "get bootstrap layer reader")
+	}
+	defer ra.Close()
+
+	return nydusutils.UnpackFile(content.NewReader(ra), nydusutils.BootstrapFileNameInLayer, target)
+}
+
+func (d *Driver) generateChunkDict(ctx context.Context, workDir string, sources []string, target string) error {
+	args := []string{
+		"chunkdict",
+		"generate",
+		"--log-level",
+		"warn",
+		"--database",
+		"sqlite://" + filepath.Join(workDir, "chunk-dict.db"),
+		"--bootstrap",
+		target,
+	}
+	args = append(args, sources...)
+
+	logrus.Debugf("\tCommand: %s %v", d.builderPath, args)
+
+	cmd := exec.CommandContext(ctx, d.builderPath, args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "generate chunk dict: %s", string(output))
+	}
+
+	return nil
+}
+
+// writeBootstrapLayer packs the bootstrap file into an uncompressed tar
+// layer, so that the diff id of the layer is same as the digest.
+func writeBootstrapLayer(ctx context.Context, cs content.Store, bootstrapPath string) (*ocispec.Descriptor, error) {
+	rc, err := nydusutils.PackTargz(bootstrapPath, nydusutils.BootstrapFileNameInLayer, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "pack bootstrap")
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(rc); err != nil {
+		return nil, errors.Wrap(err, "read packed bootstrap")
+	}
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(buf.Bytes()),
+		Size:      int64(buf.Len()),
+		Annotations: map[string]string{
+			nydusutils.LayerAnnotationNydusBootstrap: "true",
+		},
+	}
+	if err := content.WriteBlob(
+		ctx, cs, desc.Digest.String(), bytes.NewReader(buf.Bytes()), desc,
+	); err != nil {
+		return nil, errors.Wrap(err, "write bootstrap layer")
+	}
+
+	return &desc, nil
+}
+
+func writeChunkDictManifest(ctx context.Context, cs content.Store, platform ocispec.Platform, layers []ocispec.Descriptor) (*ocispec.Descriptor, error) {
+	diffIDs := []digest.Digest{}
+	for _, layer := range layers {
+		// Both nydus blob and the uncompressed bootstrap layer
+		// use the digest as diff id.
+		diffIDs = append(diffIDs, layer.Digest)
+	}
+	config := ocispec.Image{
+		Platform: platform,
+		RootFS: ocispec.RootFS{
+			Type:    "layers",
+			DiffIDs: diffIDs,
+		},
+	}
+	configDesc, configBytes, err := nydusutils.MarshalToDesc(config, ocispec.MediaTypeImageConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal image config")
+	}
+	if err := content.WriteBlob(
+		ctx, cs, configDesc.Digest.String(), bytes.NewReader(configBytes), *configDesc,
+	); err != nil {
+		return nil, errors.Wrap(err, "write image config")
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    *configDesc,
+		Layers:    layers,
+	}
+	manifestDesc, manifestBytes, err := nydusutils.MarshalToDesc(manifest, ocispec.MediaTypeImageManifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal image manifest")
+	}
+
+	labels := map[string]string{}
+	labels["containerd.io/gc.ref.content.config"] = configDesc.Digest.String()
+	for idx, layer := range layers {
+		labels[fmt.Sprintf("containerd.io/gc.ref.content.l.%d", idx)] = layer.Digest.String()
+	}
+	if err := content.WriteBlob(
+		ctx, cs, manifestDesc.Digest.String(), bytes.NewReader(manifestBytes), *manifestDesc, content.WithLabels(labels),
+	); err != nil {
+		return nil, errors.Wrap(err, "write image manifest")
+	}
+
+	return manifestDesc, nil
+}
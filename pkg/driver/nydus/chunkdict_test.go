@@ -0,0 +1,73 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func storeChunkDict(t *testing.T, cache *chunkDictCache, dgst digest.Digest) *chunkDictInfo {
+	require.NoError(t, os.MkdirAll(cache.dir, 0755))
+	file := filepath.Join(cache.dir, "unpacking-test")
+	require.NoError(t, os.WriteFile(file, []byte(dgst), 0644))
+	info, err := cache.store(dgst, file)
+	require.NoError(t, err)
+	return info
+}
+
+func TestChunkDictCache(t *testing.T) {
+	cache := newChunkDictCache(t.TempDir())
+
+	dgst1 := digest.FromString("chunk-dict-1")
+	dgst2 := digest.FromString("chunk-dict-2")
+
+	require.Nil(t, cache.acquire(dgst1))
+
+	info1 := storeChunkDict(t, cache, dgst1)
+	require.Equal(t, cache.bootstrapPath(dgst1), info1.BootstrapPath)
+
+	// Hit the cached bootstrap.
+	info := cache.acquire(dgst1)
+	require.NotNil(t, info)
+	info.release()
+	require.FileExists(t, info1.BootstrapPath)
+
+	// The stale bootstrap is kept until it's released.
+	info2 := storeChunkDict(t, cache, dgst2)
+	require.FileExists(t, info1.BootstrapPath)
+	info1.release()
+	require.NoFileExists(t, info1.BootstrapPath)
+
+	// The latest bootstrap is kept after released.
+	info2.release()
+	info2.release()
+	require.FileExists(t, info2.BootstrapPath)
+}
+
+func TestPinnedRef(t *testing.T) {
+	dgst := digest.FromString("chunk dict")
+	ref, err := pinnedRef("192.168.1.1/library/chunkdict:latest", dgst)
+	require.NoError(t, err)
+	require.Equal(t, "192.168.1.1/library/chunkdict@"+dgst.String(), ref)
+
+	ref, err = pinnedRef("chunkdict", dgst)
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/chunkdict@"+dgst.String(), ref)
+}
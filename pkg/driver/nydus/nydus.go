@@ -22,6 +22,7 @@ import (
 
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -37,10 +38,10 @@ import (
 	"github.com/goharbor/acceleration-service/pkg/adapter/annotation"
 	"github.com/goharbor/acceleration-service/pkg/cache"
 	accelcontent "github.com/goharbor/acceleration-service/pkg/content"
-	"github.com/goharbor/acceleration-service/pkg/driver/nydus/parser"
+	"github.com/goharbor/acceleration-service/pkg/converter/layer"
 	nydusutils "github.com/goharbor/acceleration-service/pkg/driver/nydus/utils"
-	"github.com/goharbor/acceleration-service/pkg/errdefs"
 	"github.com/goharbor/acceleration-service/pkg/utils"
+	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
@@ -63,16 +64,14 @@ var builderVersionOnce sync.Once
 
 type CacheRef struct{}
 
-type chunkDictInfo struct {
-	BootstrapPath string
-}
-
 type Driver struct {
 	workDir           string
 	builderPath       string
 	fsVersion         string
 	compressor        string
+	chunkDictMutex    sync.RWMutex
 	chunkDictRef      string
+	chunkDictCache    *chunkDictCache
 	mergeManifest     bool
 	ociRef            bool
 	docker2oci        bool
@@ -84,6 +83,7 @@ type Driver struct {
 	platformMC        platforms.MatchComparer
 	encryptRecipients []string
 	withReferrer      bool
+	configHash        string
 }
 
 func detectBuilderVersion(ctx context.Context, builder string) string {
@@ -209,6 +209,7 @@ func New(cfg map[string]string, platformMC platforms.MatchComparer) (*Driver, er
 		fsVersion:         fsVersion,
 		compressor:        compressor,
 		chunkDictRef:      chunkDictRef,
+		chunkDictCache:    newChunkDictCache(workDir),
 		mergeManifest:     mergeManifest,
 		ociRef:            ociRef,
 		docker2oci:        docker2oci,
@@ -220,6 +221,7 @@ func New(cfg map[string]string, platformMC platforms.MatchComparer) (*Driver, er
 		platformMC:        platformMC,
 		encryptRecipients: encryptRecipients,
 		withReferrer:      withReferrer,
+		configHash:        layer.ConfigHash("nydus", cfg),
 	}, nil
 }
 
@@ -227,8 +229,22 @@ func (d *Driver) Name() string {
 	return "nydus"
 }
 
+// Version returns the digest of nydus builder version and the options
+// affecting the converted layers, the layers converted by a different
+// builder or options aren't reused from the remote cache.
 func (d *Driver) Version() string {
-	return ""
+	options := strings.Join([]string{
+		"converter=" + utils.ModuleVersion("github.com/containerd/nydus-snapshotter"),
+		"builder=" + detectBuilderVersion(context.Background(), d.builderPath),
+		"fs_version=" + d.fsVersion,
+		"compressor=" + d.compressor,
+		"fs_align_chunk=" + strconv.FormatBool(d.alignedChunk),
+		"fs_chunk_size=" + d.chunkSize,
+		"batch_size=" + d.batchSize,
+		"oci_ref=" + strconv.FormatBool(d.ociRef),
+		"encrypt_recipients=" + strings.Join(d.encryptRecipients, ","),
+	}, "\n")
+	return digest.FromString(options).Encoded()[:12]
 }
 
 func (d *Driver) Convert(ctx context.Context, provider accelcontent.Provider, sourceRef string) (*ocispec.Descriptor, error) {
@@ -250,12 +266,15 @@ func (d *Driver) convert(ctx context.Context, provider accelcontent.Provider, so
 	cs := provider.ContentStore()
 
 	chunkDictPath := ""
-	if d.chunkDictRef != "" {
+	if d.getChunkDictRef() != "" {
 		chunkDictInfo, err := d.getChunkDict(ctx, provider)
 		if err != nil {
 			return nil, errors.Wrap(err, "get chunk dict info")
 		}
-		chunkDictPath = chunkDictInfo.BootstrapPath
+		if chunkDictInfo != nil {
+			defer chunkDictInfo.release()
+			chunkDictPath = chunkDictInfo.BootstrapPath
+		}
 	}
 
 	packOpt := nydusify.PackOption{
@@ -321,8 +340,16 @@ func (d *Driver) convert(ctx context.Context, provider accelcontent.Provider, so
 	convertHooks := converter.ConvertHooks{
 		PostConvertHook: convertHookFunc,
 	}
+	// The converted layer also depends on the chunk dict in use.
+	configHash := d.configHash
+	if chunkDictPath != "" {
+		configHash = layer.ConfigHash(configHash, map[string]string{"chunk_dict": filepath.Base(chunkDictPath)})
+	}
+	layerConvertFunc := layer.Dedup(configHash, layer.Cached(
+		d.Name(), configHash, detectBuilderVersion(ctx, d.builderPath), nydusify.LayerConvertFunc(packOpt),
+	))
 	convertFunc := func(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (*ocispec.Descriptor, error) {
-		target, err := nydusify.LayerConvertFunc(packOpt)(ctx, cs, desc)
+		target, err := layerConvertFunc(ctx, cs, desc)
 		if err == nil && target != nil {
 			cache.Set(ctx, desc, *target)
 		}
@@ -382,46 +409,3 @@ func (d *Driver) makeManifestIndex(ctx context.Context, cs content.Store, oci, n
 
 	return indexDesc, nil
 }
-
-func (d *Driver) getChunkDict(ctx context.Context, provider accelcontent.Provider) (*chunkDictInfo, error) {
-	if d.chunkDictRef == "" {
-		return nil, nil
-	}
-
-	parser, err := parser.New(provider)
-	if err != nil {
-		return nil, errors.Wrap(err, "create chunk dict parser")
-	}
-
-	bootstrapReader, _, err := parser.PullAsChunkDict(ctx, d.chunkDictRef, false)
-	if err != nil {
-		if errdefs.NeedsRetryWithHTTP(err) {
-			logrus.Infof("try to pull chunk dict image with plain HTTP for %s", d.chunkDictRef)
-			bootstrapReader, _, err = parser.PullAsChunkDict(ctx, d.chunkDictRef, true)
-			if err != nil {
-				return nil, errors.Wrapf(err, "try to pull chunk dict image %s", d.chunkDictRef)
-			}
-		} else {
-			return nil, errors.Wrapf(err, "pull chunk dict image %s", d.chunkDictRef)
-		}
-	}
-	defer bootstrapReader.Close()
-
-	bootstrapFile, err := os.CreateTemp(d.workDir, "nydus-chunk-dict-")
-	if err != nil {
-		return nil, errors.Wrapf(err, "create temp file for chunk dict bootstrap")
-	}
-	defer bootstrapFile.Close()
-
-	bootstrapPath := bootstrapFile.Name()
-	// FIXME: avoid unpacking the bootstrap on every conversion.
-	if err := nydusutils.UnpackFile(content.NewReader(bootstrapReader), nydusutils.BootstrapFileNameInLayer, bootstrapFile.Name()); err != nil {
-		return nil, errors.Wrap(err, "unpack nydus bootstrap")
-	}
-
-	chunkDict := chunkDictInfo{
-		BootstrapPath: bootstrapPath,
-	}
-
-	return &chunkDict, nil
-}
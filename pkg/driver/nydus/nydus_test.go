@@ -0,0 +1,38 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	newDriver := func(cfg map[string]string) *Driver {
+		cfg["work_dir"] = t.TempDir()
+		driver, err := New(cfg, platforms.All)
+		require.NoError(t, err)
+		return driver
+	}
+
+	version := newDriver(map[string]string{}).Version()
+	require.NotEmpty(t, version)
+	// The options not affecting converted layers keep the version.
+	require.Equal(t, version, newDriver(map[string]string{"merge_manifest": "true"}).Version())
+	require.NotEqual(t, version, newDriver(map[string]string{"compressor": "zstd"}).Version())
+	require.NotEqual(t, version, newDriver(map[string]string{"fs_version": "5"}).Version())
+}
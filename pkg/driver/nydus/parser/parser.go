@@ -40,7 +40,9 @@ func (parser *Parser) PullAsChunkDict(ctx context.Context, ref string, usePlainH
 	cs := parser.content.ContentStore()
 
 	if usePlainHTTP {
-		parser.content.UsePlainHTTP()
+		if err := parser.content.UsePlainHTTP(ref); err != nil {
+			return nil, nil, errors.Wrap(err, "use plain HTTP")
+		}
 	}
 
 	if err := parser.content.Pull(ctx, ref); err != nil {
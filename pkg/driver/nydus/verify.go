@@ -0,0 +1,165 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content"
+	nydusify "github.com/containerd/nydus-snapshotter/pkg/converter"
+	nydusutils "github.com/goharbor/acceleration-service/pkg/driver/nydus/utils"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// ConvertedLayers returns the source layers containing file data followed by
+// the bootstrap layer, the layers without file data (like the whiteout-only
+// layers) are dropped from the converted manifest since they have no blob.
+func (d *Driver) ConvertedLayers(ctx context.Context, cs content.Store, source ocispec.Manifest) ([]ocispec.Descriptor, int, error) {
+	if d.backend != nil {
+		// Only the bootstrap layer is kept in manifest if blobs are
+		// pushed to the storage backend.
+		return []ocispec.Descriptor{}, 1, nil
+	}
+	if d.getChunkDictRef() != "" {
+		// The blob layers are rewritten by the blobs referenced from chunk dict.
+		return nil, 0, nil
+	}
+	layers := []ocispec.Descriptor{}
+	for _, desc := range source.Layers {
+		hasData, err := hasFileData(ctx, cs, desc)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "read layer %s", desc.Digest)
+		}
+		if hasData {
+			layers = append(layers, desc)
+		}
+	}
+	return layers, 1, nil
+}
+
+// hasFileData checks if the layer contains any regular file with data.
+func hasFileData(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (bool, error) {
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return false, err
+	}
+	defer ra.Close()
+	rdr, err := compression.DecompressStream(content.NewReader(ra))
+	if err != nil {
+		return false, err
+	}
+	defer rdr.Close()
+
+	tr := tar.NewReader(rdr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			return true, nil
+		}
+	}
+}
+
+func (d *Driver) VerifyLayers(ctx context.Context, cs content.Store, target ocispec.Manifest) error {
+	if len(target.Layers) == 0 {
+		return fmt.Errorf("no layer found in manifest")
+	}
+	bootstrapDesc := target.Layers[len(target.Layers)-1]
+	if !nydusify.IsNydusBootstrap(bootstrapDesc) {
+		return fmt.Errorf("the last layer %s isn't nydus bootstrap", bootstrapDesc.Digest)
+	}
+	if err := d.verifyBootstrap(ctx, cs, bootstrapDesc); err != nil {
+		return errors.Wrapf(err, "verify bootstrap %s", bootstrapDesc.Digest)
+	}
+	return nil
+}
+
+// verifyBootstrap unpacks the bootstrap from bootstrap layer and
+// checks it can be parsed with the expected fs version.
+func (d *Driver) verifyBootstrap(ctx context.Context, cs content.Store, desc ocispec.Descriptor) error {
+	if len(d.encryptRecipients) > 0 {
+		// The encrypted bootstrap can't be parsed without private key.
+		return nil
+	}
+
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return errors.Wrap(err, "get bootstrap layer reader")
+	}
+	defer ra.Close()
+
+	bootstrapFile, err := os.CreateTemp(d.workDir, "nydus-verify-bootstrap-")
+	if err != nil {
+		return errors.Wrap(err, "create temp file for bootstrap")
+	}
+	defer os.Remove(bootstrapFile.Name())
+	defer bootstrapFile.Close()
+
+	if err := nydusutils.UnpackFile(content.NewReader(ra), nydusutils.BootstrapFileNameInLayer, bootstrapFile.Name()); err != nil {
+		return errors.Wrap(err, "unpack bootstrap")
+	}
+
+	if d.fsVersion == "6" {
+		if _, err := nydusutils.GetRawBootstrapFromV6(bootstrapFile); err != nil {
+			return errors.Wrap(err, "parse bootstrap")
+		}
+		return nil
+	}
+
+	info, err := bootstrapFile.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat bootstrap")
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("empty bootstrap")
+	}
+
+	return nil
+}
+
+func (d *Driver) UnpackLayer(ctx context.Context, cs content.Store, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	// The blob layer can't be unpacked standalone if the blob data
+	// is stored in backend or referenced from the OCI layer.
+	if !nydusify.IsNydusBlob(desc) || d.backend != nil || d.ociRef || len(d.encryptRecipients) > 0 {
+		return nil, nil
+	}
+
+	ra, err := cs.ReaderAt(ctx, desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "get blob layer reader")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer ra.Close()
+		pw.CloseWithError(nydusify.Unpack(ctx, ra, pw, nydusify.UnpackOption{
+			WorkDir:     d.workDir,
+			BuilderPath: d.builderPath,
+		}))
+	}()
+
+	return pr, nil
+}
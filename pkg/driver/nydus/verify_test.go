@@ -0,0 +1,80 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nydus
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLayer(t *testing.T, store content.Store, hdrs ...*tar.Header) ocispec.Descriptor {
+	buf := new(bytes.Buffer)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for _, hdr := range hdrs {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write(make([]byte, hdr.Size))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(buf.Bytes()),
+		Size:      int64(buf.Len()),
+	}
+	require.NoError(t, content.WriteBlob(context.Background(), store, desc.Digest.String(), bytes.NewReader(buf.Bytes()), desc))
+	return desc
+}
+
+func TestConvertedLayers(t *testing.T) {
+	store, err := local.NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	data := writeLayer(t, store,
+		&tar.Header{Name: "etc/", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Size: 3},
+	)
+	whiteout := writeLayer(t, store,
+		&tar.Header{Name: "etc/", Typeflag: tar.TypeDir},
+		&tar.Header{Name: "etc/.wh.hosts", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+	)
+	source := ocispec.Manifest{Layers: []ocispec.Descriptor{data, whiteout}}
+
+	d := &Driver{}
+	layers, extra, err := d.ConvertedLayers(context.Background(), store, source)
+	require.NoError(t, err)
+	require.Equal(t, []ocispec.Descriptor{data}, layers)
+	require.Equal(t, 1, extra)
+
+	// The layer count isn't checked with chunk dict.
+	d.chunkDictRef = "localhost/chunk_dict/image:latest"
+	layers, _, err = d.ConvertedLayers(context.Background(), store, source)
+	require.NoError(t, err)
+	require.Nil(t, layers)
+}
@@ -19,6 +19,9 @@ var (
 	ErrAlreadyConverted = errors.New("ERR_ALREADY_CONVERTED")
 	ErrUnhealthy        = errors.New("ERR_UNHEALTHY")
 	ErrSameTag          = errors.New("ERR_SAME_TAG")
+	ErrVerifyFailed     = errors.New("ERR_VERIFY_FAILED")
+	ErrChunkDictFailed  = errors.New("ERR_CHUNK_DICT_FAILED")
+	ErrBackfillFailed   = errors.New("ERR_BACKFILL_FAILED")
 )
 
 // IsErrHTTPResponseToHTTPSClient returns whether err is
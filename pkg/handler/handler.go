@@ -22,7 +22,10 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/goharbor/acceleration-service/pkg/adapter"
+	"github.com/goharbor/acceleration-service/pkg/backfill"
 	"github.com/goharbor/acceleration-service/pkg/config"
+	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/model"
 )
 
 const healthCheckTimeout = time.Second * 5
@@ -35,11 +38,29 @@ type Handler interface {
 	// Convert converts source image to target image by specifying
 	// source image reference, the conversion is asynchronous, and
 	// if the sync option is specified, the HTTP request will be
-	// blocked until the conversion is complete.
-	Convert(ctx context.Context, ref string, sync bool) error
+	// blocked until the conversion is complete. The overrides replace
+	// the configured driver config, platforms and target reference
+	// if not nil. The ID of created task is returned.
+	Convert(ctx context.Context, ref string, sync bool, overrides *model.Overrides) (string, error)
+	// Plan reports what the conversion of source image reference would
+	// do, including the target and cache references, the matched platforms
+	// and cache hits, without converting or pushing anything.
+	Plan(ctx context.Context, ref string, overrides *model.Overrides) (*converter.Plan, error)
 	// CheckHealth checks the acceld service is healthy and can serve
 	// webhook request.
 	CheckHealth(ctx context.Context) error
+	// BuildChunkDict builds a chunk dict image from the most frequently
+	// converted images of the repository (or project), the configured
+	// repository is used if the repository parameter is empty.
+	BuildChunkDict(ctx context.Context, repository string) error
+	// Backfill creates a job converting the existing images of registry,
+	// the repositories and tags are listed by registry (or Harbor) API,
+	// and the matched images are enqueued with rate limiting.
+	Backfill(ctx context.Context, req model.BackfillRequest) (*backfill.Job, error)
+	// ListBackfills lists the backfill jobs with progress.
+	ListBackfills(ctx context.Context) []*backfill.Job
+	// ResumeBackfills resumes the backfill jobs interrupted by the last exit.
+	ResumeBackfills()
 }
 
 type LocalHandler struct {
@@ -71,8 +92,12 @@ func (handler *LocalHandler) Auth(_ context.Context, host string, authHeader str
 	return nil
 }
 
-func (handler *LocalHandler) Convert(ctx context.Context, ref string, sync bool) error {
-	return handler.adp.Dispatch(ctx, ref, sync)
+func (handler *LocalHandler) Convert(ctx context.Context, ref string, sync bool, overrides *model.Overrides) (string, error) {
+	return handler.adp.Dispatch(ctx, ref, sync, overrides)
+}
+
+func (handler *LocalHandler) Plan(ctx context.Context, ref string, overrides *model.Overrides) (*converter.Plan, error) {
+	return handler.adp.Plan(ctx, ref, overrides)
 }
 
 func (handler *LocalHandler) CheckHealth(ctx context.Context) error {
@@ -80,3 +105,19 @@ func (handler *LocalHandler) CheckHealth(ctx context.Context) error {
 	defer cancel()
 	return handler.adp.CheckHealth(ctx)
 }
+
+func (handler *LocalHandler) BuildChunkDict(ctx context.Context, repository string) error {
+	return handler.adp.BuildChunkDict(ctx, repository)
+}
+
+func (handler *LocalHandler) Backfill(ctx context.Context, req model.BackfillRequest) (*backfill.Job, error) {
+	return handler.adp.Backfill(ctx, req)
+}
+
+func (handler *LocalHandler) ListBackfills(_ context.Context) []*backfill.Job {
+	return handler.adp.ListBackfills()
+}
+
+func (handler *LocalHandler) ResumeBackfills() {
+	handler.adp.ResumeBackfills()
+}
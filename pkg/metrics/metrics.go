@@ -18,13 +18,13 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/goharbor/acceleration-service/pkg/converter"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var subsystem = "acceleration_service_core"
 
 var Conversion ConversionMetric
+var ChunkDict ChunkDictMetric
 
 type OpWrapper struct {
 	OpDuration   *prometheus.HistogramVec
@@ -36,14 +36,24 @@ type ConversionMetric struct {
 	*OpWrapper
 }
 
+type ChunkDictMetric struct {
+	*OpWrapper
+}
+
 func init() {
 	Conversion = ConversionMetric{
 		OpWrapper: NewOpWrapper("conversions", []string{"op"}),
 	}
+	ChunkDict = ChunkDictMetric{
+		OpWrapper: NewOpWrapper("chunk_dict", []string{"op"}),
+	}
 	prometheus.MustRegister(
 		Conversion.OpDuration,
 		Conversion.OpTotal,
 		Conversion.OpErrorTotal,
+		ChunkDict.OpDuration,
+		ChunkDict.OpTotal,
+		ChunkDict.OpErrorTotal,
 	)
 }
 
@@ -76,14 +86,14 @@ func NewOpWrapper(scope string, labelNames []string) *OpWrapper {
 	}
 }
 
-func (metrics *OpWrapper) OpWrap(op func() (*converter.Metric, error), lvs ...string) (*converter.Metric, error) {
+func (metrics *OpWrapper) OpWrap(op func() error, lvs ...string) error {
 	start := time.Now()
 
-	metric, err := op()
+	err := op()
 	Duration(err, metrics.OpDuration, start, lvs...)
 	CountInc(err, metrics.OpTotal, metrics.OpErrorTotal, lvs...)
 
-	return metric, err
+	return err
 }
 
 func Duration(err error, metric *prometheus.HistogramVec, start time.Time, lvs ...string) {
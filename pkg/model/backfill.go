@@ -0,0 +1,32 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// BackfillRequest is the request to convert the existing images of
+// registry, the images are enqueued as the conversions of webhook.
+type BackfillRequest struct {
+	// Registry is the registry host like `192.168.1.1:5000`.
+	Registry string `json:"registry"`
+	// Project limits the repositories under the namespace like `library`.
+	Project string `json:"project,omitempty"`
+	// Repository limits to a single repository like `library/nginx`.
+	Repository string `json:"repository,omitempty"`
+	// TagPattern is the regular expression matching the whole tag.
+	TagPattern string `json:"tag_pattern,omitempty"`
+	// MaxAge skips the images older than the duration like `720h`, the
+	// age is counted from the push time reported by Harbor, or the
+	// creation time in image config.
+	MaxAge string `json:"max_age,omitempty"`
+}
@@ -0,0 +1,35 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+// ConversionPayload is the payload of conversion request, it's compatible
+// with the webhook payload of Harbor, and extended by the overrides of the
+// conversion.
+type ConversionPayload struct {
+	Payload
+	Overrides *Overrides `json:"overrides,omitempty"`
+}
+
+// Overrides overrides the conversion configuration of acceld for a request.
+type Overrides struct {
+	// DriverConfig overrides the allowed keys of `converter.driver.config`,
+	// like `fs_version`, `compressor` and `prefetch_patterns`.
+	DriverConfig map[string]string `json:"driver_config,omitempty" yaml:"driver_config"`
+	// Platforms overrides `converter.platforms`, like `linux/amd64,linux/arm64`.
+	Platforms string `json:"platforms,omitempty" yaml:"platforms"`
+	// Target is the target reference used instead of the one created by
+	// `converter.rules`, it's only allowed for the request of single resource.
+	Target string `json:"target,omitempty" yaml:"target"`
+}
@@ -0,0 +1,178 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+type bandwidthKey struct{}
+
+// BandwidthLimiter limits the bandwidth (bytes per second) of the transfers
+// with registries by token buckets, the buckets are shared by all requests
+// using the same limiter.
+type BandwidthLimiter struct {
+	mutex    sync.Mutex
+	perHost  uint64
+	hosts    map[string]uint64
+	limiters map[string]*rate.Limiter
+	global   *rate.Limiter
+}
+
+// NewBandwidthLimiter creates a bandwidth limiter, perHost is the default limit
+// for each registry host, hosts overrides the limit for specified hosts, and
+// global is the limit shared by all hosts. Zero means unlimited.
+func NewBandwidthLimiter(perHost uint64, hosts map[string]uint64, global uint64) *BandwidthLimiter {
+	limiter := &BandwidthLimiter{
+		perHost:  perHost,
+		hosts:    hosts,
+		limiters: make(map[string]*rate.Limiter),
+	}
+	if global > 0 {
+		limiter.global = newTokenBucket(global)
+	}
+	return limiter
+}
+
+func newTokenBucket(bps uint64) *rate.Limiter {
+	// Allows to burst the transfer of one second.
+	return rate.NewLimiter(rate.Limit(bps), int(bps))
+}
+
+// WithBandwidthLimiter limits the bandwidth of registry requests with context.
+func WithBandwidthLimiter(ctx context.Context, limiter *BandwidthLimiter) context.Context {
+	if limiter == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, bandwidthKey{}, limiter)
+}
+
+// buckets returns the token buckets applied to the requests of host.
+func (limiter *BandwidthLimiter) buckets(req *http.Request) []*rate.Limiter {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	buckets := []*rate.Limiter{}
+	host := req.URL.Host
+	bucket, ok := limiter.limiters[host]
+	if !ok {
+		bps, ok := limiter.hosts[host]
+		if !ok {
+			bps, ok = limiter.hosts[req.URL.Hostname()]
+		}
+		if !ok {
+			bps = limiter.perHost
+		}
+		if bps > 0 {
+			bucket = newTokenBucket(bps)
+		}
+		limiter.limiters[host] = bucket
+	}
+	if bucket != nil {
+		buckets = append(buckets, bucket)
+	}
+	if limiter.global != nil {
+		buckets = append(buckets, limiter.global)
+	}
+	return buckets
+}
+
+// bandwidthTransport limits the bandwidth of request and response body.
+type bandwidthTransport struct {
+	base http.RoundTripper
+}
+
+func (t *bandwidthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter, ok := req.Context().Value(bandwidthKey{}).(*BandwidthLimiter)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	buckets := limiter.buckets(req)
+	if len(buckets) == 0 {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(ctx)
+		req.Body = newLimitedReader(ctx, req.Body, buckets)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return newLimitedReader(ctx, body, buckets), nil
+			}
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = newLimitedReader(ctx, resp.Body, buckets)
+	}
+	return resp, nil
+}
+
+// limitedReader waits for the tokens of all buckets after each read.
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.ReadCloser
+	buckets []*rate.Limiter
+	// chunk is the max size of each read, which must not exceed the burst of buckets.
+	chunk int
+}
+
+func newLimitedReader(ctx context.Context, reader io.ReadCloser, buckets []*rate.Limiter) io.ReadCloser {
+	chunk := 32 * 1024
+	for _, bucket := range buckets {
+		if bucket.Burst() < chunk {
+			chunk = bucket.Burst()
+		}
+	}
+	return &limitedReader{
+		ctx:     ctx,
+		reader:  reader,
+		buckets: buckets,
+		chunk:   chunk,
+	}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		for _, bucket := range r.buckets {
+			if werr := bucket.WaitN(r.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+func (r *limitedReader) Close() error {
+	return r.reader.Close()
+}
@@ -0,0 +1,66 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimiterBuckets(t *testing.T) {
+	limiter := NewBandwidthLimiter(1024, map[string]uint64{"hub.harbor.com": 4096}, 0)
+	bucketsOf := func(host string) int {
+		return len(limiter.buckets(&http.Request{URL: &url.URL{Host: host}}))
+	}
+	require.Equal(t, 1, bucketsOf("docker.io"))
+	require.Equal(t, 4096, limiter.buckets(&http.Request{URL: &url.URL{Host: "hub.harbor.com:443"}})[0].Burst())
+
+	limiter = NewBandwidthLimiter(0, map[string]uint64{"hub.harbor.com": 4096}, 8192)
+	require.Equal(t, 1, bucketsOf("docker.io"))
+	require.Equal(t, 2, bucketsOf("hub.harbor.com"))
+}
+
+func TestBandwidthTransport(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 16*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	client := newDefaultClient(false, nil)
+	ctx := WithBandwidthLimiter(context.Background(), NewBandwidthLimiter(0, nil, 16*1024))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// The upload consumes the burst, and the download waits for one second.
+	start := time.Now()
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, data, body)
+	require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+}
@@ -0,0 +1,186 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+)
+
+// catalogPageSize is the number of entries requested per page of the
+// catalog and tags list API.
+const catalogPageSize = 100
+
+// NewHostClient returns the HTTP client with the TLS configuration of host,
+// it's used to call the APIs other than the distribution API of registry.
+func NewHostClient(hostConfig *HostConfig) *http.Client {
+	return newDefaultClient(hostConfig.Insecure, hostConfig.TLS)
+}
+
+// ListRepositories lists the repositories of registry host by the
+// `/v2/_catalog` API, the repositories are returned without the host.
+// It falls back to plain HTTP if the registry doesn't serve HTTPS.
+func ListRepositories(ctx context.Context, host string, hostFunc HostFunc) ([]string, error) {
+	hostConfig, err := hostFunc(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []string
+	err = withPlainHTTP(hostConfig, func(hostConfig *HostConfig) error {
+		registryHost, err := originHost(hostConfig, host)
+		if err != nil {
+			return err
+		}
+		ctx := docker.WithScope(ctx, "registry:catalog:*")
+		repositories = nil
+		return listPages(ctx, *registryHost, "_catalog", func(data []byte) error {
+			var page struct {
+				Repositories []string `json:"repositories"`
+			}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return errors.Wrap(err, "unmarshal catalog")
+			}
+			repositories = append(repositories, page.Repositories...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list repositories of %s", host)
+	}
+
+	return repositories, nil
+}
+
+// ListTags lists the tags of repository by the `/v2/<name>/tags/list` API,
+// the repository is in `<host>/<name>` form. It falls back to plain HTTP if
+// the registry doesn't serve HTTPS.
+func ListTags(ctx context.Context, repository string, hostFunc HostFunc) ([]string, error) {
+	refspec, err := reference.Parse(repository)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse repository")
+	}
+	parts := strings.SplitN(refspec.Locator, "/", 2)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid repository %s: %w", repository, errdefs.ErrInvalidArgument)
+	}
+	hostConfig, err := hostFunc(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	err = withPlainHTTP(hostConfig, func(hostConfig *HostConfig) error {
+		registryHost, err := originHost(hostConfig, refspec.Hostname())
+		if err != nil {
+			return err
+		}
+		ctx, err := docker.ContextWithRepositoryScope(ctx, refspec, false)
+		if err != nil {
+			return err
+		}
+		tags = nil
+		return listPages(ctx, *registryHost, parts[1]+"/tags/list", func(data []byte) error {
+			var page struct {
+				Tags []string `json:"tags"`
+			}
+			if err := json.Unmarshal(data, &page); err != nil {
+				return errors.Wrap(err, "unmarshal tags")
+			}
+			tags = append(tags, page.Tags...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list tags of %s", repository)
+	}
+
+	return tags, nil
+}
+
+// listPages requests the paginated list API of registry, and calls fn with
+// the body of each page, the next page is located by the `Link` header.
+func listPages(ctx context.Context, host docker.RegistryHost, api string, fn func(data []byte) error) error {
+	req := newRequest(nil, host, http.MethodGet, "", api)
+	req.path += fmt.Sprintf("?n=%d", catalogPageSize)
+	for {
+		resp, err := req.doWithRetries(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := remoteserrors.NewUnexpectedStatusErr(resp)
+			resp.Body.Close()
+			return err
+		}
+		var data json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrap(err, "decode response")
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+
+		next := nextLink(resp.Header.Get("Link"))
+		if next == nil {
+			return nil
+		}
+		req = newRequest(nil, host, http.MethodGet, "")
+		req.path = next.Path
+		if next.RawQuery != "" {
+			req.path += "?" + next.RawQuery
+		}
+	}
+}
+
+// nextLink parses the URL of next page from the `Link` header, like
+// `</v2/_catalog?last=library%2Fnginx&n=100>; rel="next"`.
+func nextLink(header string) *url.URL {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+		isNext := false
+		for _, param := range parts[1:] {
+			param = strings.ReplaceAll(strings.TrimSpace(param), " ", "")
+			if param == `rel="next"` || param == "rel=next" {
+				isNext = true
+			}
+		}
+		if !isNext {
+			continue
+		}
+		target := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(parts[0]), "<"), ">")
+		next, err := url.Parse(target)
+		if err != nil || next.Path == "" {
+			return nil
+		}
+		return next
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+func TestListCatalog(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	host := func(string) (*HostConfig, error) {
+		return &HostConfig{}, nil
+	}
+
+	putManifest := func(repository, tag string) {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/v2/%s/manifests/%s", registry.URL, repository, tag), strings.NewReader(`{"schemaVersion":2}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", ocispec.MediaTypeImageManifest)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	// More than a page of tags.
+	for idx := 0; idx < catalogPageSize+20; idx++ {
+		putManifest("library/nginx", fmt.Sprintf("v%03d", idx))
+	}
+	putManifest("library/busybox", "latest")
+
+	repositories, err := ListRepositories(context.Background(), registry.Host(), host)
+	require.NoError(t, err)
+	require.Equal(t, []string{"library/busybox", "library/nginx"}, repositories)
+
+	tags, err := ListTags(context.Background(), registry.Host()+"/library/nginx", host)
+	require.NoError(t, err)
+	require.Len(t, tags, catalogPageSize+20)
+	require.Equal(t, "v000", tags[0])
+	require.Equal(t, fmt.Sprintf("v%03d", catalogPageSize+19), tags[len(tags)-1])
+
+	_, err = ListTags(context.Background(), registry.Host()+"/library/redis", host)
+	require.Error(t, err)
+}
@@ -0,0 +1,136 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	acceldErrdefs "github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/pkg/errors"
+)
+
+// CheckPush checks the push credential of image reference is granted to push
+// to the repository, by starting a blob upload and cancelling it at once, so
+// nothing is written to the registry. It falls back to plain HTTP if the
+// registry doesn't serve HTTPS.
+func CheckPush(ctx context.Context, ref string, host HostFunc) error {
+	hostConfig, err := host(ref)
+	if err != nil {
+		return err
+	}
+	hostConfig = hostConfig.For(OperationPush)
+
+	return withPlainHTTP(hostConfig, func(hostConfig *HostConfig) error {
+		return checkPush(ctx, ref, hostConfig)
+	})
+}
+
+// withPlainHTTP calls fn with the host configuration, and calls it again
+// with plain HTTP if the registry doesn't serve HTTPS.
+func withPlainHTTP(hostConfig *HostConfig, fn func(hostConfig *HostConfig) error) error {
+	err := fn(hostConfig)
+	if acceldErrdefs.NeedsRetryWithHTTP(err) && !hostConfig.PlainHTTP {
+		plainHTTPConfig := *hostConfig
+		plainHTTPConfig.PlainHTTP = true
+		err = fn(&plainHTTPConfig)
+	}
+	return err
+}
+
+// originHost returns the origin registry host of hostname, the mirrors
+// are skipped.
+func originHost(hostConfig *HostConfig, hostname string) (*docker.RegistryHost, error) {
+	hosts, err := registryHosts(hostConfig, nil)(hostname)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range hosts {
+		if hosts[idx].Capabilities.Has(docker.HostCapabilityPush) {
+			return &hosts[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("no push hosts: %w", errdefs.ErrNotFound)
+}
+
+func checkPush(ctx context.Context, ref string, hostConfig *HostConfig) error {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse reference")
+	}
+	parts := strings.SplitN(refspec.Locator, "/", 2)
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid reference %s: %w", ref, errdefs.ErrInvalidArgument)
+	}
+	repository := parts[1]
+
+	pushHost, err := originHost(hostConfig, refspec.Hostname())
+	if err != nil {
+		return err
+	}
+
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, true)
+	if err != nil {
+		return err
+	}
+
+	req := newRequest(nil, *pushHost, http.MethodPost, repository, "blobs", "uploads/")
+	resp, err := req.doWithRetries(ctx, nil)
+	if err != nil {
+		if errors.Is(err, docker.ErrInvalidAuthorization) {
+			return fmt.Errorf("push access denied to %s: %w", ref, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNoContent:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("push access denied to %s: %w", ref, remoteserrors.NewUnexpectedStatusErr(resp))
+	default:
+		return remoteserrors.NewUnexpectedStatusErr(resp)
+	}
+
+	// Cancel the upload, it's fine to leave the upload to be cleaned
+	// by registry if the cancel fails.
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Path == "" {
+		return nil
+	}
+	cancelHost := *pushHost
+	if location.Host != "" && (location.Host != cancelHost.Host || location.Scheme != cancelHost.Scheme) {
+		cancelHost.Host = location.Host
+		cancelHost.Scheme = location.Scheme
+		cancelHost.Authorizer = nil
+	}
+	cancelReq := newRequest(nil, cancelHost, http.MethodDelete, "")
+	cancelReq.path = location.Path
+	if location.RawQuery != "" {
+		cancelReq.path += "?" + location.RawQuery
+	}
+	if cancelResp, err := cancelReq.do(ctx); err == nil {
+		cancelResp.Body.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/goharbor/acceleration-service/pkg/remote/registrytest"
+)
+
+func TestCheckPush(t *testing.T) {
+	registry := registrytest.New()
+	defer registry.Close()
+	host := func(string) (*HostConfig, error) {
+		return &HostConfig{}, nil
+	}
+	ref := registry.Host() + "/library/nginx:latest-nydus"
+
+	// Falls back to plain HTTP, and the upload is cancelled after check.
+	require.NoError(t, CheckPush(context.Background(), ref, host))
+	require.Zero(t, registry.Uploads())
+
+	registry.ReadOnly = true
+	err := CheckPush(context.Background(), ref, host)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "push access denied")
+}
@@ -0,0 +1,353 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/credentials"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// dockerCredentialCacheDuration is the duration to cache the credential read
+// from docker config, the credential helper isn't executed again within the
+// duration unless the config is modified.
+const dockerCredentialCacheDuration = 5 * time.Minute
+
+// Credential is the credential of registry, only one of the basic auth,
+// identity token and registry token is used.
+type Credential struct {
+	Username string
+	Password string
+	// IdentityToken is exchanged for the registry token by OAuth.
+	IdentityToken string
+	// RegistryToken is sent to registry as bearer token directly.
+	RegistryToken string
+}
+
+// CredentialProvider provides the credential of registry host.
+type CredentialProvider interface {
+	// Credential returns the credential of the host, an empty
+	// credential means anonymous access.
+	Credential(host string) (*Credential, error)
+}
+
+// CredentialProviderFunc adapts the func to the credential provider.
+type CredentialProviderFunc func(host string) (*Credential, error)
+
+func (fn CredentialProviderFunc) Credential(host string) (*Credential, error) {
+	return fn(host)
+}
+
+// registryTokenProvider is implemented by the credential providers which may
+// return a registry token, the others are never asked for the bearer token
+// on each registry request.
+type registryTokenProvider interface {
+	CredentialProvider
+	mayReturnRegistryToken()
+}
+
+// withRegistryToken marks the credential provider may return a registry token.
+type withRegistryToken struct {
+	CredentialProvider
+}
+
+func (withRegistryToken) mayReturnRegistryToken() {}
+
+type cachedCredential struct {
+	credential *Credential
+	expiresAt  time.Time
+}
+
+// credentialCache caches the credentials per host, the concurrent fetches of
+// the same host share a single call, which runs without the mutex held.
+type credentialCache struct {
+	group   singleflight.Group
+	mutex   sync.Mutex
+	entries map[string]cachedCredential
+	// generation is increased once the cache is reset, so that a fetch
+	// started before the reset isn't stored.
+	generation int
+}
+
+// get returns the cached credential of host, or fetches it and caches it
+// for the returned duration, zero duration disables the cache.
+func (cache *credentialCache) get(host string, fetch func() (*Credential, time.Duration, error)) (*Credential, error) {
+	cache.mutex.Lock()
+	cached, ok := cache.entries[host]
+	generation := cache.generation
+	cache.mutex.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.credential, nil
+	}
+
+	credential, err, _ := cache.group.Do(fmt.Sprintf("%d/%s", generation, host), func() (interface{}, error) {
+		credential, duration, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		if duration > 0 && generation == cache.generation {
+			if cache.entries == nil {
+				cache.entries = make(map[string]cachedCredential)
+			}
+			cache.entries[host] = cachedCredential{
+				credential: credential,
+				expiresAt:  time.Now().Add(duration),
+			}
+		} else {
+			delete(cache.entries, host)
+		}
+		return credential, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credential.(*Credential), nil
+}
+
+// reset drops all cached credentials.
+func (cache *credentialCache) reset() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.entries = nil
+	cache.generation++
+}
+
+// NewFuncCredentialProvider creates the credential provider of basic auth
+// returned by credential func.
+func NewFuncCredentialProvider(credFunc CredentialFunc) CredentialProvider {
+	return CredentialProviderFunc(func(host string) (*Credential, error) {
+		if credFunc == nil {
+			return &Credential{}, nil
+		}
+		username, password, err := credFunc(host)
+		if err != nil {
+			return nil, err
+		}
+		return &Credential{Username: username, Password: password}, nil
+	})
+}
+
+// NewTokenCredentialProvider creates the credential provider of static token,
+// the token is an identity token exchanged for registry token by OAuth if
+// identity is true, or a registry token sent as bearer token directly.
+func NewTokenCredentialProvider(token string, identity bool) CredentialProvider {
+	if identity {
+		return CredentialProviderFunc(func(string) (*Credential, error) {
+			return &Credential{IdentityToken: token}, nil
+		})
+	}
+	return withRegistryToken{CredentialProviderFunc(func(string) (*Credential, error) {
+		return &Credential{RegistryToken: token}, nil
+	})}
+}
+
+// dockerServerAddress converts the registry host to the server address
+// used as the key in docker config.
+func dockerServerAddress(host string) string {
+	// The host of docker hub image will be converted to `registry-1.docker.io` in:
+	// github.com/containerd/containerd/remotes/docker/registry.go
+	// But we need use the key `https://index.docker.io/v1/` to find auth from docker config.
+	if host == "registry-1.docker.io" {
+		return "https://index.docker.io/v1/"
+	}
+	return host
+}
+
+func fromAuthConfig(authConfig types.AuthConfig) *Credential {
+	return &Credential{
+		Username:      authConfig.Username,
+		Password:      authConfig.Password,
+		IdentityToken: authConfig.IdentityToken,
+		RegistryToken: authConfig.RegistryToken,
+	}
+}
+
+// dockerCredentialProvider reads the credential from docker config, the
+// config is re-read once modified, and the credential is cached per host
+// to avoid executing the credential helper on each request.
+type dockerCredentialProvider struct {
+	configDir string
+	helper    string
+	cache     credentialCache
+
+	mutex sync.Mutex
+	// config is the docker config of cached credentials.
+	config *configfile.ConfigFile
+	// missing is the config loaded while `config.json` doesn't exist.
+	missing *configfile.ConfigFile
+}
+
+// NewDockerCredentialProvider creates the credential provider reading docker
+// config `config.json` in configDir, which respects the `credsStore` and
+// `credHelpers` in config. If helper is not empty, the credential helper
+// `docker-credential-<helper>` is always used. The configDir defaults to
+// `$DOCKER_CONFIG` or `~/.docker`.
+func NewDockerCredentialProvider(configDir, helper string) CredentialProvider {
+	return &dockerCredentialProvider{configDir: configDir, helper: helper}
+}
+
+func (provider *dockerCredentialProvider) mayReturnRegistryToken() {}
+
+// loadConfig loads the docker config, the same config is returned until
+// `config.json` is modified.
+func (provider *dockerCredentialProvider) loadConfig() (*configfile.ConfigFile, error) {
+	configDir := provider.configDir
+	if configDir == "" {
+		configDir = dockerconfig.Dir()
+	}
+	path := filepath.Join(configDir, dockerconfig.ConfigFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		provider.mutex.Lock()
+		defer provider.mutex.Unlock()
+		if provider.missing == nil {
+			configFile, err := dockerconfig.Load(configDir)
+			if err != nil {
+				return nil, errors.Wrapf(err, "load docker config in %s", configDir)
+			}
+			provider.missing = configFile
+		}
+		return provider.missing, nil
+	}
+
+	configFile, err := loadedFiles.load(func(data [][]byte) (interface{}, error) {
+		configFile := configfile.New(path)
+		if err := configFile.LoadFromReader(bytes.NewReader(data[0])); err != nil {
+			return nil, err
+		}
+		return configFile, nil
+	}, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load docker config in %s", configDir)
+	}
+	return configFile.(*configfile.ConfigFile), nil
+}
+
+func (provider *dockerCredentialProvider) Credential(host string) (*Credential, error) {
+	configFile, err := provider.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	provider.mutex.Lock()
+	if provider.config != configFile {
+		provider.config = configFile
+		provider.cache.reset()
+	}
+	provider.mutex.Unlock()
+
+	return provider.cache.get(host, func() (*Credential, time.Duration, error) {
+		var authConfig types.AuthConfig
+		var err error
+		if provider.helper != "" {
+			authConfig, err = credentials.NewNativeStore(configFile, provider.helper).Get(dockerServerAddress(host))
+		} else {
+			authConfig, err = configFile.GetAuthConfig(dockerServerAddress(host))
+		}
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "get credential of %s from docker config", host)
+		}
+		return fromAuthConfig(authConfig), dockerCredentialCacheDuration, nil
+	})
+}
+
+// NewFileCredentialProvider creates the credential provider reading the file
+// in docker config format, like the `.dockerconfigjson` of Kubernetes secret.
+// The file is re-read once modified, so the rotated secret takes effect
+// without restart.
+func NewFileCredentialProvider(path string) CredentialProvider {
+	return withRegistryToken{CredentialProviderFunc(func(host string) (*Credential, error) {
+		configFile, err := loadedFiles.load(func(data [][]byte) (interface{}, error) {
+			configFile := configfile.New(path)
+			if err := configFile.LoadFromReader(bytes.NewReader(data[0])); err != nil {
+				return nil, err
+			}
+			return configFile, nil
+		}, path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load credential file %s", path)
+		}
+		authConfig, err := configFile.(*configfile.ConfigFile).GetAuthConfig(dockerServerAddress(host))
+		if err != nil {
+			return nil, errors.Wrapf(err, "get credential of %s from %s", host, path)
+		}
+		return fromAuthConfig(authConfig), nil
+	})}
+}
+
+// authCreds converts the credential provider to the credential func used by
+// docker authorizer, the identity token is passed as the secret with empty
+// username, which is used as the refresh token of OAuth.
+func authCreds(provider CredentialProvider) func(string) (string, string, error) {
+	return func(host string) (string, string, error) {
+		if provider == nil {
+			return "", "", nil
+		}
+		cred, err := provider.Credential(host)
+		if err != nil {
+			return "", "", err
+		}
+		if cred.IdentityToken != "" {
+			return "", cred.IdentityToken, nil
+		}
+		return cred.Username, cred.Password, nil
+	}
+}
+
+// tokenAuthorizer sends the registry token of credential as bearer token,
+// and falls back to the docker authorizer if there is no registry token.
+// The registry token is only looked up if the provider may return one.
+type tokenAuthorizer struct {
+	docker.Authorizer
+	provider CredentialProvider
+}
+
+func newAuthorizer(client *http.Client, provider CredentialProvider) docker.Authorizer {
+	return &tokenAuthorizer{
+		Authorizer: docker.NewDockerAuthorizer(
+			docker.WithAuthClient(client),
+			docker.WithAuthCreds(authCreds(provider)),
+		),
+		provider: provider,
+	}
+}
+
+func (authorizer *tokenAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	if provider, ok := authorizer.provider.(registryTokenProvider); ok {
+		cred, err := provider.Credential(req.URL.Host)
+		if err != nil {
+			return err
+		}
+		if cred.RegistryToken != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cred.RegistryToken))
+			return nil
+		}
+	}
+	return authorizer.Authorizer.Authorize(ctx, req)
+}
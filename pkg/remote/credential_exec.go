@@ -0,0 +1,145 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExecCredentialAPIVersion is the API version of exec credential plugin protocol.
+	ExecCredentialAPIVersion = "acceld.goharbor.io/v1"
+
+	defaultExecTimeout = 10 * time.Second
+)
+
+// ExecCredentialRequest is written to the stdin of exec credential plugin.
+type ExecCredentialRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Host is the registry host requiring the credential.
+	Host string `json:"host"`
+}
+
+// ExecCredentialResponse is read from the stdout of exec credential plugin.
+type ExecCredentialResponse struct {
+	APIVersion    string `json:"apiVersion"`
+	Kind          string `json:"kind"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identityToken,omitempty"`
+	RegistryToken string `json:"registryToken,omitempty"`
+	// CacheDuration overrides the default cache duration of the credential,
+	// like `10m`, zero disables the cache.
+	CacheDuration string `json:"cacheDuration,omitempty"`
+}
+
+// ExecCredentialOptions configures the exec credential plugin.
+type ExecCredentialOptions struct {
+	Command string
+	Args    []string
+	// Env is appended to the environment of acceld for the plugin.
+	Env []string
+	// Timeout of each plugin execution, defaults to 10s.
+	Timeout time.Duration
+	// CacheDuration is the default duration to cache the credential
+	// of host, zero disables the cache.
+	CacheDuration time.Duration
+}
+
+// execCredentialProvider gets the credential from the external plugin like
+// kubelet credential provider, the request and response are JSON encoded.
+type execCredentialProvider struct {
+	opts  ExecCredentialOptions
+	cache credentialCache
+}
+
+// NewExecCredentialProvider creates the credential provider executing the plugin
+// command for the credential, the credential is cached per host.
+func NewExecCredentialProvider(opts ExecCredentialOptions) CredentialProvider {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultExecTimeout
+	}
+	return &execCredentialProvider{
+		opts: opts,
+	}
+}
+
+func (provider *execCredentialProvider) mayReturnRegistryToken() {}
+
+func (provider *execCredentialProvider) Credential(host string) (*Credential, error) {
+	return provider.cache.get(host, func() (*Credential, time.Duration, error) {
+		resp, err := provider.exec(host)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "get credential of %s from plugin %s", host, provider.opts.Command)
+		}
+		credential := &Credential{
+			Username:      resp.Username,
+			Password:      resp.Password,
+			IdentityToken: resp.IdentityToken,
+			RegistryToken: resp.RegistryToken,
+		}
+
+		cacheDuration := provider.opts.CacheDuration
+		if resp.CacheDuration != "" {
+			if cacheDuration, err = time.ParseDuration(resp.CacheDuration); err != nil {
+				return nil, 0, errors.Wrapf(err, "parse cache duration of plugin %s", provider.opts.Command)
+			}
+		}
+		return credential, cacheDuration, nil
+	})
+}
+
+func (provider *execCredentialProvider) exec(host string) (*ExecCredentialResponse, error) {
+	req, err := json.Marshal(ExecCredentialRequest{
+		APIVersion: ExecCredentialAPIVersion,
+		Kind:       "CredentialProviderRequest",
+		Host:       host,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), provider.opts.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, provider.opts.Command, provider.opts.Args...)
+	cmd.Env = append(os.Environ(), provider.opts.Env...)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "execute plugin: %s", stderr.String())
+	}
+
+	var resp ExecCredentialResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, errors.Wrap(err, "decode plugin response")
+	}
+	if resp.APIVersion != ExecCredentialAPIVersion || resp.Kind != "CredentialProviderResponse" {
+		return nil, fmt.Errorf("unsupported plugin response %s/%s", resp.APIVersion, resp.Kind)
+	}
+
+	return &resp, nil
+}
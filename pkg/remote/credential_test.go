@@ -0,0 +1,156 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeDockerConfig(t *testing.T, path, host, username, password string, modTime time.Time) {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	data := fmt.Sprintf(`{"auths": {"https://%s": {"auth": %q}}}`, host, auth)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileCredentialProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".dockerconfigjson")
+	writeDockerConfig(t, path, "hub.harbor.com", "user", "old", time.Now().Add(-time.Hour))
+	provider := NewFileCredentialProvider(path)
+
+	cred, err := provider.Credential("hub.harbor.com")
+	require.NoError(t, err)
+	require.Equal(t, &Credential{Username: "user", Password: "old"}, cred)
+
+	// The rotated secret is re-read.
+	writeDockerConfig(t, path, "hub.harbor.com", "user", "new", time.Now())
+	cred, err = provider.Credential("hub.harbor.com")
+	require.NoError(t, err)
+	require.Equal(t, &Credential{Username: "user", Password: "new"}, cred)
+
+	cred, err = provider.Credential("docker.io")
+	require.NoError(t, err)
+	require.Equal(t, &Credential{}, cred)
+}
+
+func TestExecCredentialProvider(t *testing.T) {
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "plugin.sh")
+	calls := filepath.Join(dir, "calls")
+	// The plugin echoes the requested host as username.
+	script := fmt.Sprintf(`#!/bin/sh
+echo called >> %s
+host=$(sed -n 's/.*"host":"\([^"]*\)".*/\1/p')
+echo "{\"apiVersion\":\"%s\",\"kind\":\"CredentialProviderResponse\",\"username\":\"$host\",\"password\":\"$SECRET\",\"cacheDuration\":\"1h\"}"
+`, calls, ExecCredentialAPIVersion)
+	require.NoError(t, os.WriteFile(plugin, []byte(script), 0755))
+
+	provider := NewExecCredentialProvider(ExecCredentialOptions{
+		Command: plugin,
+		Env:     []string{"SECRET=pass"},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cred, err := provider.Credential("hub.harbor.com")
+			require.NoError(t, err)
+			require.Equal(t, &Credential{Username: "hub.harbor.com", Password: "pass"}, cred)
+		}()
+	}
+	wg.Wait()
+
+	// The credential is cached per host, the concurrent requests share
+	// a single execution.
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "called"))
+}
+
+func TestDockerCredentialProvider(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	// The credential helper returns the server address as username.
+	helper := fmt.Sprintf(`#!/bin/sh
+echo called >> %s
+read server
+echo "{\"ServerURL\":\"$server\",\"Username\":\"$server\",\"Secret\":\"pass\"}"
+`, calls)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(helper), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	configDir := filepath.Join(dir, "docker")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	configPath := filepath.Join(configDir, "config.json")
+	writeDockerConfig(t, configPath, "hub.harbor.com", "user", "old", time.Now().Add(-time.Hour))
+	provider := NewDockerCredentialProvider(configDir, "")
+
+	cred, err := provider.Credential("hub.harbor.com")
+	require.NoError(t, err)
+	require.Equal(t, &Credential{Username: "user", Password: "old"}, cred)
+
+	// The modified config is re-read.
+	writeDockerConfig(t, configPath, "hub.harbor.com", "user", "new", time.Now())
+	cred, err = provider.Credential("hub.harbor.com")
+	require.NoError(t, err)
+	require.Equal(t, &Credential{Username: "user", Password: "new"}, cred)
+
+	// The credential of helper is cached.
+	provider = NewDockerCredentialProvider(configDir, "test")
+	for i := 0; i < 2; i++ {
+		cred, err = provider.Credential("hub.harbor.com")
+		require.NoError(t, err)
+		require.Equal(t, &Credential{Username: "hub.harbor.com", Password: "pass"}, cred)
+	}
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "called"))
+}
+
+func TestTokenAuthorizer(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://hub.harbor.com/v2/", nil)
+	require.NoError(t, err)
+	authorizer := newAuthorizer(http.DefaultClient, NewTokenCredentialProvider("token", false))
+	require.NoError(t, authorizer.Authorize(context.Background(), req))
+	require.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	username, secret, err := authCreds(NewTokenCredentialProvider("token", true))("hub.harbor.com")
+	require.NoError(t, err)
+	require.Equal(t, "", username)
+	require.Equal(t, "token", secret)
+
+	// The basic auth provider isn't asked for the registry token.
+	calls := 0
+	authorizer = newAuthorizer(http.DefaultClient, NewFuncCredentialProvider(func(string) (string, string, error) {
+		calls++
+		return "user", "pass", nil
+	}))
+	req.Header.Del("Authorization")
+	require.NoError(t, authorizer.Authorize(context.Background(), req))
+	require.Empty(t, req.Header.Get("Authorization"))
+	require.Equal(t, 0, calls)
+}
@@ -0,0 +1,66 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/opencontainers/go-digest"
+)
+
+type ifMatchKey struct{}
+
+// WithIfMatch sets the digest of the tagged manifest expected to be overwritten
+// by the manifest push with context, the registry supporting conditional request
+// rejects the push with 412 Precondition Failed if the tag has been changed.
+func WithIfMatch(ctx context.Context, dgst digest.Digest) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, dgst)
+}
+
+// IsPreconditionFailed returns true if the error is caused by
+// the unmatched If-Match condition of request.
+func IsPreconditionFailed(err error) bool {
+	var statusErr remoteserrors.ErrUnexpectedStatus
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusPreconditionFailed
+}
+
+// ifMatchTransport adds If-Match header to the manifest push by tag.
+type ifMatchTransport struct {
+	base http.RoundTripper
+}
+
+func (t *ifMatchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	dgst, ok := req.Context().Value(ifMatchKey{}).(digest.Digest)
+	if ok && req.Method == http.MethodPut && isManifestTagPath(req.URL.Path) {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-Match", fmt.Sprintf("%q", dgst.String()))
+	}
+	return t.base.RoundTrip(req)
+}
+
+func isManifestTagPath(urlPath string) bool {
+	dir, object := path.Split(urlPath)
+	if !strings.HasSuffix(dir, "/manifests/") {
+		return false
+	}
+	_, err := digest.Parse(object)
+	return err != nil
+}
@@ -0,0 +1,56 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/labels"
+	"github.com/containerd/containerd/reference"
+)
+
+// AppendDistributionSource appends the repository of reference to the distribution
+// source label in labels, the blob with the label can be mounted from the repository
+// when pushing to another repository of the same registry.
+func AppendDistributionSource(labelMap map[string]string, ref string) (map[string]string, error) {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	// Keep consistent with docker.AppendDistributionSourceLabel of containerd.
+	u, err := url.Parse("dummy://" + refspec.Locator)
+	if err != nil {
+		return nil, err
+	}
+	repo := strings.TrimPrefix(u.Path, "/")
+	key := fmt.Sprintf("%s.%s", labels.LabelDistributionSource, u.Hostname())
+
+	newLabels := make(map[string]string, len(labelMap)+1)
+	for k, v := range labelMap {
+		newLabels[k] = v
+	}
+	if value := newLabels[key]; value != "" {
+		for _, existing := range strings.Split(value, ",") {
+			if existing == repo {
+				return newLabels, nil
+			}
+		}
+		repo = value + "," + repo
+	}
+	newLabels[key] = repo
+	return newLabels, nil
+}
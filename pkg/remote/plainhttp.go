@@ -0,0 +1,92 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/pkg/errors"
+)
+
+// DefaultPlainHTTPFallbackTTL is the default duration to remember the
+// fallback to plain HTTP of registry host.
+const DefaultPlainHTTPFallbackTTL = 10 * time.Minute
+
+// PlainHTTPFallback remembers the registry hosts fallen back to plain HTTP
+// after the HTTPS request failed, the fallback expires after the TTL, so
+// that HTTPS is tried again in case the registry enables it later.
+type PlainHTTPFallback struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	expires map[string]time.Time
+	now     func() time.Time
+}
+
+// NewPlainHTTPFallback creates the plain HTTP fallback, the default TTL is
+// used if ttl isn't positive.
+func NewPlainHTTPFallback(ttl time.Duration) *PlainHTTPFallback {
+	if ttl <= 0 {
+		ttl = DefaultPlainHTTPFallbackTTL
+	}
+	return &PlainHTTPFallback{
+		ttl:     ttl,
+		expires: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// SetClock replaces the clock checking the expiration of fallback, it's
+// used to expire the fallback in tests without waiting for the TTL.
+func (fallback *PlainHTTPFallback) SetClock(now func() time.Time) {
+	fallback.mutex.Lock()
+	defer fallback.mutex.Unlock()
+	fallback.now = now
+}
+
+// Fallback uses plain HTTP for the registry host of image reference.
+func (fallback *PlainHTTPFallback) Fallback(ref string) error {
+	refspec, err := reference.Parse(ref)
+	if err != nil {
+		return errors.Wrap(err, "parse reference")
+	}
+
+	fallback.mutex.Lock()
+	defer fallback.mutex.Unlock()
+	fallback.expires[refspec.Hostname()] = fallback.now().Add(fallback.ttl)
+
+	return nil
+}
+
+// Enabled returns true if the host has fallen back to plain HTTP.
+func (fallback *PlainHTTPFallback) Enabled(host string) bool {
+	if fallback == nil {
+		return false
+	}
+
+	fallback.mutex.Lock()
+	defer fallback.mutex.Unlock()
+
+	expire, ok := fallback.expires[host]
+	if !ok {
+		return false
+	}
+	if fallback.now().After(expire) {
+		delete(fallback.expires, host)
+		return false
+	}
+	return true
+}
@@ -41,30 +41,21 @@ const maxRetry = 3
 
 // Modified from containerd project, copyright The containerd Authors.
 // https://github.com/containerd/containerd/remotes/docker/fetcher.go
-func Fetch(ctx context.Context, cacheRef string, desc ocispec.Descriptor, host HostFunc, plainHTTP bool) (content.ReaderAt, error) {
+func Fetch(ctx context.Context, cacheRef string, desc ocispec.Descriptor, host HostFunc, fallback *PlainHTTPFallback) (content.ReaderAt, error) {
 	ctx = log.WithLogger(ctx, log.G(ctx).WithField("digest", desc.Digest))
 
 	refspec, err := reference.Parse(cacheRef)
 	if err != nil {
 		return nil, err
 	}
-	cred, insecure, err := host(cacheRef)
+	hostConfig, err := host(cacheRef)
 	if err != nil {
 		return nil, err
 	}
+	// The cache repository is written by acceld, use the push credential.
+	hostConfig = hostConfig.For(OperationPush)
 
-	registryHosts := docker.ConfigureDefaultRegistries(
-		docker.WithAuthorizer(
-			docker.NewDockerAuthorizer(
-				docker.WithAuthClient(newDefaultClient(insecure)),
-				docker.WithAuthCreds(cred),
-			),
-		),
-		docker.WithClient(newDefaultClient(insecure)),
-		docker.WithPlainHTTP(func(host string) (bool, error) {
-			return plainHTTP, nil
-		}),
-	)
+	registryHosts := registryHosts(hostConfig, fallback)
 	hosts, err := registryHosts(refspec.Hostname())
 	if err != nil {
 		return nil, err
@@ -190,8 +181,11 @@ func Fetch(ctx context.Context, cacheRef string, desc ocispec.Descriptor, host H
 	})
 	// try to use open to trigger http request
 	if _, err = hrs.open(0); err != nil {
-		if acceldErrdefs.NeedsRetryWithHTTP(err) {
-			return Fetch(ctx, cacheRef, desc, host, true)
+		if acceldErrdefs.NeedsRetryWithHTTP(err) && fallback != nil && !hostConfig.PlainHTTP && !fallback.Enabled(refspec.Hostname()) {
+			if err := fallback.Fallback(cacheRef); err != nil {
+				return nil, err
+			}
+			return Fetch(ctx, cacheRef, desc, host, fallback)
 		}
 	}
 	return hrs, nil
@@ -0,0 +1,394 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registrytest provides an in-process registry implementing the
+// subset of OCI distribution API used by acceld, for testing only.
+package registrytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+// Registry is an in-process registry, a blob is only visible in the
+// repositories it's pushed or mounted to.
+type Registry struct {
+	*httptest.Server
+
+	// IfMatch enables the conditional manifest push, the push by tag with
+	// If-Match header is rejected if the tag points to another manifest.
+	IfMatch bool
+	// ReadOnly rejects the blob upload and manifest push with 403.
+	ReadOnly bool
+	// OnRequest is called before handling each request if not nil.
+	OnRequest func(req *http.Request)
+
+	mutex sync.Mutex
+	blobs map[digest.Digest][]byte
+	// repoBlobs records the blobs in each repository.
+	repoBlobs map[string]map[digest.Digest]bool
+	manifests map[digest.Digest]manifest
+	// tags maps "<repository>:<tag>" to manifest digest.
+	tags    map[string]digest.Digest
+	uploads map[string][]byte
+	nextID  int
+}
+
+// New starts an in-process registry serving plain HTTP, it should
+// be closed by caller.
+func New() *Registry {
+	registry := newRegistry()
+	registry.Server = httptest.NewServer(registry)
+	return registry
+}
+
+// NewTLS starts an in-process registry serving HTTPS with a self-signed
+// certificate, it should be closed by caller.
+func NewTLS() *Registry {
+	registry := newRegistry()
+	registry.Server = httptest.NewTLSServer(registry)
+	return registry
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		blobs:     make(map[digest.Digest][]byte),
+		repoBlobs: make(map[string]map[digest.Digest]bool),
+		manifests: make(map[digest.Digest]manifest),
+		tags:      make(map[string]digest.Digest),
+		uploads:   make(map[string][]byte),
+	}
+}
+
+// Host returns the host of registry used in image reference.
+func (registry *Registry) Host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(registry.URL, "http://"), "https://")
+}
+
+// Tag returns the manifest digest of the tag in repository.
+func (registry *Registry) Tag(repository, tag string) (digest.Digest, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	dgst, ok := registry.tags[repository+":"+tag]
+	return dgst, ok
+}
+
+// Uploads returns the number of unfinished blob uploads.
+func (registry *Registry) Uploads() int {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return len(registry.uploads)
+}
+
+// DeleteBlob deletes the blob from registry.
+func (registry *Registry) DeleteBlob(dgst digest.Digest) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	delete(registry.blobs, dgst)
+	for _, blobs := range registry.repoBlobs {
+		delete(blobs, dgst)
+	}
+}
+
+// HasBlob returns true if the blob exists in repository.
+func (registry *Registry) HasBlob(repository string, dgst digest.Digest) bool {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	return registry.repoBlobs[repository][dgst]
+}
+
+func (registry *Registry) addBlob(repository string, dgst digest.Digest) {
+	if registry.repoBlobs[repository] == nil {
+		registry.repoBlobs[repository] = make(map[digest.Digest]bool)
+	}
+	registry.repoBlobs[repository][dgst] = true
+}
+
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if registry.OnRequest != nil {
+		registry.OnRequest(req)
+	}
+
+	urlPath := req.URL.Path
+	if urlPath == "/v2/" || urlPath == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(urlPath, "/v2/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	urlPath = strings.TrimPrefix(urlPath, "/v2/")
+
+	if urlPath == "_catalog" {
+		registry.serveCatalog(w, req)
+		return
+	}
+	if repository := strings.TrimSuffix(urlPath, "/tags/list"); repository != urlPath {
+		registry.serveTags(w, req, repository)
+		return
+	}
+	if idx := strings.LastIndex(urlPath, "/blobs/uploads/"); idx >= 0 {
+		registry.serveUpload(w, req, urlPath[:idx], strings.TrimPrefix(urlPath[idx:], "/blobs/uploads/"))
+		return
+	}
+	if idx := strings.LastIndex(urlPath, "/blobs/"); idx >= 0 {
+		registry.serveBlob(w, req, urlPath[:idx], strings.TrimPrefix(urlPath[idx:], "/blobs/"))
+		return
+	}
+	if idx := strings.LastIndex(urlPath, "/manifests/"); idx >= 0 {
+		registry.serveManifest(w, req, urlPath[:idx], strings.TrimPrefix(urlPath[idx:], "/manifests/"))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (registry *Registry) serveBlob(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	registry.mutex.Lock()
+	data := registry.blobs[digest.Digest(reference)]
+	ok := registry.repoBlobs[repository][digest.Digest(reference)]
+	registry.mutex.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodHead, http.MethodGet:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", reference)
+		status := http.StatusOK
+		// Supports the range request of "bytes=<offset>-" form.
+		if offset, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(req.Header.Get("Range"), "bytes="), "-")); err == nil && offset > 0 && offset < len(data) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(data)-1, len(data)))
+			data = data[offset:]
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (registry *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repository, id string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if registry.ReadOnly {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		if mount := digest.Digest(req.URL.Query().Get("mount")); mount != "" {
+			if registry.repoBlobs[req.URL.Query().Get("from")][mount] {
+				registry.addBlob(repository, mount)
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, mount))
+				w.Header().Set("Docker-Content-Digest", mount.String())
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		registry.nextID++
+		id := strconv.Itoa(registry.nextID)
+		registry.uploads[id] = []byte{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch, http.MethodPut:
+		data, ok := registry.uploads[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data = append(data, body...)
+		if req.Method == http.MethodPatch {
+			registry.uploads[id] = data
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(data)-1))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		delete(registry.uploads, id)
+		dgst := digest.FromBytes(data)
+		if expected := req.URL.Query().Get("digest"); expected != dgst.String() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		registry.blobs[dgst] = data
+		registry.addBlob(repository, dgst)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := registry.uploads[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(registry.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (registry *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	dgst, err := digest.Parse(reference)
+	isTag := err != nil
+	if isTag {
+		dgst = registry.tags[repository+":"+reference]
+	}
+
+	switch req.Method {
+	case http.MethodHead, http.MethodGet:
+		mani, ok := registry.manifests[dgst]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mani.mediaType)
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.Header().Set("Etag", fmt.Sprintf("%q", dgst.String()))
+		w.Header().Set("Content-Length", strconv.Itoa(len(mani.data)))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(mani.data)
+		}
+	case http.MethodPut:
+		if registry.ReadOnly {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if ifMatch := req.Header.Get("If-Match"); registry.IfMatch && isTag && ifMatch != "" {
+			if ifMatch != fmt.Sprintf("%q", dgst.String()) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		data, err := io.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		newDgst := digest.FromBytes(data)
+		if !isTag && newDgst != dgst {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		registry.manifests[newDgst] = manifest{
+			mediaType: req.Header.Get("Content-Type"),
+			data:      data,
+		}
+		if isTag {
+			registry.tags[repository+":"+reference] = newDgst
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repository, newDgst))
+		w.Header().Set("Docker-Content-Digest", newDgst.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (registry *Registry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	registry.mutex.Lock()
+	seen := make(map[string]bool)
+	repositories := []string{}
+	for key := range registry.tags {
+		repository := key[:strings.LastIndex(key, ":")]
+		if !seen[repository] {
+			seen[repository] = true
+			repositories = append(repositories, repository)
+		}
+	}
+	registry.mutex.Unlock()
+
+	page, next := paginate(req, repositories)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%s>; rel="next"`, next, req.URL.Query().Get("n")))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"repositories": page,
+	})
+}
+
+func (registry *Registry) serveTags(w http.ResponseWriter, req *http.Request, repository string) {
+	registry.mutex.Lock()
+	tags := []string{}
+	for key := range registry.tags {
+		if idx := strings.LastIndex(key, ":"); key[:idx] == repository {
+			tags = append(tags, key[idx+1:])
+		}
+	}
+	registry.mutex.Unlock()
+	if len(tags) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	page, next := paginate(req, tags)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%s>; rel="next"`, repository, next, req.URL.Query().Get("n")))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"name": repository,
+		"tags": page,
+	})
+}
+
+// paginate returns the sorted entries after the `last` query parameter,
+// at most `n` entries, and the last entry if there are more.
+func paginate(req *http.Request, entries []string) ([]string, string) {
+	sort.Strings(entries)
+	if last := req.URL.Query().Get("last"); last != "" {
+		entries = entries[sort.SearchStrings(entries, last):]
+		if len(entries) > 0 && entries[0] == last {
+			entries = entries[1:]
+		}
+	}
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || n <= 0 || n >= len(entries) {
+		return entries, ""
+	}
+	return entries[:n], entries[n-1]
+}
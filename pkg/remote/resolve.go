@@ -26,9 +26,9 @@ import (
 	dockerconfig "github.com/docker/cli/cli/config"
 )
 
-func newDefaultClient(skipTLSVerify bool) *http.Client {
+func newDefaultClient(skipTLSVerify bool, tlsConfig *TLSConfig) *http.Client {
 	return &http.Client{
-		Transport: &http.Transport{
+		Transport: &ifMatchTransport{base: &bandwidthTransport{base: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
@@ -41,10 +41,8 @@ func newDefaultClient(skipTLSVerify bool) *http.Client {
 			ExpectContinueTimeout: 5 * time.Second,
 			DisableKeepAlives:     true,
 			TLSNextProto:          make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipTLSVerify,
-			},
-		},
+			TLSClientConfig:       newTLSConfig(skipTLSVerify, tlsConfig),
+		}}},
 	}
 }
 
@@ -52,9 +50,66 @@ func newDefaultClient(skipTLSVerify bool) *http.Client {
 // username, password and error.
 type CredentialFunc = func(string) (string, string, error)
 
-// HostFunc  accepts host url parameter and returns with
-// CredentialFunc, insecure and error.
-type HostFunc = func(ref string) (CredentialFunc, bool, error)
+// HostConfig is the configuration of the registry host of image reference.
+type HostConfig struct {
+	// Credential is used for pulling from the registry.
+	Credential CredentialProvider
+	// PushCredential is used for pushing to the registry, the
+	// same as Credential if nil.
+	PushCredential CredentialProvider
+	// Insecure skips verifying the server certs of HTTPS registry.
+	Insecure bool
+	// PlainHTTP always uses plain HTTP to communicate with registry.
+	PlainHTTP bool
+	// TLS is the custom TLS configuration of registry, nil uses the system CAs.
+	TLS *TLSConfig
+	// Retry is the max number of retries of failed layer download.
+	Retry int
+	// Mirrors are tried in order before the origin registry for pulling.
+	Mirrors []Mirror
+}
+
+// Operation is the registry operation choosing the credential.
+type Operation string
+
+const (
+	OperationPull Operation = "pull"
+	OperationPush Operation = "push"
+)
+
+// For returns the host configuration using the credential of operation, the
+// mirrors are dropped for pushing, so that the resolving before and after push,
+// like the remote cache update, always reads the latest state from origin.
+func (config *HostConfig) For(op Operation) *HostConfig {
+	if op != OperationPush {
+		return config
+	}
+	pushConfig := *config
+	pushConfig.Mirrors = nil
+	if config.PushCredential != nil {
+		pushConfig.Credential = config.PushCredential
+	}
+	return &pushConfig
+}
+
+// Mirror is a pull-only endpoint of the registry host, like the
+// mirror host of containerd `hosts.toml`.
+type Mirror struct {
+	// Scheme is `http` or `https`.
+	Scheme string
+	// Host is the host[:port] of mirror.
+	Host string
+	// Path is the API path of mirror like `/v2`.
+	Path       string
+	Credential CredentialProvider
+	// Insecure skips verifying the server certs of HTTPS mirror.
+	Insecure bool
+	TLS      *TLSConfig
+}
+
+// HostFunc accepts image reference parameter and returns with
+// the host configuration and error.
+type HostFunc = func(ref string) (*HostConfig, error)
 
 // NewDockerConfigCredFunc attempts to read docker auth config file `$DOCKER_CONFIG/config.json`
 // to communicate with remote registry, `$DOCKER_CONFIG` defaults to `~/.docker`.
@@ -78,20 +133,56 @@ func NewDockerConfigCredFunc() CredentialFunc {
 }
 
 func NewResolver(insecure, plainHTTP bool, credFunc CredentialFunc) remotes.Resolver {
-	registryHosts := docker.ConfigureDefaultRegistries(
+	return NewHostResolver(&HostConfig{
+		Credential: NewFuncCredentialProvider(credFunc),
+		Insecure:   insecure,
+		PlainHTTP:  plainHTTP,
+	}, nil, nil)
+}
+
+// NewHostResolver creates a resolver of the registry host configuration, the
+// mirrors are tried in order before the origin registry for pulling. The push
+// status is tracked by tracker, an in-memory tracker is used if tracker is nil.
+// The registry uses plain HTTP if configured, or it has fallen back to plain
+// HTTP in fallback.
+func NewHostResolver(hostConfig *HostConfig, fallback *PlainHTTPFallback, tracker docker.StatusTracker) remotes.Resolver {
+	return docker.NewResolver(docker.ResolverOptions{
+		Hosts:   registryHosts(hostConfig, fallback),
+		Tracker: tracker,
+	})
+}
+
+func registryHosts(hostConfig *HostConfig, fallback *PlainHTTPFallback) docker.RegistryHosts {
+	origin := docker.ConfigureDefaultRegistries(
 		docker.WithAuthorizer(
-			docker.NewDockerAuthorizer(
-				docker.WithAuthClient(newDefaultClient(insecure)),
-				docker.WithAuthCreds(credFunc),
-			),
+			newAuthorizer(newDefaultClient(hostConfig.Insecure, hostConfig.TLS), hostConfig.Credential),
 		),
-		docker.WithClient(newDefaultClient(insecure)),
+		docker.WithClient(newDefaultClient(hostConfig.Insecure, hostConfig.TLS)),
 		docker.WithPlainHTTP(func(host string) (bool, error) {
-			return plainHTTP, nil
+			return hostConfig.PlainHTTP || fallback.Enabled(host), nil
 		}),
 	)
+	if len(hostConfig.Mirrors) == 0 {
+		return origin
+	}
 
-	return docker.NewResolver(docker.ResolverOptions{
-		Hosts: registryHosts,
-	})
+	return func(host string) ([]docker.RegistryHost, error) {
+		originHosts, err := origin(host)
+		if err != nil {
+			return nil, err
+		}
+		hosts := []docker.RegistryHost{}
+		for _, mirror := range hostConfig.Mirrors {
+			client := newDefaultClient(mirror.Insecure, mirror.TLS)
+			hosts = append(hosts, docker.RegistryHost{
+				Client:       client,
+				Authorizer:   newAuthorizer(client, mirror.Credential),
+				Host:         mirror.Host,
+				Scheme:       mirror.Scheme,
+				Path:         mirror.Path,
+				Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve,
+			})
+		}
+		return append(hosts, originHosts...), nil
+	}
 }
@@ -0,0 +1,166 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig is the TLS configuration of registry host. The files are reloaded
+// once modified, so the rotated certificates take effect without restart.
+type TLSConfig struct {
+	// CAFile is the PEM encoded CA bundle trusted in addition to the system CAs.
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key for mTLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the server name used to verify the server certs.
+	ServerName string
+}
+
+// newTLSConfig creates the client TLS config, the server certs aren't verified
+// if insecure is true.
+func newTLSConfig(insecure bool, config *TLSConfig) *tls.Config {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if config == nil {
+		return tlsConfig
+	}
+
+	tlsConfig.ServerName = config.ServerName
+	if config.CertFile != "" && config.KeyFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return loadKeyPair(config.CertFile, config.KeyFile)
+		}
+	}
+	if config.CAFile != "" && !insecure {
+		// The server certs are verified in VerifyConnection by the reloaded
+		// CA bundle instead of the static RootCAs.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyConnection(state, config.CAFile)
+		}
+	}
+
+	return tlsConfig
+}
+
+func verifyConnection(state tls.ConnectionState, caFile string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+	roots, err := loadCertPool(caFile)
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       state.ServerName,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// loadedFiles caches the objects loaded from files, the object is reloaded
+// if any of its files is modified.
+var loadedFiles = &fileCache{entries: make(map[string]fileCacheEntry)}
+
+type fileCacheEntry struct {
+	stamp string
+	value interface{}
+}
+
+type fileCache struct {
+	mutex   sync.Mutex
+	entries map[string]fileCacheEntry
+}
+
+func (cache *fileCache) load(load func(data [][]byte) (interface{}, error), paths ...string) (interface{}, error) {
+	stamps := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stat %s", path)
+		}
+		stamps = append(stamps, fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	key := strings.Join(paths, "\x00")
+	stamp := strings.Join(stamps, ",")
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if entry, ok := cache.entries[key]; ok && entry.stamp == stamp {
+		return entry.value, nil
+	}
+	data := [][]byte{}
+	for _, path := range paths {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", path)
+		}
+		data = append(data, bytes)
+	}
+	value, err := load(data)
+	if err != nil {
+		return nil, err
+	}
+	cache.entries[key] = fileCacheEntry{stamp: stamp, value: value}
+
+	return value, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pool, err := loadedFiles.load(func(data [][]byte) (interface{}, error) {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data[0]) {
+			return nil, fmt.Errorf("no valid certificate in %s", caFile)
+		}
+		return pool, nil
+	}, caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load CA file")
+	}
+	return pool.(*x509.CertPool), nil
+}
+
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := loadedFiles.load(func(data [][]byte) (interface{}, error) {
+		cert, err := tls.X509KeyPair(data[0], data[1])
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load client certificate")
+	}
+	return cert.(*tls.Certificate), nil
+}
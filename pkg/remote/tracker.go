@@ -0,0 +1,64 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+type pushStatsKey struct{}
+
+// PushStats collects the statistics of the pushes with context.
+type PushStats struct {
+	mountedBytes int64
+}
+
+// MountedBytes returns the total size of blobs cross-repo mounted
+// instead of uploaded.
+func (stats *PushStats) MountedBytes() int64 {
+	return atomic.LoadInt64(&stats.mountedBytes)
+}
+
+func WithPushStats(ctx context.Context) (context.Context, *PushStats) {
+	stats := &PushStats{}
+	return context.WithValue(ctx, pushStatsKey{}, stats), stats
+}
+
+// mountTracker counts the mounted blobs in push stats.
+type mountTracker struct {
+	docker.StatusTracker
+	stats *PushStats
+}
+
+// NewStatusTracker creates a push status tracker, the mounted blobs
+// are counted if there is push stats in context.
+func NewStatusTracker(ctx context.Context) docker.StatusTracker {
+	tracker := docker.NewInMemoryTracker()
+	stats, ok := ctx.Value(pushStatsKey{}).(*PushStats)
+	if !ok {
+		return tracker
+	}
+	return &mountTracker{StatusTracker: tracker, stats: stats}
+}
+
+func (tracker *mountTracker) SetStatus(ref string, status docker.Status) {
+	if status.Committed && status.MountedFrom != "" {
+		atomic.AddInt64(&tracker.stats.mountedBytes, status.Total)
+	}
+	tracker.StatusTracker.SetStatus(ref, status)
+}
@@ -0,0 +1,66 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/model"
+	"github.com/goharbor/acceleration-service/pkg/server/util"
+)
+
+func (r *LocalRouter) CreateBackfill(ctx echo.Context) error {
+	req := new(model.BackfillRequest)
+	if err := ctx.Bind(req); err != nil {
+		return util.ReplyError(
+			ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+			"invalid backfill request",
+		)
+	}
+
+	auth := ctx.Request().Header.Get(echo.HeaderAuthorization)
+	if err := r.handler.Auth(ctx.Request().Context(), req.Registry, auth); err != nil {
+		logger.WithError(err).Errorf("failed to authenticate for host %s", req.Registry)
+		return util.ReplyError(
+			ctx, http.StatusUnauthorized, errdefs.ErrUnauthorized,
+			"invalid auth config",
+		)
+	}
+
+	job, err := r.handler.Backfill(ctx.Request().Context(), *req)
+	if err != nil {
+		logger.WithError(err).Errorf("failed to create backfill job")
+		if errors.Is(err, errdefs.ErrIllegalParameter) {
+			return util.ReplyError(
+				ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+				err.Error(),
+			)
+		}
+		return util.ReplyError(
+			ctx, http.StatusInternalServerError, errdefs.ErrBackfillFailed,
+			err.Error(),
+		)
+	}
+
+	return ctx.JSON(http.StatusOK, job)
+}
+
+func (r *LocalRouter) ListBackfills(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, r.handler.ListBackfills(ctx.Request().Context()))
+}
@@ -0,0 +1,45 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
+	"github.com/goharbor/acceleration-service/pkg/server/util"
+)
+
+func (r *LocalRouter) BuildChunkDict(ctx echo.Context) error {
+	repository := ctx.QueryParam("repository")
+
+	if err := r.handler.BuildChunkDict(ctx.Request().Context(), repository); err != nil {
+		logger.WithError(err).Errorf("failed to build chunk dict")
+		if errors.Is(err, errdefs.ErrIllegalParameter) {
+			return util.ReplyError(
+				ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+				err.Error(),
+			)
+		}
+		return util.ReplyError(
+			ctx, http.StatusInternalServerError, errdefs.ErrChunkDictFailed,
+			err.Error(),
+		)
+	}
+
+	return ctx.JSON(http.StatusOK, "Ok")
+}
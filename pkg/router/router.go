@@ -45,6 +45,9 @@ func (router *LocalRouter) Register(server *echo.Echo) error {
 	server.POST("/api/v1/conversions", router.CreateTask)
 	server.GET("/api/v1/conversions", router.ListTask)
 	server.GET("/api/v1/health", router.CheckHealth)
+	server.POST("/api/v1/chunkdicts", router.BuildChunkDict)
+	server.POST("/api/v1/backfills", router.CreateBackfill)
+	server.GET("/api/v1/backfills", router.ListBackfills)
 
 	// Any unexpected endpoint will return an error.
 	server.Any("*", func(ctx echo.Context) error {
@@ -15,10 +15,13 @@
 package router
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/goharbor/acceleration-service/pkg/converter"
 	"github.com/goharbor/acceleration-service/pkg/model"
 	"github.com/labstack/echo/v4"
 
@@ -30,8 +33,9 @@ func (r *LocalRouter) CreateTask(ctx echo.Context) error {
 	logger.Infof("received webhook request from %s", ctx.Request().RemoteAddr)
 
 	sync, _ := strconv.ParseBool(ctx.QueryParam("sync"))
+	dryRun, _ := strconv.ParseBool(ctx.QueryParam("dry_run"))
 
-	payload := new(model.Payload)
+	payload := new(model.ConversionPayload)
 	if err := ctx.Bind(payload); err != nil {
 		logger.Errorf("invalid webhook payload")
 		return util.ReplyError(
@@ -45,6 +49,13 @@ func (r *LocalRouter) CreateTask(ctx echo.Context) error {
 		return ctx.JSON(http.StatusOK, "Ok")
 	}
 
+	if payload.Overrides != nil && payload.Overrides.Target != "" && payload.EventData != nil && len(payload.EventData.Resources) > 1 {
+		return util.ReplyError(
+			ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+			"target override is only allowed for single resource",
+		)
+	}
+
 	auth := ctx.Request().Header.Get(echo.HeaderAuthorization)
 	for _, res := range payload.EventData.Resources {
 		url, err := url.Parse("dummy://" + res.ResourceURL)
@@ -65,14 +76,65 @@ func (r *LocalRouter) CreateTask(ctx echo.Context) error {
 		}
 	}
 
+	// The target host is authenticated as well, so that the caller can't
+	// push to a host it isn't allowed to.
+	if payload.Overrides != nil && payload.Overrides.Target != "" {
+		url, err := url.Parse("dummy://" + payload.Overrides.Target)
+		if err != nil {
+			return util.ReplyError(
+				ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+				"failed to parse target reference",
+			)
+		}
+		if err := r.handler.Auth(ctx.Request().Context(), url.Host, auth); err != nil {
+			logger.WithError(err).Errorf("failed to authenticate for target host %s", url.Host)
+			return util.ReplyError(
+				ctx, http.StatusUnauthorized, errdefs.ErrUnauthorized,
+				"invalid auth config",
+			)
+		}
+	}
+
+	if dryRun {
+		plans := []*converter.Plan{}
+		for _, res := range payload.EventData.Resources {
+			plan, err := r.handler.Plan(ctx.Request().Context(), res.ResourceURL, payload.Overrides)
+			if err != nil {
+				if errors.Is(err, errdefs.ErrIllegalParameter) {
+					return util.ReplyError(
+						ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+						err.Error(),
+					)
+				}
+				return util.ReplyError(
+					ctx, http.StatusInternalServerError, errdefs.ErrConvertFailed,
+					err.Error(),
+				)
+			}
+			plans = append(plans, plan)
+		}
+		return ctx.JSON(http.StatusOK, plans)
+	}
+
+	taskIDs := []string{}
 	for _, res := range payload.EventData.Resources {
-		if err := r.handler.Convert(ctx.Request().Context(), res.ResourceURL, sync); err != nil {
+		taskID, err := r.handler.Convert(ctx.Request().Context(), res.ResourceURL, sync, payload.Overrides)
+		if err != nil {
+			if errors.Is(err, errdefs.ErrIllegalParameter) {
+				return util.ReplyError(
+					ctx, http.StatusBadRequest, errdefs.ErrIllegalParameter,
+					err.Error(),
+				)
+			}
 			return util.ReplyError(
 				ctx, http.StatusInternalServerError, errdefs.ErrConvertFailed,
 				err.Error(),
 			)
 		}
+		taskIDs = append(taskIDs, taskID)
 	}
 
+	// The IDs of created tasks are responded in header for compatibility.
+	ctx.Response().Header().Set(util.HeaderTaskID, strings.Join(taskIDs, ","))
 	return ctx.JSON(http.StatusOK, "Ok")
 }
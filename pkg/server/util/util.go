@@ -16,6 +16,10 @@ package util
 
 import "github.com/labstack/echo/v4"
 
+// HeaderTaskID is the response header of task creation, it has the IDs of
+// created tasks separated by comma.
+const HeaderTaskID = "X-Task-Id"
+
 type ErrorResp struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/goharbor/acceleration-service/pkg/converter"
+	"github.com/goharbor/acceleration-service/pkg/errdefs"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
@@ -43,6 +44,7 @@ type Task struct {
 	SourceSize uint      `json:"source_size"`
 	TargetSize uint      `json:"target_size"`
 	Status     string    `json:"status"`
+	Code       string    `json:"code,omitempty"`
 	Reason     string    `json:"reason"`
 }
 
@@ -163,6 +165,10 @@ func (m *manager) Finish(id string, metric *converter.Metric, err error) error {
 	if task != nil {
 		if err != nil {
 			task.Status = StatusFailed
+			task.Code = errdefs.ErrConvertFailed.Error()
+			if errors.Is(err, errdefs.ErrVerifyFailed) {
+				task.Code = errdefs.ErrVerifyFailed.Error()
+			}
 			task.Reason = err.Error()
 		} else {
 			task.Status = StatusCompleted
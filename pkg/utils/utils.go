@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"runtime/debug"
 
 	"github.com/containerd/containerd/content"
 	"github.com/containerd/containerd/images"
@@ -114,3 +115,22 @@ func UpdateLayerDiffID(ctx context.Context, cs content.Store, image ocispec.Desc
 
 	return nil
 }
+
+// ModuleVersion returns the version of the Go module linked into the binary,
+// or "unknown" if the build info isn't available.
+func ModuleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != path {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		return dep.Version
+	}
+	return "unknown"
+}